@@ -0,0 +1,33 @@
+package bugsnag
+
+// AppPhase is the stage of its lifecycle the application is in, which is
+// reported with each event so that errors during startup or shutdown can be
+// told apart from those while it's serving traffic.
+type AppPhase string
+
+// The phases of the application's lifecycle.
+const (
+	// AppPhaseStartup is while the application is initializing, e.g.
+	// connecting to its database or loading configuration.
+	AppPhaseStartup AppPhase = "startup"
+	// AppPhaseRunning is while the application is doing its usual work.
+	AppPhaseRunning AppPhase = "running"
+	// AppPhaseStopping is while the application is shutting down. OnShutdown
+	// sets this automatically.
+	AppPhaseStopping AppPhase = "stopping"
+)
+
+// appPhaseTab is the meta-data tab the AppPhase is added to. It's shown as a
+// tab of its own, separately from the app section of the event.
+const appPhaseTab = "app"
+
+// SetAppPhase sets the AppPhase of the global configuration, which is
+// reported with subsequent events. It's safe to call concurrently with
+// notifying, e.g. from a signal handler. Notifiers created with New before the
+// phase is set keep their own configuration, so set their Config.AppPhase
+// directly.
+func SetAppPhase(phase AppPhase) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	Config.AppPhase = phase
+}
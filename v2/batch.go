@@ -0,0 +1,239 @@
+package bugsnag
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBatchSize is the number of events which are sent together when
+// batching is enabled and Configuration.MaxBatchSize is not set.
+const DefaultMaxBatchSize = 50
+
+var batcher = new(eventBatcher)
+
+// Flush immediately sends any events which are waiting to be delivered as
+// part of a batch, along with the counts of any de-duplicated events, and
+// waits for them to be delivered. This is a no-op unless
+// Configuration.BatchWindow or Configuration.DedupWindow is set.
+func Flush() {
+	batcher.flush()
+	deduplicator.flush()
+}
+
+// eventBatcher accumulates asynchronous events so that they can be delivered
+// together, rather than opening a connection to Bugsnag for each event. Each
+// notifier's events are batched separately, using its own BatchWindow.
+type eventBatcher struct {
+	mutex   sync.Mutex
+	batches map[batchKey]*pendingBatch
+}
+
+// batchKey identifies the batch which an event is added to.
+type batchKey struct {
+	notifier *Notifier
+	window   time.Duration
+}
+
+// pendingBatch is the payloads waiting for the batch window to pass.
+type pendingBatch struct {
+	payloads []*payload
+	timer    *time.Timer
+}
+
+// add queues the payload for delivery, starting the batch window if this is
+// the first pending payload. Unhandled events are delivered immediately along
+// with anything already pending, to keep events in order. The error of the
+// delivery is returned for synchronous payloads which are delivered
+// immediately.
+func (b *eventBatcher) add(p *payload) error {
+	key := batchKey{notifier: p.Event.notifier, window: p.BatchWindow}
+	b.mutex.Lock()
+	if b.batches == nil {
+		b.batches = make(map[batchKey]*pendingBatch)
+	}
+	pending := b.batches[key]
+	if pending == nil {
+		pending = new(pendingBatch)
+		b.batches[key] = pending
+	}
+	pending.payloads = append(pending.payloads, p)
+	if p.Unhandled || len(pending.payloads) >= p.maxBatchSize() {
+		batch := b.take(key)
+		b.mutex.Unlock()
+		if p.Synchronous {
+			return deliverBatch(batch)
+		}
+		if !deliveries.run(p.Configuration, func() { logBatchError(batch, deliverBatch(batch)) }) {
+			for _, dropped := range batch {
+				dropped.dropped(dropped.Event, DropReasonDeliveryOverflow)
+			}
+			p.logf("bugsnag/eventBatcher.add: dropped %d events as %d deliveries are in progress", len(batch), p.MaxDeliveryConcurrency)
+		}
+		return nil
+	}
+	if pending.timer == nil {
+		pending.timer = time.AfterFunc(p.BatchWindow, func() { b.flushBatch(key) })
+	}
+	b.mutex.Unlock()
+	return nil
+}
+
+// flush delivers all pending payloads synchronously.
+func (b *eventBatcher) flush() {
+	b.mutex.Lock()
+	var batches [][]*payload
+	for key := range b.batches {
+		batches = append(batches, b.take(key))
+	}
+	b.mutex.Unlock()
+	for _, batch := range batches {
+		logBatchError(batch, deliverBatch(batch))
+	}
+}
+
+// flushBatch delivers the pending payloads of one batch synchronously, once
+// its batch window has passed.
+func (b *eventBatcher) flushBatch(key batchKey) {
+	b.mutex.Lock()
+	batch := b.take(key)
+	b.mutex.Unlock()
+	logBatchError(batch, deliverBatch(batch))
+}
+
+// take removes and returns the pending payloads of a batch. Callers must hold
+// the mutex.
+func (b *eventBatcher) take(key batchKey) []*payload {
+	pending := b.batches[key]
+	if pending == nil {
+		return nil
+	}
+	if pending.timer != nil {
+		pending.timer.Stop()
+	}
+	delete(b.batches, key)
+	return pending.payloads
+}
+
+// newReport builds the report with the payloads as its events, using the
+// configuration of the first payload. The ExcludeMetaDataTabs are left out
+// only if excludeTabs is set, as dry runs log everything which was collected,
+// and the events are counted against their sessions if countSessions is set.
+func newReport(payloads []*payload, excludeTabs, countSessions bool) reportJSON {
+	events := make([]eventJSON, len(payloads))
+	for i, p := range payloads {
+		events[i] = p.eventJSON(countSessions)
+		if excludeTabs {
+			events[i].Metadata = p.withoutExcludedTabs(events[i].Metadata)
+		}
+	}
+	return reportJSON{
+		APIKey:   payloads[0].APIKey,
+		Events:   events,
+		Notifier: payloads[0].notifier(),
+	}
+}
+
+// marshalReport converts the payloads into the JSON of the report which is
+// sent to Bugsnag, before it is encoded by any PayloadEncoder. The events are
+// counted against their sessions if countSessions is set, as when they're
+// being delivered.
+func marshalReport(payloads []*payload, countSessions bool) ([]byte, error) {
+	buf, err := json.Marshal(newReport(payloads, true, countSessions))
+	if err != nil {
+		return nil, fmt.Errorf("bugsnag/payload.deliver: %v", err)
+	}
+	if mapper := payloads[0].FieldNameMapper; mapper != nil {
+		return renameFields(buf, mapper)
+	}
+	return buf, nil
+}
+
+func (config *Configuration) maxBatchSize() int {
+	if config.MaxBatchSize > 0 {
+		return config.MaxBatchSize
+	}
+	return DefaultMaxBatchSize
+}
+
+// deliverBatch sends the payloads in as few requests as possible. Payloads
+// can only share a request when they are sent to the same project and
+// endpoint, so consecutive payloads are grouped to keep events in order.
+// Every request is attempted, and the first error is returned.
+func deliverBatch(batch []*payload) error {
+	var first error
+	for len(batch) > 0 {
+		n := 1
+		for n < len(batch) && batch[0].canShareRequest(batch[n]) {
+			n++
+		}
+		if err := deliverReport(batch[:n]); err != nil {
+			if first != nil {
+				batch[0].logf("bugsnag/deliverBatch: %v", err)
+			} else {
+				first = err
+			}
+		}
+		batch = batch[n:]
+	}
+	return first
+}
+
+// logBatchError logs the error of delivering a batch which has no caller to
+// return it to.
+func logBatchError(batch []*payload, err error) {
+	if err != nil {
+		batch[0].logf("bugsnag/deliverBatch: %v", err)
+	}
+}
+
+func (p *payload) canShareRequest(other *payload) bool {
+	return p.APIKey == other.APIKey &&
+		p.Endpoints.Notify == other.Endpoints.Notify &&
+		p.payloadVersion() == other.payloadVersion()
+}
+
+// deliverReport sends the payloads as the events of a single report, using
+// the configuration of the first payload for the request.
+func deliverReport(payloads []*payload) (err error) {
+	first := payloads[0]
+	start := time.Now()
+	kind := ErrorKindDelivery
+	defer func() {
+		first.metrics().Delivered(len(payloads), time.Since(start), err)
+		if err != nil {
+			first.reportError(err, kind)
+			for _, p := range payloads {
+				if p.OnEventDropped != nil {
+					p.OnEventDropped(p.Event, DropReasonDeliveryFailed)
+				}
+			}
+		}
+	}()
+	if !first.DryRun {
+		if err := first.Configuration.Validate(); err != nil {
+			return fmt.Errorf("bugsnag/payload.deliver: %v", err)
+		}
+	}
+	kind = ErrorKindMarshal
+	if first.DryRun {
+		buf, err := json.MarshalIndent(newReport(payloads, false, true), "", "  ")
+		if err != nil {
+			return fmt.Errorf("bugsnag/payload.deliver: %v", err)
+		}
+		first.logf("bugsnag/payload.deliver: dry run, not sending report:\n%s", buf)
+		return nil
+	}
+	buf, err := marshalReport(payloads, true)
+	if err != nil {
+		return err
+	}
+	if first.PayloadEncoder != nil {
+		if buf, err = first.PayloadEncoder(buf); err != nil {
+			return fmt.Errorf("bugsnag/payload.deliver: unable to encode report: %v", err)
+		}
+	}
+	kind = ErrorKindDelivery
+	return first.send(buf)
+}
@@ -0,0 +1,156 @@
+package bugsnag
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/bitly/go-simplejson"
+)
+
+func batchingNotifier(url string, maxBatchSize int) *Notifier {
+	config := generateSampleConfig(url)
+	config.BatchWindow = time.Hour
+	config.MaxBatchSize = maxBatchSize
+	return New(config)
+}
+
+func assertBatchMessages(t *testing.T, report []byte, exp ...string) {
+	json, err := simplejson.NewJson(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _ := json.Get("events").Array()
+	if len(events) != len(exp) {
+		t.Fatalf("Expected %d events in the batch but got %d", len(exp), len(events))
+	}
+	for i, message := range exp {
+		event := getIndex(json, "events", i)
+		if got := getString(getIndex(event, "exceptions", 0), "message"); got != message {
+			t.Errorf("Expected event %d to have message '%s' but was '%s'", i, message, got)
+		}
+	}
+}
+
+func TestBatchFlushesOnUnhandledEvent(t *testing.T) {
+	defer func(b *eventBatcher) { batcher = b }(batcher)
+	batcher = new(eventBatcher)
+	ts, reports := setup()
+	defer ts.Close()
+	notifier := batchingNotifier(ts.URL, 10)
+
+	notifier.Notify(fmt.Errorf("first"))
+	notifier.Notify(fmt.Errorf("second"))
+	select {
+	case <-reports:
+		t.Fatalf("Expected handled events to wait for the batch window")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	state := HandledState{SeverityReasonUnhandledPanic, SeverityError, true, ""}
+	notifier.NotifySync(fmt.Errorf("fatal"), true, state)
+
+	select {
+	case report := <-reports:
+		assertBatchMessages(t, report, "first", "second", "fatal")
+	default:
+		t.Fatalf("Expected unhandled event to be delivered immediately")
+	}
+}
+
+func TestBatchFlushesOnMaxBatchSize(t *testing.T) {
+	defer func(b *eventBatcher) { batcher = b }(batcher)
+	batcher = new(eventBatcher)
+	ts, reports := setup()
+	defer ts.Close()
+	notifier := batchingNotifier(ts.URL, 2)
+
+	notifier.Notify(fmt.Errorf("first"))
+	notifier.Notify(fmt.Errorf("second"))
+
+	assertBatchMessages(t, <-reports, "first", "second")
+}
+
+func TestBatchFlush(t *testing.T) {
+	defer func(b *eventBatcher) { batcher = b }(batcher)
+	batcher = new(eventBatcher)
+	ts, reports := setup()
+	defer ts.Close()
+	notifier := batchingNotifier(ts.URL, 10)
+
+	notifier.Notify(fmt.Errorf("first"))
+	Flush()
+
+	select {
+	case report := <-reports:
+		assertBatchMessages(t, report, "first")
+	default:
+		t.Fatalf("Expected Flush to deliver waiting events")
+	}
+}
+
+func TestBatchFlushesOnBatchWindow(t *testing.T) {
+	defer func(b *eventBatcher) { batcher = b }(batcher)
+	batcher = new(eventBatcher)
+	ts, reports := setup()
+	defer ts.Close()
+	config := generateSampleConfig(ts.URL)
+	config.BatchWindow = 10 * time.Millisecond
+	notifier := New(config)
+
+	notifier.Notify(fmt.Errorf("first"))
+	notifier.Notify(fmt.Errorf("second"))
+
+	select {
+	case report := <-reports:
+		assertBatchMessages(t, report, "first", "second")
+	case <-time.After(time.Second):
+		t.Fatalf("Expected events to be delivered once the batch window passed")
+	}
+}
+
+func TestBatchesAreKeptPerNotifier(t *testing.T) {
+	defer func(b *eventBatcher) { batcher = b }(batcher)
+	batcher = new(eventBatcher)
+	ts, reports := setup()
+	defer ts.Close()
+	config := generateSampleConfig(ts.URL)
+	config.BatchWindow = 10 * time.Millisecond
+	fast := New(config)
+	slow := batchingNotifier(ts.URL, 10)
+
+	slow.Notify(fmt.Errorf("slow"))
+	fast.Notify(fmt.Errorf("fast"))
+
+	select {
+	case report := <-reports:
+		assertBatchMessages(t, report, "fast")
+	case <-time.After(time.Second):
+		t.Fatalf("Expected events to be delivered once their notifier's batch window passed")
+	}
+	Flush()
+	assertBatchMessages(t, <-reports, "slow")
+}
+
+func TestBatchReturnsSynchronousDeliveryError(t *testing.T) {
+	defer func(b *eventBatcher) { batcher = b }(batcher)
+	batcher = new(eventBatcher)
+	resetCircuitBreakers(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	notifier := batchingNotifier(ts.URL, 10)
+	notifier.Config.Synchronous = true
+	notifier.Config.Logger = log.New(ioutil.Discard, "", 0)
+
+	state := HandledState{SeverityReason: SeverityReasonUnhandledPanic, OriginalSeverity: SeverityError, Unhandled: true}
+	if err := notifier.NotifySync(fmt.Errorf("fatal"), true, state); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Expected the delivery error to be returned for a synchronous unhandled event but got '%v'", err)
+	}
+}
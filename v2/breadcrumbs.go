@@ -0,0 +1,134 @@
+package bugsnag
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBreadcrumbs is the number of breadcrumbs kept when
+// Configuration.MaxBreadcrumbs is not set.
+const DefaultMaxBreadcrumbs = 25
+
+// BreadcrumbType describes what a breadcrumb records, which decides how it is
+// displayed in the Bugsnag dashboard.
+type BreadcrumbType string
+
+// The types of breadcrumb which Bugsnag understands.
+const (
+	BreadcrumbTypeManual     BreadcrumbType = "manual"
+	BreadcrumbTypeError      BreadcrumbType = "error"
+	BreadcrumbTypeLog        BreadcrumbType = "log"
+	BreadcrumbTypeNavigation BreadcrumbType = "navigation"
+	BreadcrumbTypeProcess    BreadcrumbType = "process"
+	BreadcrumbTypeRequest    BreadcrumbType = "request"
+	BreadcrumbTypeState      BreadcrumbType = "state"
+	BreadcrumbTypeUser       BreadcrumbType = "user"
+)
+
+// Breadcrumb records something which happened before an error, such as a
+// query being run or a step of a job completing. Breadcrumbs are shown in
+// order in the dashboard, to give the sequence of events leading up to the
+// error.
+type Breadcrumb struct {
+	// Timestamp is when it happened. Defaults to the time it was left.
+	Timestamp time.Time `json:"timestamp"`
+	// Name is a short summary of what happened.
+	Name string `json:"name"`
+	// Type defaults to BreadcrumbTypeManual.
+	Type BreadcrumbType `json:"type"`
+	// MetaData is any further details, which are subject to ParamsFilters.
+	MetaData map[string]interface{} `json:"metaData,omitempty"`
+}
+
+// globalBreadcrumbs keeps the breadcrumbs left without a session, which are
+// included in every event.
+var globalBreadcrumbs = newBreadcrumbBuffer(DefaultMaxBreadcrumbs)
+
+// LeaveBreadcrumb records a breadcrumb which is sent with the events that
+// follow it. If the context has a session from StartSession the breadcrumb is
+// only sent with events notified with that session's context, so that each
+// request or job has its own trail, which is discarded along with the
+// context. Otherwise the breadcrumb is sent with every event, alongside the
+// breadcrumbs of the event's session.
+func LeaveBreadcrumb(ctx context.Context, breadcrumb Breadcrumb) {
+	if breadcrumb.Timestamp.IsZero() {
+		breadcrumb.Timestamp = Config.currentTime()
+	}
+	if breadcrumb.Type == "" {
+		breadcrumb.Type = BreadcrumbTypeManual
+	}
+	if buffer := breadcrumbBufferFromContext(ctx); buffer != nil {
+		buffer.append(breadcrumb)
+		return
+	}
+	globalBreadcrumbs.append(breadcrumb)
+}
+
+// withBreadcrumbBuffer returns a child of the given context with an empty
+// buffer for the breadcrumbs of a new session.
+func withBreadcrumbBuffer(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, breadcrumbsContextKey, newBreadcrumbBuffer(size))
+}
+
+func breadcrumbBufferFromContext(ctx context.Context) *breadcrumbBuffer {
+	if ctx == nil {
+		return nil
+	}
+	if buffer, ok := ctx.Value(breadcrumbsContextKey).(*breadcrumbBuffer); ok {
+		return buffer
+	}
+	return nil
+}
+
+// eventBreadcrumbs returns the global breadcrumbs and those of the session of
+// the context, oldest first, keeping the most recent max.
+func eventBreadcrumbs(ctx context.Context, max int) []Breadcrumb {
+	breadcrumbs := globalBreadcrumbs.snapshot()
+	if buffer := breadcrumbBufferFromContext(ctx); buffer != nil {
+		breadcrumbs = append(breadcrumbs, buffer.snapshot()...)
+		sort.SliceStable(breadcrumbs, func(i, j int) bool {
+			return breadcrumbs[i].Timestamp.Before(breadcrumbs[j].Timestamp)
+		})
+	}
+	if len(breadcrumbs) > max {
+		breadcrumbs = breadcrumbs[len(breadcrumbs)-max:]
+	}
+	return breadcrumbs
+}
+
+// breadcrumbBuffer keeps the most recent breadcrumbs in a fixed size ring,
+// in the same way as the logBuffer.
+type breadcrumbBuffer struct {
+	mutex       sync.Mutex
+	breadcrumbs []Breadcrumb
+	next        int
+	full        bool
+}
+
+func newBreadcrumbBuffer(size int) *breadcrumbBuffer {
+	return &breadcrumbBuffer{breadcrumbs: make([]Breadcrumb, size)}
+}
+
+func (buffer *breadcrumbBuffer) append(breadcrumb Breadcrumb) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	buffer.breadcrumbs[buffer.next] = breadcrumb
+	buffer.next = (buffer.next + 1) % len(buffer.breadcrumbs)
+	if buffer.next == 0 {
+		buffer.full = true
+	}
+}
+
+// snapshot returns the buffered breadcrumbs, oldest first.
+func (buffer *breadcrumbBuffer) snapshot() []Breadcrumb {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if !buffer.full {
+		return append([]Breadcrumb(nil), buffer.breadcrumbs[:buffer.next]...)
+	}
+	breadcrumbs := make([]Breadcrumb, 0, len(buffer.breadcrumbs))
+	breadcrumbs = append(breadcrumbs, buffer.breadcrumbs[buffer.next:]...)
+	return append(breadcrumbs, buffer.breadcrumbs[:buffer.next]...)
+}
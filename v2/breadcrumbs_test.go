@@ -0,0 +1,62 @@
+package bugsnag
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func breadcrumbNames(breadcrumbs []Breadcrumb) []string {
+	names := make([]string, len(breadcrumbs))
+	for i, breadcrumb := range breadcrumbs {
+		names[i] = breadcrumb.Name
+	}
+	return names
+}
+
+func TestSessionScopedBreadcrumbs(t *testing.T) {
+	defer func(buffer *breadcrumbBuffer) { globalBreadcrumbs = buffer }(globalBreadcrumbs)
+	globalBreadcrumbs = newBreadcrumbBuffer(DefaultMaxBreadcrumbs)
+	start := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	// Left before any session was started
+	LeaveBreadcrumb(context.Background(), Breadcrumb{Name: "config loaded", Timestamp: at(0)})
+	checkout := StartSession(context.Background())
+	search := StartSession(context.Background())
+	LeaveBreadcrumb(checkout, Breadcrumb{Name: "cart loaded", Type: BreadcrumbTypeState, Timestamp: at(1)})
+	LeaveBreadcrumb(search, Breadcrumb{Name: "query parsed", Timestamp: at(2)})
+	LeaveBreadcrumb(checkout, Breadcrumb{Name: "payment requested", Type: BreadcrumbTypeRequest, Timestamp: at(3)})
+
+	notifier := New(Configuration{APIKey: testAPIKey})
+	event, _ := newEvent([]interface{}{fmt.Errorf("payment declined"), checkout}, notifier)
+	if got, exp := breadcrumbNames(event.Breadcrumbs), []string{"config loaded", "cart loaded", "payment requested"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected the breadcrumbs of the session to be '%v' but were '%v'", exp, got)
+	}
+	if got := event.Breadcrumbs[0].Type; got != BreadcrumbTypeManual {
+		t.Errorf("Expected the type to default to '%s' but was '%s'", BreadcrumbTypeManual, got)
+	}
+
+	event, _ = newEvent([]interface{}{fmt.Errorf("timeout"), context.Background()}, notifier)
+	if got, exp := breadcrumbNames(event.Breadcrumbs), []string{"config loaded"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected only the global breadcrumbs without a session but got '%v'", got)
+	}
+
+	event, _ = newEvent([]interface{}{fmt.Errorf("payment declined"), checkout, Configuration{MaxBreadcrumbs: 2}}, notifier)
+	if got, exp := breadcrumbNames(event.Breadcrumbs), []string{"cart loaded", "payment requested"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected the most recent breadcrumbs to be kept but got '%v'", got)
+	}
+}
+
+func TestBreadcrumbMetaDataIsFiltered(t *testing.T) {
+	event := &Event{Breadcrumbs: []Breadcrumb{{Name: "login", MetaData: map[string]interface{}{"user": "ada", "password": "hunter2"}}}}
+	p := &payload{event, &Configuration{ParamsFilters: []string{"password"}}}
+	if got := p.breadcrumbs()[0].MetaData["password"]; got != "[FILTERED]" {
+		t.Errorf("Expected the password to be filtered but was '%v'", got)
+	}
+	if got := event.Breadcrumbs[0].MetaData["password"]; got != "hunter2" {
+		t.Errorf("Expected the event's breadcrumbs to be left unchanged but the password was '%v'", got)
+	}
+}
@@ -8,12 +8,14 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"sync"
 	"time"
 
 	"github.com/bugsnag/bugsnag-go/v2/device"
 	"github.com/bugsnag/bugsnag-go/v2/errors"
 	"github.com/bugsnag/bugsnag-go/v2/sessions"
+	uuid "github.com/google/uuid"
 
 	// Fixes a bug with SHA-384 intermediate certs on some platforms.
 	// - https://github.com/bugsnag/bugsnag-go/issues/9
@@ -39,18 +41,78 @@ var DefaultSessionPublishInterval = 60 * time.Second
 var defaultNotifier = Notifier{&Config, nil}
 var sessionTracker sessions.SessionTracker
 
+// configMutex is held by Configure, Reconfigure, UseProfile and SetAppPhase
+// while they change the global configuration, and by notifiers while they
+// copy it.
+var configMutex sync.RWMutex
+
+// profileBase is the configuration which profiles are applied to, which is
+// set when UseProfile is first called.
+var profileBase *Configuration
+
 // Configure Bugsnag. The only required setting is the APIKey, which can be
 // obtained by clicking on "Settings" in your Bugsnag dashboard. This function
 // is also responsible for installing the global panic handler, so it should be
 // called as early as possible in your initialization process.
 func Configure(config Configuration) {
+	configMutex.Lock()
 	// Load configuration from the environment, if any
 	readEnvConfigOnce.Do(Config.loadEnv)
 	Config.update(&config)
+	if profileBase != nil {
+		profileBase.update(&config)
+	}
 	updateSessionConfig()
+	configured := Config.Clone()
+	configMutex.Unlock()
 	// Only do once in case the user overrides the default panichandler, and
 	// configures multiple times.
-	panicHandlerOnce.Do(Config.PanicHandler)
+	panicHandlerOnce.Do(configured.PanicHandler)
+	if configured.InstallShutdownHandler {
+		shutdownHandlerOnce.Do(installShutdownHandler)
+	}
+}
+
+// Reconfigure changes the global configuration while the application is
+// running, e.g. to use a new API key after it has been rotated, without
+// recreating notifiers or losing the sessions which haven't been sent yet.
+// change is given a copy of the configuration, which replaces it once change
+// returns, so each event notified concurrently uses either the old or the new
+// configuration. Events which are already being delivered keep the
+// configuration they were notified with, and sessions are sent with the new
+// API key and endpoint from the next time they're published. The change lasts
+// until UseProfile is next called, if profiles are being used.
+func Reconfigure(change func(config *Configuration)) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	config := Config.Clone()
+	change(config)
+	Config = *config
+	updateSessionConfig()
+}
+
+// UseProfile switches the global configuration to the named profile from
+// Config.Profiles. The fields set in the profile are applied to the
+// configuration as it was before any profile was used, along with any later
+// calls to Configure, so that fields which aren't set in the profile are
+// inherited rather than left over from the previous profile. Changes made by
+// writing to Config directly, or with Reconfigure, after a profile is first
+// used aren't part of that configuration, so they're replaced by the next
+// call to UseProfile. Use Configure for changes which should be kept when
+// switching profiles.
+func UseProfile(name string) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	profile, ok := Config.Profiles[name]
+	if !ok {
+		return fmt.Errorf("bugsnag.UseProfile: no profile named '%s'", name)
+	}
+	if profileBase == nil {
+		profileBase = Config.Clone()
+	}
+	Config = *profileBase.merge(&profile)
+	updateSessionConfig()
+	return nil
 }
 
 // StartSession creates new context from the context.Context instance with
@@ -58,7 +120,17 @@ func Configure(config Configuration) {
 // started
 func StartSession(ctx context.Context) context.Context {
 	sessionTrackerOnce.Do(startSessionTracking)
-	return sessionTracker.StartSession(ctx)
+	// Each session has its own breadcrumbs
+	return withBreadcrumbBuffer(sessionTracker.StartSession(ctx), Config.maxBreadcrumbs())
+}
+
+// FlushSessions immediately sends the sessions started since they were last
+// published, rather than waiting for the next publish interval, and returns
+// once they have been sent. This is useful before a process is suspended or
+// exits, e.g. at the end of a serverless function invocation.
+func FlushSessions() {
+	sessionTrackerOnce.Do(startSessionTracking)
+	sessionTracker.FlushSessions()
 }
 
 // Notify sends an error.Error to Bugsnag along with the current stack trace.
@@ -71,13 +143,54 @@ func StartSession(ctx context.Context) context.Context {
 // that can be extracted, see
 // https://docs.bugsnag.com/platforms/go/reporting-handled-errors/
 func Notify(err error, rawData ...interface{}) error {
-	if e := checkForEmptyError(err); e != nil {
+	if empty, e := checkForEmptyError(err, &Config); empty {
 		return e
 	}
 	// Stripping one stackframe to not include this function in the stacktrace
 	// for a manual notification.
 	skipFrames := 1
-	return defaultNotifier.Notify(errors.New(err, skipFrames), rawData...)
+	return defaultNotifier.Notify(newError(err, skipFrames, &Config, rawData), rawData...)
+}
+
+// NotifyWithID sends an error to Bugsnag in the same way as Notify, and
+// returns a unique ID for the event which can be shown to a user as a
+// reference. See Notifier.NotifyWithID.
+func NotifyWithID(err error, rawData ...interface{}) (string, error) {
+	if empty, e := checkForEmptyError(err, &Config); empty {
+		return "", e
+	}
+	id := uuid.New().String()
+	// Stripping one stackframe to not include this function in the stacktrace
+	// for a manual notification.
+	skipFrames := 1
+	err = newError(err, skipFrames, &Config, rawData)
+	return id, defaultNotifier.Notify(err, append(rawData, Tags{EventIDTag: id})...)
+}
+
+// NotifyMessage sends a message to Bugsnag without needing to create an error
+// first. The message is formatted as for fmt.Sprintf, and is reported with the
+// error class "Message" and the stacktrace of the caller.
+func NotifyMessage(format string, args ...interface{}) error {
+	// Stripping one stackframe to not include this function in the stacktrace
+	// for a manual notification.
+	skipFrames := 1
+	err := newError(fmt.Sprintf(format, args...), skipFrames, &Config)
+	return defaultNotifier.Notify(err, ErrorClass{Name: messageErrorClass})
+}
+
+// SendTestError synchronously sends an unmistakable test error to Bugsnag
+// using the global configuration, and returns an error if it wasn't
+// delivered. See Notifier.SendTestError.
+func SendTestError() error {
+	return defaultNotifier.SendTestError()
+}
+
+// NotifyRelease reports a release of the application to Bugsnag, so that the
+// dashboard can show which release introduced an error. It should be called
+// once per deploy, e.g. from a CI pipeline, and returns once the release has
+// been recorded.
+func NotifyRelease(release ReleaseInfo) error {
+	return defaultNotifier.NotifyRelease(release)
 }
 
 // AutoNotify logs a panic on a goroutine and then repanics.
@@ -90,6 +203,11 @@ func Notify(err error, rawData ...interface{}) error {
 // context.
 // The rawData is used to send extra information along with any
 // panics that are handled this way.
+// The original panic value is repanicked unchanged, so panic handlers further
+// up the stack can recover it as before. As the repanic happens before the
+// stack has been unwound, the goroutine's stack (e.g. from debug.Stack in a
+// later deferred function) still contains the frames where the panic
+// originally happened, below the frames of AutoNotify itself.
 // Usage:
 //
 //	 go func() {
@@ -109,8 +227,7 @@ func AutoNotify(rawData ...interface{}) {
 		// - runtime/panic.go#gopanic
 		// Panics have their own stacktrace, so no stripping of the current stack
 		skipFrames := 2
-		defaultNotifier.NotifySync(errors.New(err, skipFrames), true, rawData...)
-		sessionTracker.FlushSessions()
+		defaultNotifier.notifyPanic(errors.NewPanic(err, skipFrames), rawData)
 		panic(err)
 	}
 }
@@ -151,19 +268,104 @@ func Recover(rawData ...interface{}) {
 		// - runtime/panic.go#gopanic
 		// Panics have their own stacktrace, so no stripping of the current stack
 		skipFrames := 2
-		defaultNotifier.Notify(errors.New(err, skipFrames), rawData...)
+		defaultNotifier.notifyPanic(errors.NewPanic(err, skipFrames), rawData)
 	}
 }
 
+// Go runs the function in a new goroutine with AutoNotify deferred, so that
+// any panic is reported to Bugsnag before crashing the program as usual. The
+// rawData is sent along with any panic reported this way.
+// Usage:
+//
+//	bugsnag.Go(func() {
+//	    // (possibly crashy code)
+//	}, ctx)
+//
+// Panics can only be reported from goroutines started with Go or GoRecover,
+// or which defer AutoNotify or Recover themselves. A panic in any other
+// goroutine will crash the program without being reported by this process.
+//
+// See also: bugsnag.GoRecover()
+func Go(f func(), rawData ...interface{}) {
+	go func() {
+		defer AutoNotify(rawData...)
+		f()
+	}()
+}
+
+// GoRecover runs the function in a new goroutine with Recover deferred, so
+// that any panic is reported to Bugsnag and then recovered from, leaving the
+// rest of the program running. The rawData is sent along with any panic
+// reported this way.
+//
+// See also: bugsnag.Go()
+func GoRecover(f func(), rawData ...interface{}) {
+	go func() {
+		defer Recover(rawData...)
+		f()
+	}()
+}
+
 // OnBeforeNotify adds a callback to be run before a notification is sent to
 // Bugsnag.  It can be used to modify the event or its MetaData. Changes made
-// to the configuration are local to notifying about this event. To prevent the
-// event from being sent to Bugsnag return an error, this error will be
-// returned from bugsnag.Notify() and the event will not be sent.
+// to the configuration are local to notifying about this event, e.g. setting
+// config.Synchronous delivers this event before Notify returns, even if the
+// notifier is asynchronous. To prevent the event from being sent to Bugsnag
+// return an error, this error will be returned from bugsnag.Notify() and the
+// event will not be sent.
 func OnBeforeNotify(callback func(event *Event, config *Configuration) error) {
 	middleware.OnBeforeNotify(callback)
 }
 
+// OnBeforeNotifyFinal adds a callback to be run after all callbacks added with
+// OnBeforeNotify. Callbacks are run in two phases:
+//
+//  1. callbacks added with OnBeforeNotify, most recently added first. The
+//     first callback to return an error prevents the rest of this phase from
+//     running.
+//  2. callbacks added with OnBeforeNotifyFinal, most recently added first.
+//     These are always run, even if a callback in the first phase returned an
+//     error such as ErrAbortNotification.
+//
+// The event is only sent to Bugsnag if no callback in either phase returned an
+// error. This makes final callbacks suitable for enrichment which must always
+// happen, such as stamping a correlation ID.
+func OnBeforeNotifyFinal(callback func(event *Event, config *Configuration) error) {
+	middleware.OnBeforeNotifyFinal(callback)
+}
+
+// AddOnBeforeNotify adds a callback in the same way as OnBeforeNotify, and
+// returns a handle which can be passed to RemoveOnBeforeNotify to unregister
+// the callback again. This is useful for tests which capture events, or for
+// enrichment which is toggled at runtime.
+func AddOnBeforeNotify(callback func(event *Event, config *Configuration) error) MiddlewareHandle {
+	return middleware.add(callback, false)
+}
+
+// AddOnBeforeNotifyFinal adds a callback in the same way as
+// OnBeforeNotifyFinal, and returns a handle which can be passed to
+// RemoveOnBeforeNotify to unregister the callback again.
+func AddOnBeforeNotifyFinal(callback func(event *Event, config *Configuration) error) MiddlewareHandle {
+	return middleware.add(callback, true)
+}
+
+// RemoveOnBeforeNotify unregisters a callback previously added with
+// AddOnBeforeNotify. Notifications which are already in progress are not
+// affected.
+func RemoveOnBeforeNotify(handle MiddlewareHandle) {
+	middleware.Remove(handle)
+}
+
+// ClearOnBeforeNotify unregisters all callbacks added with OnBeforeNotify,
+// OnBeforeNotifyFinal or their Add variants. This also removes all of the
+// default middleware, which enriches events with e.g. HTTP request, context
+// and environment data and drops events reported with a context created by
+// WithSuppression, so only call it if you intend to replace all of that
+// behavior. Use RemoveOnBeforeNotify to unregister a single callback instead.
+func ClearOnBeforeNotify() {
+	middleware.Clear()
+}
+
 // Handler creates an http Handler that notifies Bugsnag any panics that
 // happen. It then repanics so that the default http Server panic handler can
 // handle the panic too. The rawData is used to send extra information along
@@ -212,22 +414,55 @@ func HandlerFunc(h http.HandlerFunc, rawData ...interface{}) http.HandlerFunc {
 	}
 }
 
+const nilErrorMessage = "attempted to notify Bugsnag without supplying an error. Bugsnag not notified"
+
+// errNilError is returned by Notify when it's called without an error and the
+// NotifyNilBehavior is NotifyNilError.
+var errNilError = fmt.Errorf(nilErrorMessage)
+
 // checkForEmptyError checks if the given error (to be reported to Bugsnag) is
-// nil. If it is, then log an error message and return another error wrapping
-// this error message.
-func checkForEmptyError(err error) error {
+// nil. If it is, it's handled according to the configured NotifyNilBehavior,
+// and the error to return instead of notifying, if any, is returned.
+func checkForEmptyError(err error, config *Configuration) (bool, error) {
 	if err != nil {
-		return nil
+		return false, nil
+	}
+	switch cloneConfig(config).NotifyNilBehavior {
+	case NotifyNilLog:
+		config.logf("ERROR: " + nilErrorMessage)
+		return true, nil
+	case NotifyNilError:
+		config.logf("ERROR: " + nilErrorMessage)
+		return true, errNilError
 	}
-	msg := "attempted to notify Bugsnag without supplying an error. Bugsnag not notified"
-	Config.Logger.Printf("ERROR: " + msg)
-	return fmt.Errorf(msg)
+	return true, nil
 }
 
 func init() {
 	// Set up builtin middlewarez
 	OnBeforeNotify(httpRequestMiddleware)
 	OnBeforeNotify(httpRequestBodyMiddleware)
+	OnBeforeNotify(contextMetaDataMiddleware)
+	OnBeforeNotify(traceContextMiddleware)
+	OnBeforeNotify(baggageMiddleware)
+	OnBeforeNotify(operationMiddleware)
+	OnBeforeNotify(contextFuncMiddleware)
+	OnBeforeNotify(logBufferMiddleware)
+	OnBeforeNotify(requestIDMiddleware)
+	OnBeforeNotify(jobMiddleware)
+	OnBeforeNotify(envMiddleware)
+	OnBeforeNotify(appPhaseMiddleware)
+	OnBeforeNotify(goroutineLabelsMiddleware)
+	OnBeforeNotify(goroutineDumpMiddleware)
+	OnBeforeNotify(sqlMiddleware)
+	OnBeforeNotify(outboundRequestMiddleware)
+	OnBeforeNotify(deadlineMiddleware)
+	OnBeforeNotify(rootCauseGroupingMiddleware)
+	OnBeforeNotify(validationMiddleware)
+	OnBeforeNotify(execMiddleware)
+	OnBeforeNotify(panicGroupingMiddleware)
+	// Registered last so that it runs first
+	OnBeforeNotify(suppressionMiddleware)
 
 	// Default configuration
 	sourceRoot := ""
@@ -236,11 +471,17 @@ func init() {
 	} else {
 		sourceRoot = filepath.Join(runtime.GOROOT(), "src") + "/"
 	}
+	mainModulePath, gitCommit := "", ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		mainModulePath = info.Main.Path
+		gitCommit = vcsRevision(info)
+	}
 	Config.update(&Configuration{
 		APIKey: "",
 		Endpoints: Endpoints{
 			Notify:   "https://notify.bugsnag.com",
 			Sessions: "https://sessions.bugsnag.com",
+			Build:    "https://build.bugsnag.com",
 		},
 		Hostname:            device.GetHostname(),
 		AppType:             "",
@@ -249,37 +490,57 @@ func init() {
 		ReleaseStage:        "",
 		ParamsFilters:       []string{"password", "secret", "authorization", "cookie", "access_token"},
 		SourceRoot:          sourceRoot,
+		MainModulePath:      mainModulePath,
+		GitCommit:           gitCommit,
 		ProjectPackages:     []string{"main*"},
 		NotifyReleaseStages: nil,
 		Logger:              log.New(os.Stdout, log.Prefix(), log.Flags()),
 		PanicHandler:        defaultPanicHandler,
 		Transport:           http.DefaultTransport,
-
-		flushSessionsOnRepanic: true,
+		DeliveryTimeout:     15 * time.Second,
 	})
 	updateSessionConfig()
 }
 
 func startSessionTracking() {
 	if sessionTracker == nil {
+		configMutex.RLock()
 		updateSessionConfig()
+		configMutex.RUnlock()
 		sessionTracker = sessions.NewSessionTracker(&sessionTrackingConfig)
 	}
 }
 
+func reportSessionError(err error) {
+	Config.reportError(err, ErrorKindSession)
+}
+
 func updateSessionConfig() {
+	var onShutdown func()
+	if Config.InstallShutdownHandler {
+		onShutdown = OnShutdown
+	}
 	sessionTrackingConfig.Update(&sessions.SessionTrackingConfiguration{
 		APIKey:              Config.APIKey,
 		AutoCaptureSessions: Config.AutoCaptureSessions,
 		Endpoint:            Config.Endpoints.Sessions,
-		Version:             Version,
+		Version:             Config.notifierVersion(),
+		UserAgent:           Config.userAgent(),
 		PublishInterval:     DefaultSessionPublishInterval,
 		Transport:           Config.Transport,
+		Timeout:             Config.DeliveryTimeout,
 		ReleaseStage:        Config.ReleaseStage,
 		Hostname:            Config.Hostname,
 		AppType:             Config.AppType,
 		AppVersion:          Config.AppVersion,
-		NotifyReleaseStages: Config.NotifyReleaseStages,
+		AppBuildID:          Config.AppBuildID,
+		GitCommit:           Config.GitCommit,
+		DeployID:            Config.DeployID,
+		NotifyReleaseStages: Config.sessionReleaseStages(),
 		Logger:              Config.Logger,
+		Clock:               Config.now,
+		OnError:             reportSessionError,
+		Store:               Config.SessionStore,
+		OnShutdown:          onShutdown,
 	})
 }
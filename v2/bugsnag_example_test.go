@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/bugsnag/bugsnag-go/v2"
@@ -129,6 +130,55 @@ func ExampleNotify_details() {
 	}
 }
 
+func ExampleNewError() {
+	_, err := net.Listen("tcp", ":80")
+
+	if err != nil {
+		bugsnag.NewError(err).
+			WithSeverity(bugsnag.SeverityInfo).
+			WithContext("createlistener").
+			WithUser(bugsnag.User{Id: "123456789"}).
+			WithMetaData(bugsnag.MetaData{
+				"Listen": {
+					"Protocol": "tcp",
+					"Port":     "80",
+				},
+			}).
+			Notify(nil)
+	}
+}
+
+func ExampleJob() {
+	process := func(payload []byte) error { return nil }
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if err := process([]byte(`{"invoice":42}`)); err != nil {
+			bugsnag.Notify(err, bugsnag.Job{Queue: "invoices", ID: "a1b2c3", Attempt: attempt})
+			continue
+		}
+		break
+	}
+}
+
+func ExampleNotifyRelease() {
+	// Run as a step of a CI pipeline once the deploy has finished, e.g. with
+	// the version and commit which the pipeline deployed
+	bugsnag.Configure(bugsnag.Configuration{
+		APIKey:       "YOUR_API_KEY_HERE",
+		ReleaseStage: "production",
+	})
+	err := bugsnag.NotifyRelease(bugsnag.ReleaseInfo{
+		AppVersion:  os.Getenv("APP_VERSION"),
+		BuilderName: os.Getenv("CI_PIPELINE_NAME"),
+		Repository:  "https://github.com/example/billing",
+		Revision:    os.Getenv("CI_COMMIT_SHA"),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to report the release to Bugsnag: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 func ExampleOnBeforeNotify() {
 
 	type Job struct {
@@ -157,3 +207,22 @@ func ExampleOnBeforeNotify() {
 		return nil
 	})
 }
+
+func ExampleNewHandledState() {
+	// A framework integration reports panics in route handlers as unhandled,
+	// and errors returned by them as handled
+	panicked := bugsnag.NewHandledState(bugsnag.SeverityReasonUnhandledMiddlewareError, bugsnag.SeverityError, true, "MyFramework")
+	returned := bugsnag.NewHandledState(bugsnag.SeverityReasonHandledError, bugsnag.SeverityWarning, false, "MyFramework")
+
+	serve := func(ctx context.Context, route func(context.Context) error) {
+		defer bugsnag.AutoNotify(ctx, panicked)
+		if err := route(ctx); err != nil {
+			bugsnag.Notify(err, ctx, returned)
+		}
+	}
+	serve(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+	fmt.Println(panicked.Unhandled, returned.Unhandled)
+	// Output: true false
+}
@@ -477,7 +477,7 @@ func TestNotifyWithoutError(t *testing.T) {
 	defer ts.Close()
 
 	config := generateSampleConfig(ts.URL)
-	config.Synchronous = true
+	config.Synchronous, config.NotifyNilBehavior = true, NotifyNilLog
 	l := logger{}
 	config.Logger = &l
 	Configure(config)
@@ -511,6 +511,52 @@ func TestConfigureTwice(t *testing.T) {
 	}
 }
 
+func TestGoRecover(t *testing.T) {
+	ts, reports := setup()
+	defer ts.Close()
+
+	GoRecover(func() {
+		panic("spam")
+	}, StartSession(context.Background()), generateSampleConfig(ts.URL))
+
+	json, err := simplejson.NewJson(<-reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := getIndex(json, "events", 0)
+	if getBool(event, "unhandled") {
+		t.Errorf("Expected a recovered panic to be reported as handled")
+	}
+	exception := getIndex(event, "exceptions", 0)
+	if got, exp := getString(exception, "message"), "spam"; got != exp {
+		t.Errorf("Expected exception message to be '%s' but was '%s'", exp, got)
+	}
+	verifyExistsInStackTrace(t, exception, &StackFrame{File: "bugsnag_test.go", Method: "TestGoRecover.func1", InProject: true, LineNumber: 519})
+}
+
+func TestNotifyMessage(t *testing.T) {
+	pub := new(recordingPublisher)
+	publisher = pub
+	defer func() { publisher = new(defaultReportPublisher) }()
+
+	NotifyMessage("cache miss rate at %d%%", 87)
+	New().NotifyMessage("queue %s is backing up", "emails")
+
+	if len(pub.payloads) != 2 {
+		t.Fatalf("Expected 2 reports to be published but got %d", len(pub.payloads))
+	}
+	for i, exp := range []string{"cache miss rate at 87%", "queue emails is backing up"} {
+		event := pub.payloads[i].Event
+		if event.Message != exp || event.ErrorClass != "Message" {
+			t.Errorf("Expected message '%s' with class 'Message' but got '%s' with class '%s'", exp, event.Message, event.ErrorClass)
+		}
+		if frame := event.Stacktrace[0]; !strings.HasSuffix(frame.File, "bugsnag_test.go") || frame.Method != "TestNotifyMessage" {
+			t.Errorf("Expected stacktrace to start at the caller of NotifyMessage but was '%+v'", frame)
+		}
+	}
+}
+
 func generateSampleConfig(endpoint string) Configuration {
 	return Configuration{
 		APIKey:          testAPIKey,
@@ -0,0 +1,89 @@
+package bugsnag
+
+// ErrorBuilder builds up the details of an error report, as an alternative
+// to passing the equivalent values to Notify as rawData. Create one with
+// NewError, and send it with Notify:
+//
+//	bugsnag.NewError(err).
+//		WithSeverity(bugsnag.SeverityWarning).
+//		WithContext("job:sync").
+//		WithUser(bugsnag.User{Id: "123456789"}).
+//		Notify(nil)
+type ErrorBuilder struct {
+	err     error
+	rawData []interface{}
+}
+
+// NewError starts building a report of the given error.
+func NewError(err error) *ErrorBuilder {
+	return &ErrorBuilder{err: err}
+}
+
+// WithSeverity sets the severity of the report. This can be SeverityError,
+// SeverityWarning or SeverityInfo.
+func (b *ErrorBuilder) WithSeverity(severity severity) *ErrorBuilder {
+	return b.WithRawData(severity)
+}
+
+// WithSeverityReason sets the reason shown for the severity of the report,
+// e.g. SeverityReasonLog.
+func (b *ErrorBuilder) WithSeverityReason(reason SeverityReason) *ErrorBuilder {
+	return b.WithRawData(reason)
+}
+
+// WithContext sets the context of the report, which is the part of the app
+// that was running, e.g. the path for http requests.
+func (b *ErrorBuilder) WithContext(context string) *ErrorBuilder {
+	return b.WithRawData(Context{String: context})
+}
+
+// WithErrorClass overrides the error class of the report, which defaults to
+// the type name of the error.
+func (b *ErrorBuilder) WithErrorClass(class string) *ErrorBuilder {
+	return b.WithRawData(ErrorClass{Name: class})
+}
+
+// WithMetaData adds meta-data tabs to the report. Calling this more than once
+// merges the tabs together.
+func (b *ErrorBuilder) WithMetaData(metaData MetaData) *ErrorBuilder {
+	return b.WithRawData(metaData)
+}
+
+// WithUser sets the user affected by the error.
+func (b *ErrorBuilder) WithUser(user User) *ErrorBuilder {
+	return b.WithRawData(user)
+}
+
+// WithTags adds searchable tags to the report.
+func (b *ErrorBuilder) WithTags(tags Tags) *ErrorBuilder {
+	return b.WithRawData(tags)
+}
+
+// WithCallback adds a callback which can modify the event before it is sent,
+// after the other details have been applied.
+func (b *ErrorBuilder) WithCallback(callback func(*Event)) *ErrorBuilder {
+	return b.WithRawData(callback)
+}
+
+// WithRawData adds any other rawData which Notify accepts, such as a
+// context.Context or *http.Request.
+func (b *ErrorBuilder) WithRawData(rawData ...interface{}) *ErrorBuilder {
+	b.rawData = append(b.rawData, rawData...)
+	return b
+}
+
+// Notify sends the report to Bugsnag using the given notifier, or using the
+// global configuration if notifier is nil. The stacktrace is taken from
+// where Notify is called unless the error already has one.
+func (b *ErrorBuilder) Notify(notifier *Notifier) error {
+	if notifier == nil {
+		notifier = &defaultNotifier
+	}
+	if empty, e := checkForEmptyError(b.err, notifier.Config); empty {
+		return e
+	}
+	// Stripping one stackframe to not include this function in the stacktrace
+	// for a manual notification.
+	skipFrames := 1
+	return notifier.Notify(newError(b.err, skipFrames, notifier.Config, notifier.RawData, b.rawData), b.rawData...)
+}
@@ -0,0 +1,65 @@
+package bugsnag
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type recordingPublisher struct {
+	payloads []*payload
+}
+
+func (rp *recordingPublisher) publishReport(p *payload) error {
+	rp.payloads = append(rp.payloads, p)
+	return nil
+}
+
+func TestErrorBuilder(t *testing.T) {
+	pub := new(recordingPublisher)
+	publisher = pub
+	defer func() { publisher = new(defaultReportPublisher) }()
+
+	notifier := New(Configuration{APIKey: testAPIKey})
+	err := NewError(fmt.Errorf("sync failed")).
+		WithSeverity(SeverityWarning).
+		WithContext("job:sync").
+		WithErrorClass("SyncError").
+		WithMetaData(MetaData{"job": {"attempt": 2}}).
+		WithMetaData(MetaData{"job": {"queue": "default"}}).
+		WithUser(User{Id: "123"}).
+		WithTags(Tags{"shard": "7"}).
+		WithCallback(func(event *Event) { event.GroupingHash = "sync" }).
+		Notify(notifier)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.payloads) != 1 {
+		t.Fatalf("Expected 1 report to be published but got %d", len(pub.payloads))
+	}
+	event := pub.payloads[0].Event
+	if event.handledState.OriginalSeverity != SeverityWarning {
+		t.Errorf("Expected severity to be warning but was '%s'", event.handledState.OriginalSeverity)
+	}
+	if event.Context != "job:sync" || event.ErrorClass != "SyncError" || event.GroupingHash != "sync" {
+		t.Errorf("Unexpected context '%s', class '%s' or grouping hash '%s'", event.Context, event.ErrorClass, event.GroupingHash)
+	}
+	if exp := (map[string]interface{}{"attempt": 2, "queue": "default"}); !reflect.DeepEqual(event.MetaData["job"], exp) {
+		t.Errorf("Expected job tab to be '%v' but was '%v'", exp, event.MetaData["job"])
+	}
+	if event.User == nil || event.User.Id != "123" || event.Tags["shard"] != "7" {
+		t.Errorf("Unexpected user '%+v' or tags '%+v'", event.User, event.Tags)
+	}
+	if frame := event.Stacktrace[0]; !strings.HasSuffix(frame.File, "builder_test.go") || frame.Method != "TestErrorBuilder" {
+		t.Errorf("Expected stacktrace to start at the caller of Notify but was '%+v'", frame)
+	}
+}
+
+func TestErrorBuilderWithoutError(t *testing.T) {
+	notifier := New(Configuration{NotifyNilBehavior: NotifyNilError})
+	if err := NewError(nil).WithContext("job:sync").Notify(notifier); err == nil {
+		t.Errorf("Expected an error when building a report without an error")
+	}
+}
@@ -0,0 +1,207 @@
+package bugsnag
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCircuitBreakerThreshold defines how many consecutive failed
+// deliveries cause the notifier to stop sending events to Bugsnag.
+var DefaultCircuitBreakerThreshold = 5
+
+// DefaultCircuitBreakerCooldown defines how long the notifier stops sending
+// events to Bugsnag for after DefaultCircuitBreakerThreshold consecutive
+// failed deliveries.
+var DefaultCircuitBreakerCooldown = 60 * time.Second
+
+// CircuitState describes whether events are currently being delivered to
+// Bugsnag.
+type CircuitState int
+
+const (
+	// CircuitClosed means that events are being delivered as normal.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen means that recent deliveries have failed, and events are
+	// being dropped until the cooldown period has passed.
+	CircuitOpen
+	// CircuitHalfOpen means that the cooldown period has passed, and the next
+	// event is being delivered to test whether Bugsnag is reachable again.
+	CircuitHalfOpen
+)
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+var deliveryBreakers = &circuitBreakers{now: time.Now}
+
+// DeliveryCircuitState returns the current state of the circuit breaker
+// which protects the notify endpoint of the global configuration from being
+// sent events while it is failing.
+func DeliveryCircuitState() CircuitState {
+	return defaultNotifier.DeliveryCircuitState()
+}
+
+// DeliveryCircuitState returns the current state of the circuit breaker
+// which protects the notifier's notify endpoint from being sent events while
+// it is failing. Each project and notify endpoint has its own circuit
+// breaker, so notifiers which share both also share the state.
+func (notifier *Notifier) DeliveryCircuitState() CircuitState {
+	return deliveryBreakers.get(cloneConfig(notifier.Config)).currentState()
+}
+
+// circuitBreakers holds a circuit breaker for each project and notify
+// endpoint, so that one which can't be reached or is rate limiting doesn't
+// stop events being delivered to the others.
+type circuitBreakers struct {
+	mutex    sync.Mutex
+	breakers map[string]*circuitBreaker
+	now      func() time.Time
+}
+
+// get returns the circuit breaker for the API key and notify endpoint of the
+// configuration.
+func (b *circuitBreakers) get(config *Configuration) *circuitBreaker {
+	key := config.APIKey + "\x00" + config.Endpoints.Notify
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if cb, ok := b.breakers[key]; ok {
+		return cb
+	}
+	if b.breakers == nil {
+		b.breakers = make(map[string]*circuitBreaker)
+	}
+	cb := &circuitBreaker{now: b.now}
+	b.breakers[key] = cb
+	return cb
+}
+
+// circuitBreaker tracks consecutive delivery failures and decides whether
+// further deliveries should be attempted. It also holds when the server has
+// asked for deliveries to resume with a Retry-After header.
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	state     CircuitState
+	failures  int
+	openUntil time.Time
+	trialSent bool
+	retryAt   time.Time
+	now       func() time.Time
+}
+
+func (cb *circuitBreaker) currentState() CircuitState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh()
+	return cb.state
+}
+
+// refresh moves an open circuit to half-open once the cooldown has passed.
+// Callers must hold the mutex.
+func (cb *circuitBreaker) refresh() {
+	if cb.state == CircuitOpen && !cb.now().Before(cb.openUntil) {
+		cb.state = CircuitHalfOpen
+		cb.trialSent = false
+	}
+}
+
+// allow reports whether a delivery should be attempted. While half-open only
+// a single trial delivery is allowed until its outcome has been recorded.
+func (cb *circuitBreaker) allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh()
+	switch cb.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if cb.trialSent {
+			return false
+		}
+		cb.trialSent = true
+	}
+	return true
+}
+
+func (cb *circuitBreaker) recordSuccess() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.state = CircuitClosed
+	cb.failures = 0
+}
+
+// recordFailure registers a failed delivery.
+func (cb *circuitBreaker) recordFailure() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.failures++
+	if cb.state != CircuitHalfOpen && cb.failures < DefaultCircuitBreakerThreshold {
+		return
+	}
+	cb.state = CircuitOpen
+	cb.openUntil = cb.now().Add(DefaultCircuitBreakerCooldown)
+}
+
+// recordRetryAfter registers that the server has asked for deliveries to be
+// delayed until retryAt. As the breaker is shared by all deliveries to the
+// project and endpoint, every subsequent delivery to them waits until then,
+// rather than being dropped as when the circuit is open.
+func (cb *circuitBreaker) recordRetryAfter(retryAt time.Time) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if retryAt.After(cb.retryAt) {
+		cb.retryAt = retryAt
+	}
+}
+
+// retryDelay returns how long to wait before the next delivery, so that it
+// isn't sent before the time given by a Retry-After header.
+func (cb *circuitBreaker) retryDelay() time.Duration {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if delay := cb.retryAt.Sub(cb.now()); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// isDeliveryFailure reports whether the response indicates that Bugsnag is
+// unavailable or rate limiting, as opposed to rejecting this payload.
+func isDeliveryFailure(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryAfter returns the time after which the server has asked for deliveries
+// to resume, or the zero time if it has not asked us to back off. The
+// Retry-After header is only honored on 429 and 503 responses, and may be
+// given either in delta-seconds or as an HTTP-date.
+func retryAfter(resp *http.Response, now time.Time) time.Time {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return time.Time{}
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return time.Time{}
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return time.Time{}
+		}
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date
+	}
+	return time.Time{}
+}
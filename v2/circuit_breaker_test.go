@@ -0,0 +1,194 @@
+package bugsnag
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb := &circuitBreaker{now: func() time.Time { return now }}
+
+	for i := 0; i < DefaultCircuitBreakerThreshold-1; i++ {
+		cb.recordFailure()
+	}
+	if state := cb.currentState(); state != CircuitClosed {
+		t.Fatalf("Expected circuit to be closed below the threshold but was %s", state)
+	}
+
+	cb.recordFailure()
+	if cb.allow() {
+		t.Errorf("Expected deliveries to be dropped when the circuit is open")
+	}
+
+	now = now.Add(DefaultCircuitBreakerCooldown)
+	if state := cb.currentState(); state != CircuitHalfOpen {
+		t.Fatalf("Expected circuit to be half-open after the cooldown but was %s", state)
+	}
+	if !cb.allow() {
+		t.Errorf("Expected a trial delivery to be allowed when half-open")
+	}
+	if cb.allow() {
+		t.Errorf("Expected only one trial delivery to be allowed when half-open")
+	}
+
+	cb.recordFailure()
+	if state := cb.currentState(); state != CircuitOpen {
+		t.Fatalf("Expected a failed trial to re-open the circuit but was %s", state)
+	}
+
+	now = now.Add(DefaultCircuitBreakerCooldown)
+	cb.allow()
+	cb.recordSuccess()
+	if state := cb.currentState(); state != CircuitClosed {
+		t.Errorf("Expected a successful trial to close the circuit but was %s", state)
+	}
+}
+
+func TestCircuitBreakerRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb := &circuitBreaker{now: func() time.Time { return now }}
+
+	cb.recordRetryAfter(now.Add(5 * time.Second))
+	cb.recordRetryAfter(now.Add(2 * time.Second))
+	if delay := cb.retryDelay(); delay != 5*time.Second {
+		t.Errorf("Expected deliveries to wait until the latest requested time but the delay was %v", delay)
+	}
+	if state := cb.currentState(); state != CircuitClosed {
+		t.Errorf("Expected Retry-After not to open the circuit but it was %s", state)
+	}
+	now = now.Add(5 * time.Second)
+	if delay := cb.retryDelay(); delay != 0 {
+		t.Errorf("Expected no delay once the requested time has passed but it was %v", delay)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		status int
+		header string
+		exp    time.Time
+	}{
+		{name: "delta-seconds", status: 429, header: "120", exp: now.Add(120 * time.Second)},
+		{name: "http-date", status: 503, header: "Wed, 01 Jan 2020 00:05:00 GMT", exp: now.Add(5 * time.Minute)},
+		{name: "http-date in the past", status: 429, header: "Tue, 31 Dec 2019 23:59:00 GMT"},
+		{name: "invalid", status: 429, header: "soon"},
+		{name: "missing", status: 429},
+		{name: "other status", status: 500, header: "120"},
+	} {
+		t.Run(tc.name, func(st *testing.T) {
+			resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+			if got := retryAfter(resp, now); !got.Equal(tc.exp) {
+				st.Errorf("Expected next allowed delivery at '%v' but was '%v'", tc.exp, got)
+			}
+		})
+	}
+}
+
+func TestDeliverWaitsForRetryAfter(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	var limited int32
+	received := make(chan time.Time, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.CompareAndSwapInt32(&limited, 0, 1) {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		received <- time.Now()
+	}))
+	defer ts.Close()
+
+	config := generateSampleConfig(ts.URL)
+	config.NotifyReleaseStages = []string{"test"}
+	notifier := New(config)
+	notifier.Config.Synchronous = true
+	event, c := newEvent([]interface{}{fmt.Errorf("oops")}, notifier)
+	if err := (&payload{event, c}).deliver(); err == nil {
+		t.Errorf("Expected an error when rate limited")
+	}
+	limitedAt := time.Now()
+	if state := notifier.DeliveryCircuitState(); state != CircuitClosed {
+		t.Errorf("Expected the circuit to stay closed when rate limited but was %s", state)
+	}
+
+	if err := notifier.Notify(fmt.Errorf("crash during the window")); err != nil {
+		t.Fatalf("Expected the event to be delivered after the window but got '%v'", err)
+	}
+	select {
+	case at := <-received:
+		if at.Sub(limitedAt) < 900*time.Millisecond {
+			t.Errorf("Expected the event to be delivered after the Retry-After window but it was sent after %v", at.Sub(limitedAt))
+		}
+	default:
+		t.Errorf("Expected the event notified during the window to be delivered")
+	}
+}
+
+func TestRetryAfterIsPerProject(t *testing.T) {
+	resetCircuitBreakers(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	limited := New(generateSampleConfig(ts.URL))
+	other := New(generateSampleConfig(ts.URL))
+	other.Config.APIKey = "0123456789abcdef0123456789abcdef"
+	event, config := newEvent([]interface{}{fmt.Errorf("oops")}, limited)
+	(&payload{event, config}).deliver()
+
+	if delay := deliveryBreakers.get(limited.Config).retryDelay(); delay <= 0 {
+		t.Errorf("Expected deliveries to the rate limited project to be delayed")
+	}
+	if delay := deliveryBreakers.get(other.Config).retryDelay(); delay != 0 {
+		t.Errorf("Expected deliveries to other projects not to be delayed but the delay was %v", delay)
+	}
+}
+
+func TestCircuitBreakerIsPerProject(t *testing.T) {
+	resetCircuitBreakers(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	failing := New(generateSampleConfig(ts.URL))
+	other := New(generateSampleConfig(ts.URL))
+	other.Config.APIKey = "0123456789abcdef0123456789abcdef"
+	event, config := newEvent([]interface{}{fmt.Errorf("oops")}, failing)
+	for i := 0; i < DefaultCircuitBreakerThreshold; i++ {
+		(&payload{event, config}).deliver()
+	}
+
+	if state := failing.DeliveryCircuitState(); state != CircuitOpen {
+		t.Errorf("Expected the circuit to be open for the failing project but was %s", state)
+	}
+	if state := other.DeliveryCircuitState(); state != CircuitClosed {
+		t.Errorf("Expected the circuit to stay closed for other projects but was %s", state)
+	}
+}
+
+// resetCircuitBreakers forgets the state of the delivery circuit breakers
+// before and after the test, so that failures in one test don't stop events
+// being delivered in another.
+func resetCircuitBreakers(tb testing.TB) {
+	reset := func() {
+		deliveryBreakers.mutex.Lock()
+		deliveryBreakers.breakers = nil
+		deliveryBreakers.mutex.Unlock()
+	}
+	reset()
+	tb.Cleanup(reset)
+}
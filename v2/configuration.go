@@ -1,20 +1,44 @@
 package bugsnag
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
+
+	"github.com/bugsnag/bugsnag-go/v2/errors"
+	"github.com/bugsnag/bugsnag-go/v2/sessions"
 )
 
 // Endpoints hold the HTTP endpoints of the notifier.
 type Endpoints struct {
 	Sessions string
 	Notify   string
+	// Build is the endpoint which releases are reported to by NotifyRelease.
+	Build string
 }
 
+// The kinds of failure which are passed to Configuration.OnError.
+const (
+	// ErrorKindDelivery means an event couldn't be sent to the notify
+	// endpoint.
+	ErrorKindDelivery = "delivery"
+	// ErrorKindMarshal means a report couldn't be converted to JSON or
+	// encoded by the PayloadEncoder.
+	ErrorKindMarshal = "marshal"
+	// ErrorKindSession means sessions couldn't be sent to the sessions
+	// endpoint.
+	ErrorKindSession = "session"
+)
+
 // Configuration sets up and customizes communication with the Bugsnag API.
 type Configuration struct {
 	// Your Bugsnag API key, e.g. "c9d60ae4c7e70c4b6c4ebd3e8056d2b8". You can
@@ -23,12 +47,14 @@ type Configuration struct {
 
 	// Endpoints define the HTTP endpoints that the notifier should notify
 	// about crashes and sessions. These default to notify.bugsnag.com for
-	// error reports and sessions.bugsnag.com for sessions.
+	// error reports, sessions.bugsnag.com for sessions and build.bugsnag.com
+	// for releases.
 	// If you are using bugsnag on-premise you will have to set these to your
 	// Event Server and Session Server endpoints. If the notify endpoint is set
 	// but the sessions endpoint is not, session tracking will be disabled
 	// automatically to avoid leaking session information outside of your
-	// server configuration, and a warning will be logged.
+	// server configuration, and a warning will be logged. Similarly, releases
+	// are only reported if the build endpoint is also set.
 	Endpoints Endpoints
 
 	// The current release stage. This defaults to "production" and is used to
@@ -41,6 +67,21 @@ type Configuration struct {
 	// in the Bugsnag dasboard. If you set this then Bugsnag will only re-open
 	// resolved errors if they happen in different app versions.
 	AppVersion string
+	// AppPhase is the stage of the application's lifecycle, such as
+	// AppPhaseStartup, which is reported with each event. It isn't reported
+	// unless set, usually with SetAppPhase as the application starts and
+	// stops.
+	AppPhase AppPhase
+	// AppBuildID identifies the build of the application, such as the ID of
+	// the CI run which produced it. It is sent as the app's buildUUID.
+	AppBuildID string
+	// GitCommit is the revision of the source the application was built from.
+	// This defaults to the VCS revision recorded in the build info, when the
+	// application was built with Go 1.18 or later from a repository.
+	GitCommit string
+	// DeployID identifies the deploy which is running, so that errors can be
+	// attributed to the deploy which introduced them.
+	DeployID string
 
 	// AutoCaptureSessions can be set to false to disable automatic session
 	// tracking. If you want control over what is deemed a session, you can
@@ -52,6 +93,38 @@ type Configuration struct {
 	// us to detect when this option has not been set.
 	AutoCaptureSessions interface{}
 
+	// ContextFunc derives the context of each event, such as the job name for
+	// a background worker or the command for a CLI, for workloads where it
+	// can't be set automatically from an http.Request. If it returns an empty
+	// string the context of the event is left as it is.
+	ContextFunc func(event *Event) string
+
+	// UserFromRequest derives the user of each event from its http.Request,
+	// e.g. from a session cookie or the token in the Authorization header. It
+	// must cope with requests which have no credentials, for which it should
+	// return an empty User. The user replaces the default of the client's IP
+	// address, but not a User passed to Notify or attached with WithUser.
+	UserFromRequest func(r *http.Request) User
+
+	// RequestIDFunc extracts the ID of the request being handled from a
+	// context.Context passed in as rawData, for services which already store
+	// one on the context. The ID is added to the "request" tab of the event,
+	// alongside the params and body added from the http.Request. If it
+	// returns an empty string no ID is added.
+	RequestIDFunc func(ctx context.Context) string
+
+	// CollectGoroutineLabels adds the pprof labels of a context.Context passed
+	// in as rawData to the "goroutine" tab of the event, so that errors carry
+	// the same labels as profiles. Go only exposes labels through the context
+	// given to pprof.Do, so that context must be passed to Notify. This is
+	// disabled by default as reading the labels has a small cost.
+	CollectGoroutineLabels bool
+
+	// SendSQLArgs sends the arguments of a SQLContext passed in as rawData.
+	// Arguments can contain personal data, so are replaced with [FILTERED]
+	// by default.
+	SendSQLArgs bool
+
 	// The hostname of the current server. This defaults to the return value of
 	// os.Hostname() and is graphed in the Bugsnag dashboard.
 	Hostname string
@@ -59,6 +132,16 @@ type Configuration struct {
 	// The Release stages to notify in. If you set this then bugsnag-go will
 	// only send notifications to Bugsnag if the ReleaseStage is listed here.
 	NotifyReleaseStages []string
+	// The release stages to send sessions in, when they should differ from
+	// the NotifyReleaseStages, e.g. to only track stability in production
+	// while still notifying about errors in staging. This defaults to the
+	// NotifyReleaseStages.
+	SessionReleaseStages []string
+	// SessionStore holds the number of sessions started until they are sent
+	// to Bugsnag. This defaults to a store in memory, but a store shared
+	// between processes lets short-lived instances, e.g. serverless
+	// functions, record sessions accurately. See sessions.Store.
+	SessionStore sessions.Store
 
 	// packages that are part of your app. Bugsnag uses this to determine how
 	// to group errors and how to display them on your dashboard. You should
@@ -77,11 +160,100 @@ type Configuration struct {
 	// build.
 	SourceRoot string
 
+	// MainModulePath is the module path of the application, e.g.
+	// "example.com/myapp". When set, file names in the application's own
+	// packages which are outside of the SourceRoot are made relative to the
+	// root of the module, so that they don't depend on where the application
+	// was built. This defaults to the main module recorded in the build info.
+	MainModulePath string
+
+	// Tags which are added to every event, such as the region or cluster the
+	// application is running in. Tags set on an event take precedence.
+	Tags Tags
+	// DefaultMetaData is added to every event, such as a "service" tab with
+	// the name and version of the service. Tabs are merged with the event's
+	// meta-data, and values set on the event take precedence.
+	DefaultMetaData MetaData
+	// MetaDataTabOrder lists the meta-data tabs which should be sent first,
+	// in order, so that they appear consistently in the dashboard. Any other
+	// tabs, and the keys within every tab, are always sent in alphabetical
+	// order, including those of nested maps and structs, as meta-data is
+	// sanitized into maps which encoding/json sorts by key.
+	MetaDataTabOrder []string
+	// ExcludeMetaDataTabs lists meta-data tabs which are never sent to
+	// Bugsnag, such as a "debug" tab of internal state. The tabs are removed
+	// after all middleware has run, so tabs added by middleware can be
+	// excluded too, and they still appear in reports logged in DryRun mode.
+	ExcludeMetaDataTabs []string
+
 	// Any meta-data that matches these filters will be marked as [FILTERED]
 	// before sending a Notification to Bugsnag. It defaults to
 	// []string{"password", "secret"} so that request parameters like password,
 	// password_confirmation and auth_secret will not be sent to Bugsnag.
 	ParamsFilters []string
+	// EnvAllowlist names the environment variables which are added to the
+	// "env" tab of every event, to show the configuration the application is
+	// actually running with. No other variables are sent, and values whose
+	// names match the ParamsFilters are still filtered. The variables are
+	// read when the configuration is updated, not for each event.
+	EnvAllowlist []string
+	// MaxRequestBodySize is the most of a request body which CaptureRequestBody
+	// keeps to send with events. Defaults to DefaultMaxRequestBodySize.
+	MaxRequestBodySize int
+	// SkipRequestBodyContentTypes lists the content types of request bodies
+	// which CaptureRequestBody doesn't keep, such as file uploads. A type
+	// ending in "/" matches every subtype. Defaults to
+	// DefaultSkipRequestBodyContentTypes.
+	SkipRequestBodyContentTypes []string
+
+	// IgnoreErrors are used to drop events without notifying Bugsnag. If any
+	// of these returns true for the error being notified, or for any error it
+	// wraps, the event is dropped. For example, to ignore cancelled requests:
+	//
+	//	IgnoreErrors: []func(error) bool{
+	//		func(err error) bool { return err == context.Canceled },
+	//	}
+	IgnoreErrors []func(error) bool
+	// NeverIgnoreUnhandled causes IgnoreErrors to be skipped for unhandled
+	// events, such as panics, so that they are always reported.
+	NeverIgnoreUnhandled bool
+	// HandledSampleRate is the proportion of handled events which are sent,
+	// as a number between 0 and 1, e.g. 0.1 to send one in ten handled
+	// warnings, or 0 to send none. Rates below 0 send none and rates above 1
+	// send every event. This defaults to 1, sending them all, but is stored as
+	// an interface to enable us to detect when this option has not been set.
+	HandledSampleRate interface{}
+	// UnhandledSampleRate is the proportion of unhandled events, such as
+	// panics, which are sent, in the same way as HandledSampleRate. This
+	// defaults to 1, so that every crash is reported while HandledSampleRate
+	// cuts down on noise.
+	UnhandledSampleRate interface{}
+	// MinSeverity is the lowest severity of handled events which are sent,
+	// e.g. SeverityError to only send errors in production. Events below it
+	// are dropped after all OnBeforeNotify callbacks have run, as they may
+	// change the severity. Unhandled events are always sent. This defaults
+	// to sending events of every severity.
+	MinSeverity severity
+	// SeparatePanicGrouping prefixes the error class of unhandled panics with
+	// "[panic] ", so that they are grouped separately from handled errors of
+	// the same type, and a crash can be told apart from a logged error.
+	SeparatePanicGrouping bool
+	// GroupByRootCause groups errors which wrap others by the class and
+	// message of the innermost cause, rather than by the outer error, so that
+	// e.g. "failed to save: failed to connect: connection refused" and
+	// "failed to load: connection refused" are grouped together. Grouping
+	// hashes set explicitly or by other middleware take precedence.
+	GroupByRootCause bool
+	// GroupByOperation groups all of the errors notified with a context
+	// from WithOperation by the operation's ID, so that each failed operation
+	// appears as a single error in the dashboard. This is intended for
+	// low-volume workflows, as every operation is grouped separately.
+	// Grouping hashes set explicitly or by other middleware take precedence.
+	GroupByOperation bool
+	// NotifySuppressedPanics sends unhandled panics even when they happen
+	// with a context created by WithSuppression, which otherwise drops them
+	// along with other events.
+	NotifySuppressedPanics bool
 
 	// The PanicHandler is used by Bugsnag to catch unhandled panics in your
 	// application. The default panicHandler uses mitchellh's panicwrap library,
@@ -98,13 +270,206 @@ type Configuration struct {
 	// can be configured if you are in an environment
 	// that has stringent conditions on making http requests.
 	Transport http.RoundTripper
+	// TransportConfig tunes the pool of connections to Bugsnag, e.g. to keep
+	// more connections open when sending a high volume of events. It creates
+	// a new Transport, so has no effect if Transport is also set. Defaults to
+	// DefaultTransportConfig.
+	TransportConfig *TransportConfig
+	// DeliveryTimeout bounds how long each request to Bugsnag may take,
+	// including connecting and reading the response, for both error reports
+	// and sessions. This defaults to 15 seconds. A delivery which times out is
+	// treated as a failed delivery.
+	DeliveryTimeout time.Duration
+	// NotifierName, NotifierVersion and NotifierURL override how the notifier
+	// identifies itself to Bugsnag. These are intended for libraries which
+	// embed bugsnag-go, and default to the values for bugsnag-go itself.
+	NotifierName    string
+	NotifierVersion string
+	NotifierURL     string
+	// UserAgent is sent as the User-Agent header of requests to Bugsnag, so
+	// that they can be identified in logs of outgoing traffic. This defaults
+	// to "bugsnag-go/" followed by the notifier version sent in reports.
+	UserAgent string
+	// The version of the event payload schema to send to Bugsnag, either "4"
+	// or "5". This defaults to "4". Version 5 allows newer features, such as
+	// trace correlation, to be sent in their dedicated fields.
+	PayloadVersion string
+	// PayloadEncoder transforms each report before it is sent, e.g. to
+	// encrypt personal data on its way to a self-hosted collector. It is
+	// given the report as JSON and returns the request body, which is sent
+	// with PayloadContentType. The collector must understand the encoding, so
+	// this can't be used with Bugsnag's own endpoints. Reports written to the
+	// Logger in DryRun mode are not encoded.
+	PayloadEncoder func(report []byte) ([]byte, error)
+	// PayloadContentType is the Content-Type of reports encoded by the
+	// PayloadEncoder. This defaults to "application/json".
+	PayloadContentType string
+	// FieldNameMapper renames the fields of each report before it is sent,
+	// e.g. to snake_case for a self-hosted collector which expects it. It is
+	// given each standard name, such as "groupingHash", and returns the name
+	// to send instead. The keys of meta-data, request headers and severity
+	// reason attributes are left as they are. By default, the standard names
+	// are sent. Reports written to the Logger in DryRun mode aren't renamed.
+	FieldNameMapper func(standardName string) string
+	// TraceContextExtractor returns the IDs of the active trace and span from
+	// a context.Context passed to Notify, so that events can be correlated
+	// with traces. For example, when using OpenTelemetry:
+	//
+	//	func(ctx context.Context) (string, string) {
+	//		sc := trace.SpanContextFromContext(ctx)
+	//		if !sc.IsValid() {
+	//			return "", ""
+	//		}
+	//		return sc.TraceID().String(), sc.SpanID().String()
+	//	}
+	//
+	// IDs attached with bugsnag.WithTraceContext take precedence.
+	TraceContextExtractor func(ctx context.Context) (traceID string, spanID string)
+	// BaggageExtractor returns the members of the baggage propagated with a
+	// context.Context passed to Notify, by key, which often carry the tenant,
+	// feature flags or experiments a request is part of. They're added to the
+	// "baggage" tab, and are subject to the ParamsFilters like other
+	// meta-data. For example, when using OpenTelemetry:
+	//
+	//	func(ctx context.Context) map[string]string {
+	//		members := baggage.FromContext(ctx).Members()
+	//		items := make(map[string]string, len(members))
+	//		for _, member := range members {
+	//			items[member.Key()] = member.Value()
+	//		}
+	//		return items
+	//	}
+	BaggageExtractor func(ctx context.Context) map[string]string
+	// MaxBaggageBytes limits the total size of the keys and values of the
+	// baggage members added to an event, so that a large set of baggage
+	// doesn't bloat the payload. Members are added in order of their keys
+	// until the limit is reached. Defaults to DefaultMaxBaggageBytes.
+	MaxBaggageBytes int
+	// DisableStacktraces stops stacktraces being captured and resolved for
+	// events, which saves time when sending a large number of low value
+	// handled errors.
+	// Events are sent with an empty stacktrace, so Bugsnag groups them by
+	// their error class and message instead. This can be set for a single
+	// notification by passing a Configuration as rawData.
+	DisableStacktraces bool
+	// StackFrameFilter is called with each frame of the stacktraces captured
+	// for an event, and frames for which it returns false are left out of
+	// the event, e.g. to keep frames from packages which handle secrets out
+	// of Bugsnag. Whether the remaining frames are in the project is not
+	// affected. It isn't called for a []StackFrame passed as rawData.
+	StackFrameFilter func(frame *errors.StackFrame) bool
+	// StackSkip is the number of extra frames to leave off the top of the
+	// stacktraces captured by Notify, for libraries which wrap Notify and
+	// don't want their own frames at the top of every event. It is not
+	// applied to errors which already have a stacktrace, and is ignored if
+	// it would leave no frames. A StackSkip passed as rawData is used instead.
+	StackSkip int
+	// MaxMetaDataDepth is how many levels of maps, slices and structs can be
+	// nested within a meta-data tab before being replaced with
+	// "[MAX DEPTH REACHED]", which limits the size of events containing
+	// large data structures. This defaults to DefaultMaxMetaDataDepth.
+	MaxMetaDataDepth int
+	// MaxMessageBytes is the longest an event's Message can be. Longer
+	// messages, such as errors containing a whole SQL statement or JSON
+	// document, are cut short at a UTF-8 character boundary and end with
+	// "…[truncated]". This defaults to DefaultMaxMessageBytes.
+	MaxMessageBytes int
+	// MaxBreadcrumbs is the most breadcrumbs which are kept for each session
+	// and sent with each event. Defaults to DefaultMaxBreadcrumbs.
+	MaxBreadcrumbs int
+	// PreserveFullMessage adds the whole message of an event whose Message
+	// was truncated to the "error" tab, under "fullMessage".
+	PreserveFullMessage bool
+	// MetricsObserver is told about the outcome of each notification, e.g.
+	// for monitoring how many events are being dropped or failing to send.
+	MetricsObserver MetricsObserver
+	// OnError is called when the notifier itself fails, e.g. because an event
+	// couldn't be delivered or sessions couldn't be sent, so that the failure
+	// can be reported to your own alerting. The kind is one of the ErrorKind
+	// constants. Failures are always logged to the Logger as well.
+	OnError func(err error, kind string)
+	// OnEventDropped is called for each event which doesn't reach Bugsnag,
+	// with the reason it was dropped, e.g. for auditing lost events. It's
+	// called for every reason a MetricsObserver is told about, and also with
+	// DropReasonDeliveryFailed when the event couldn't be delivered. It may
+	// be called concurrently, from the goroutine delivering the event.
+	OnEventDropped func(event *Event, reason DropReason)
 	// Whether bugsnag should notify synchronously. This defaults to false which
 	// causes bugsnag-go to spawn a new goroutine for each notification.
 	Synchronous bool
+	// SynchronousPanics can be set to false to send unhandled panics in the
+	// background like other events. Otherwise AutoNotify waits for the event
+	// to be delivered before repanicking, as does Recover for a panic it's
+	// told is unhandled, so that the crash report isn't lost when the process
+	// exits. Sessions are then flushed too, unless FlushSessionsOnRepanic(false)
+	// has been called. This will default to true, but is stored as an
+	// interface to enable us to detect when this option has not been set.
+	SynchronousPanics interface{}
+	// InstallShutdownHandler calls OnShutdown when the process receives
+	// SIGTERM or SIGINT, to send the events and sessions which would
+	// otherwise be lost, and then raises the signal again so that the process
+	// exits as usual. Applications which handle these signals themselves
+	// should call OnShutdown from their handler instead.
+	InstallShutdownHandler bool
+	// DryRun causes reports to be written to the Logger instead of being sent
+	// to Bugsnag, so that you can see what would be sent during development
+	// without needing an API key. Middleware is still run as normal.
+	DryRun bool
+	// BatchWindow enables batching of asynchronous notifications when set.
+	// Events are collected for up to this long and then sent to Bugsnag in a
+	// single request. Each notifier's events are batched separately.
+	// Unhandled events are always sent immediately, along with any events
+	// already waiting, and the error of delivering them is returned when
+	// Synchronous is set. Call bugsnag.Flush() to send waiting events, e.g.
+	// before shutting down.
+	BatchWindow time.Duration
+	// DedupWindow enables de-duplication of identical events when set. The
+	// first event is sent immediately, and identical events sent within this
+	// long afterwards are only counted. When the window closes, the number
+	// of duplicates is sent in the "duplicates" tab of an event with the
+	// error class, message and stacktrace of the first, if there were any.
+	DedupWindow time.Duration
+	// DedupKeyFunc returns the key which identifies identical events for
+	// de-duplication. By default events are identical if they have the same
+	// error class, message and top stack frame.
+	DedupKeyFunc func(event *Event) string
+	// MaxBatchSize is the number of events which causes a batch to be sent
+	// before the BatchWindow has passed. Defaults to DefaultMaxBatchSize.
+	MaxBatchSize int
+	// MaxDeliveryConcurrency limits how many asynchronous deliveries can be
+	// in progress at once, so that an error storm can't start an unbounded
+	// number of goroutines. There is no limit when zero. What happens to
+	// events beyond the limit is decided by DeliveryOverflow.
+	MaxDeliveryConcurrency int
+	// DeliveryOverflow decides whether events are dropped, block Notify or
+	// are queued when MaxDeliveryConcurrency deliveries are in progress. This
+	// defaults to DeliveryOverflowDrop.
+	DeliveryOverflow DeliveryOverflowPolicy
+	// NotifyNilBehavior decides what Notify does when it's called with a nil
+	// error. This defaults to NotifyNilIgnore, which returns nil without
+	// logging, so that Notify can be called unconditionally with the result
+	// of an operation. Before this option existed, Notify logged an error
+	// and returned one, which NotifyNilError restores.
+	NotifyNilBehavior NotifyNilPolicy
+	// MaxDeliveryQueue is the number of events which can be queued when
+	// DeliveryOverflow is DeliveryOverflowQueue. Defaults to
+	// DefaultMaxDeliveryQueue.
+	MaxDeliveryQueue int
+	// Profiles are named variations of the configuration, such as for each
+	// environment the application is deployed to, which are switched between
+	// with bugsnag.UseProfile. Fields which are set in a profile replace those
+	// of the configuration it's applied to, as for Configure.
+	Profiles map[string]Configuration
 	// Whether the notifier should send all sessions recorded so far to Bugsnag
 	// when repanicking to ensure that no session information is lost in a
-	// fatal crash.
-	flushSessionsOnRepanic bool
+	// fatal crash. This defaults to true when nil, so that merging a
+	// configuration only changes it once FlushSessionsOnRepanic is called.
+	flushSessionsOnRepanic *bool
+	// env holds the values of the EnvAllowlist variables which were set.
+	env map[string]string
+	// now returns the current time, used wherever timestamps are generated.
+	// This defaults to time.Now and is only replaced in tests.
+	now func() time.Time
 	// TODO: remember to update the update() function when modifying this struct
 }
 
@@ -121,6 +486,18 @@ func (config *Configuration) update(other *Configuration) *Configuration {
 	if other.AppVersion != "" {
 		config.AppVersion = other.AppVersion
 	}
+	if other.AppPhase != "" {
+		config.AppPhase = other.AppPhase
+	}
+	if other.AppBuildID != "" {
+		config.AppBuildID = other.AppBuildID
+	}
+	if other.GitCommit != "" {
+		config.GitCommit = other.GitCommit
+	}
+	if other.DeployID != "" {
+		config.DeployID = other.DeployID
+	}
 	if other.SourceRoot != "" {
 		config.SourceRoot = other.SourceRoot
 		// Use '/' as the separator as Go stacktraces are printed with '/' as
@@ -129,12 +506,40 @@ func (config *Configuration) update(other *Configuration) *Configuration {
 			config.SourceRoot = strings.Replace(config.SourceRoot, "\\", "/", -1)
 		}
 	}
+	if other.MainModulePath != "" {
+		config.MainModulePath = other.MainModulePath
+	}
 	if other.ReleaseStage != "" {
 		config.ReleaseStage = other.ReleaseStage
 	}
 	if other.ParamsFilters != nil {
 		config.ParamsFilters = other.ParamsFilters
 	}
+	if other.MaxRequestBodySize != 0 {
+		config.MaxRequestBodySize = other.MaxRequestBodySize
+	}
+	if other.SkipRequestBodyContentTypes != nil {
+		config.SkipRequestBodyContentTypes = other.SkipRequestBodyContentTypes
+	}
+	if other.Profiles != nil {
+		config.Profiles = other.Profiles
+	}
+	if other.EnvAllowlist != nil {
+		config.EnvAllowlist = other.EnvAllowlist
+		config.env = loadAllowlistedEnv(other.EnvAllowlist, os.LookupEnv)
+	}
+	if other.Tags != nil {
+		config.Tags = other.Tags
+	}
+	if other.DefaultMetaData != nil {
+		config.DefaultMetaData = other.DefaultMetaData
+	}
+	if other.MetaDataTabOrder != nil {
+		config.MetaDataTabOrder = other.MetaDataTabOrder
+	}
+	if other.ExcludeMetaDataTabs != nil {
+		config.ExcludeMetaDataTabs = other.ExcludeMetaDataTabs
+	}
 	if other.ProjectPackages != nil {
 		config.ProjectPackages = other.ProjectPackages
 		// Use '/' as the separator as Go stacktraces are printed with '/' as
@@ -151,23 +556,212 @@ func (config *Configuration) update(other *Configuration) *Configuration {
 	if other.NotifyReleaseStages != nil {
 		config.NotifyReleaseStages = other.NotifyReleaseStages
 	}
+	if other.SessionReleaseStages != nil {
+		config.SessionReleaseStages = other.SessionReleaseStages
+	}
+	if other.SessionStore != nil {
+		config.SessionStore = other.SessionStore
+	}
+	if other.IgnoreErrors != nil {
+		config.IgnoreErrors = other.IgnoreErrors
+	}
+	if other.NeverIgnoreUnhandled {
+		config.NeverIgnoreUnhandled = true
+	}
+	if other.HandledSampleRate != nil {
+		config.HandledSampleRate = other.HandledSampleRate
+	}
+	if other.UnhandledSampleRate != nil {
+		config.UnhandledSampleRate = other.UnhandledSampleRate
+	}
+	if other.MinSeverity != (severity{}) {
+		config.MinSeverity = other.MinSeverity
+	}
+	if other.SeparatePanicGrouping {
+		config.SeparatePanicGrouping = true
+	}
+	if other.GroupByRootCause {
+		config.GroupByRootCause = true
+	}
+	if other.GroupByOperation {
+		config.GroupByOperation = true
+	}
+	if other.NotifySuppressedPanics {
+		config.NotifySuppressedPanics = true
+	}
 	if other.PanicHandler != nil {
 		config.PanicHandler = other.PanicHandler
 	}
 	if other.Transport != nil {
 		config.Transport = other.Transport
+	} else if other.TransportConfig != nil {
+		config.Transport = other.TransportConfig.transport()
+	}
+	if other.TransportConfig != nil {
+		config.TransportConfig = other.TransportConfig
+	}
+	if other.UserAgent != "" {
+		config.UserAgent = other.UserAgent
+	}
+	if other.DeliveryTimeout != 0 {
+		config.DeliveryTimeout = other.DeliveryTimeout
 	}
 	if other.Synchronous {
 		config.Synchronous = true
 	}
+	if other.DryRun {
+		config.DryRun = true
+	}
+	if other.InstallShutdownHandler {
+		config.InstallShutdownHandler = true
+	}
+	if other.MaxMetaDataDepth != 0 {
+		config.MaxMetaDataDepth = other.MaxMetaDataDepth
+	}
+	if other.MaxBreadcrumbs != 0 {
+		config.MaxBreadcrumbs = other.MaxBreadcrumbs
+	}
+	if other.MaxMessageBytes != 0 {
+		config.MaxMessageBytes = other.MaxMessageBytes
+	}
+	if other.PreserveFullMessage {
+		config.PreserveFullMessage = true
+	}
+	if other.MetricsObserver != nil {
+		config.MetricsObserver = other.MetricsObserver
+	}
+	if other.OnError != nil {
+		config.OnError = other.OnError
+	}
+	if other.OnEventDropped != nil {
+		config.OnEventDropped = other.OnEventDropped
+	}
+	if other.FieldNameMapper != nil {
+		config.FieldNameMapper = other.FieldNameMapper
+	}
+	if other.PayloadEncoder != nil {
+		config.PayloadEncoder = other.PayloadEncoder
+	}
+	if other.PayloadContentType != "" {
+		config.PayloadContentType = other.PayloadContentType
+	}
+	if other.StackFrameFilter != nil {
+		config.StackFrameFilter = other.StackFrameFilter
+	}
+	if other.DisableStacktraces {
+		config.DisableStacktraces = true
+	}
+	if other.StackSkip != 0 {
+		config.StackSkip = other.StackSkip
+	}
+	if other.DedupWindow != 0 {
+		config.DedupWindow = other.DedupWindow
+	}
+	if other.DedupKeyFunc != nil {
+		config.DedupKeyFunc = other.DedupKeyFunc
+	}
+	if other.BatchWindow != 0 {
+		config.BatchWindow = other.BatchWindow
+	}
+	if other.MaxBatchSize != 0 {
+		config.MaxBatchSize = other.MaxBatchSize
+	}
+	if other.MaxDeliveryConcurrency != 0 {
+		config.MaxDeliveryConcurrency = other.MaxDeliveryConcurrency
+	}
+	if other.DeliveryOverflow != DeliveryOverflowDrop {
+		config.DeliveryOverflow = other.DeliveryOverflow
+	}
+	if other.NotifyNilBehavior != NotifyNilIgnore {
+		config.NotifyNilBehavior = other.NotifyNilBehavior
+	}
+	if other.MaxDeliveryQueue != 0 {
+		config.MaxDeliveryQueue = other.MaxDeliveryQueue
+	}
+	if other.now != nil {
+		config.now = other.now
+	}
+	if other.NotifierName != "" {
+		config.NotifierName = other.NotifierName
+	}
+	if other.NotifierVersion != "" {
+		config.NotifierVersion = other.NotifierVersion
+	}
+	if other.NotifierURL != "" {
+		config.NotifierURL = other.NotifierURL
+	}
+	if other.PayloadVersion != "" {
+		config.PayloadVersion = other.PayloadVersion
+	}
+	if other.TraceContextExtractor != nil {
+		config.TraceContextExtractor = other.TraceContextExtractor
+	}
+	if other.BaggageExtractor != nil {
+		config.BaggageExtractor = other.BaggageExtractor
+	}
+	if other.MaxBaggageBytes != 0 {
+		config.MaxBaggageBytes = other.MaxBaggageBytes
+	}
+
+	if other.ContextFunc != nil {
+		config.ContextFunc = other.ContextFunc
+	}
+	if other.UserFromRequest != nil {
+		config.UserFromRequest = other.UserFromRequest
+	}
+	if other.RequestIDFunc != nil {
+		config.RequestIDFunc = other.RequestIDFunc
+	}
+	if other.CollectGoroutineLabels {
+		config.CollectGoroutineLabels = true
+	}
+	if other.SendSQLArgs {
+		config.SendSQLArgs = true
+	}
 
 	if other.AutoCaptureSessions != nil {
 		config.AutoCaptureSessions = other.AutoCaptureSessions
 	}
+	if other.SynchronousPanics != nil {
+		config.SynchronousPanics = other.SynchronousPanics
+	}
+	if other.flushSessionsOnRepanic != nil {
+		config.flushSessionsOnRepanic = other.flushSessionsOnRepanic
+	}
 	config.updateEndpoints(&other.Endpoints)
 	return config
 }
 
+// NotifyNilPolicy decides what happens when Notify is called with a nil error.
+type NotifyNilPolicy int
+
+const (
+	// NotifyNilIgnore returns nil without notifying or logging. This is the
+	// default.
+	NotifyNilIgnore NotifyNilPolicy = iota
+	// NotifyNilLog logs that there was no error to notify, and returns nil.
+	NotifyNilLog
+	// NotifyNilError logs that there was no error to notify, and returns an
+	// error saying so.
+	NotifyNilError
+)
+
+// Validate returns an error describing why errors can't be reported to
+// Bugsnag with the configuration, e.g. because bugsnag.Configure hasn't been
+// called with an API key.
+func (config *Configuration) Validate() error {
+	if config.APIKey == "" {
+		return fmt.Errorf("Bugsnag not configured: empty API key")
+	}
+	if len(config.APIKey) != 32 {
+		return fmt.Errorf("Bugsnag not configured: invalid API key '%s'", config.APIKey)
+	}
+	if config.Endpoints.Notify == "" {
+		return fmt.Errorf("Bugsnag not configured: empty notify endpoint")
+	}
+	return nil
+}
+
 // IsAutoCaptureSessions identifies whether or not the notifier should
 // automatically capture sessions as requests come in. It's a convenience
 // wrapper that allows automatic session capturing to be enabled by default.
@@ -183,11 +777,33 @@ func (config *Configuration) IsAutoCaptureSessions() bool {
 	return false
 }
 
+// isFlushSessionsOnRepanic identifies whether sessions should be flushed when
+// AutoNotify repanics, which they are by default.
+func (config *Configuration) isFlushSessionsOnRepanic() bool {
+	if config.flushSessionsOnRepanic == nil {
+		return true // enabled by default
+	}
+	return *config.flushSessionsOnRepanic
+}
+
+// IsSynchronousPanics identifies whether unhandled panics should be delivered
+// synchronously. It's a convenience wrapper that allows this to be enabled by
+// default.
+func (config *Configuration) IsSynchronousPanics() bool {
+	if config.SynchronousPanics == nil {
+		return true // enabled by default
+	}
+	if val, ok := config.SynchronousPanics.(bool); ok {
+		return val
+	}
+	return false
+}
+
 func (config *Configuration) updateEndpoints(endpoints *Endpoints) {
 	if endpoints.Notify != "" {
 		config.Endpoints.Notify = endpoints.Notify
 		if endpoints.Sessions == "" {
-			config.Logger.Printf("WARNING: Bugsnag notify endpoint configured without also configuring the sessions endpoint. No sessions will be recorded")
+			config.logf("WARNING: Bugsnag notify endpoint configured without also configuring the sessions endpoint. No sessions will be recorded")
 			config.Endpoints.Sessions = ""
 		}
 	}
@@ -197,17 +813,58 @@ func (config *Configuration) updateEndpoints(endpoints *Endpoints) {
 		}
 		config.Endpoints.Sessions = endpoints.Sessions
 	}
+	// Changing the notify endpoint without the build endpoint disables
+	// release reporting, to avoid leaking releases of on-premise installations
+	if endpoints.Build != "" || endpoints.Notify != "" {
+		config.Endpoints.Build = endpoints.Build
+	}
 }
 
 func (config *Configuration) merge(other *Configuration) *Configuration {
-	return config.clone().update(other)
+	return config.Clone().update(other)
+}
+
+// cloneConfig copies the configuration, which may be the global
+// configuration while Reconfigure is changing it.
+func cloneConfig(config *Configuration) *Configuration {
+	if config == &Config {
+		configMutex.RLock()
+		defer configMutex.RUnlock()
+	}
+	return config.Clone()
 }
 
-func (config *Configuration) clone() *Configuration {
+// Clone returns a copy of the configuration, which can be changed without
+// affecting the original. The copy is shallow: slices, maps and functions
+// are shared with the original, so replace them rather than modifying them
+// in place.
+func (config *Configuration) Clone() *Configuration {
 	clone := *config
 	return &clone
 }
 
+// Merge applies the fields which are set in other to the configuration, in
+// the same way as bugsnag.Configure, so that libraries wrapping bugsnag can
+// layer their defaults and a user's configuration predictably. Fields which
+// are unset in other are left as they are, where unset means:
+//
+//   - an empty string, or a zero number or time.Duration
+//   - a nil slice, map, function, interface or pointer. An empty but non-nil
+//     slice or map does override, e.g. to clear the ParamsFilters
+//   - false for bool fields, so Merge can enable but not disable them.
+//     AutoCaptureSessions and SynchronousPanics are interface{} values so
+//     that they can be disabled by merging false, as are the sample rates so
+//     that they can be set to 0
+//   - DeliveryOverflowDrop for the DeliveryOverflow, and NotifyNilIgnore for
+//     the NotifyNilBehavior
+//
+// Endpoints are merged as by Configure: setting the Notify endpoint without
+// the Sessions endpoint disables session tracking, and setting the Sessions
+// endpoint without the Notify endpoint panics.
+func (config *Configuration) Merge(other *Configuration) {
+	config.update(other)
+}
+
 func (config *Configuration) isProjectPackage(_pkg string) bool {
 	sep := string(filepath.Separator)
 	// filepath functions only work if the contents of the paths use the system
@@ -231,6 +888,41 @@ func (config *Configuration) isProjectPackage(_pkg string) bool {
 	return false
 }
 
+// moduleCacheDir is the part of file names in the module cache which comes
+// before the module path, e.g. "/home/ci/go/pkg/mod/" in
+// "/home/ci/go/pkg/mod/github.com/pkg/errors@v0.9.1/errors.go".
+const moduleCacheDir = "/pkg/mod/"
+
+// trimFilePath removes the parts of the file name of a stack frame in the
+// given package which depend on where the application was built.
+func (config *Configuration) trimFilePath(file, pkg string) string {
+	// This will trim path before package name for external packages and golang default packages
+	// Excluding main package as it's special case
+	// This will NOT trim paths for packages in current module because path won't contain the package name
+	// Example: path is "/user/name/work/internal/internal.go" and module package name is "example.com/mymodule/internal"
+	if idx := strings.Index(file, pkg); idx > -1 && pkg != "main" {
+		return file[idx:]
+	}
+
+	// Paths of packages in the module cache don't contain the package name
+	// when the module has a major version suffix or an upper case letter,
+	// so trim everything up to the module path instead
+	if idx := strings.Index(file, moduleCacheDir); idx > -1 {
+		return file[idx+len(moduleCacheDir):]
+	}
+
+	// Make paths in the main module relative to the module root, unless
+	// they are within the SourceRoot, which is trimmed with ProjectPackages
+	if config.MainModulePath != "" && (config.SourceRoot == "" || !strings.HasPrefix(file, config.SourceRoot)) {
+		if rel := strings.TrimPrefix(pkg, config.MainModulePath); rel != pkg && (rel == "" || rel[0] == '/') {
+			if dir := path.Dir(file); strings.HasSuffix(dir, rel) {
+				return strings.TrimPrefix(file, dir[:len(dir)-len(rel)]+"/")
+			}
+		}
+	}
+	return file
+}
+
 func (config *Configuration) stripProjectPackages(file string) string {
 	trimmedFile := strings.TrimPrefix(file, config.SourceRoot)
 	for _, p := range config.ProjectPackages {
@@ -249,6 +941,109 @@ func (config *Configuration) stripProjectPackages(file string) string {
 	return trimmedFile
 }
 
+// DefaultMaxMetaDataDepth is the default value of
+// Configuration.MaxMetaDataDepth.
+var DefaultMaxMetaDataDepth = 10
+
+func (config *Configuration) maxMetaDataDepth() int {
+	if config.MaxMetaDataDepth > 0 {
+		return config.MaxMetaDataDepth
+	}
+	return DefaultMaxMetaDataDepth
+}
+
+// sampleRandom returns a random number in [0, 1) for sampling events. It is
+// only replaced in tests.
+var sampleRandom = rand.Float64
+
+// sampled decides whether to send the event according to the sample rate for
+// whether it's handled.
+func (config *Configuration) sampled(event *Event) bool {
+	rate := sampleRate(config.HandledSampleRate)
+	if event.Unhandled {
+		rate = sampleRate(config.UnhandledSampleRate)
+	}
+	if rate <= 0 {
+		return false
+	}
+	if rate >= 1 {
+		return true
+	}
+	return sampleRandom() < rate
+}
+
+// sampleRate converts a HandledSampleRate or UnhandledSampleRate to a
+// number. Rates which are unset, or aren't numbers, send every event.
+func sampleRate(rate interface{}) float64 {
+	switch rate := rate.(type) {
+	case float64:
+		return rate
+	case float32:
+		return float64(rate)
+	case int:
+		return float64(rate)
+	default:
+		return 1 // enabled by default
+	}
+}
+
+// severeEnough decides whether the event is at least the MinSeverity, which
+// unhandled events always are.
+func (config *Configuration) severeEnough(event *Event) bool {
+	if event.Unhandled || config.MinSeverity == (severity{}) {
+		return true
+	}
+	return event.Severity.rank() >= config.MinSeverity.rank()
+}
+
+// DefaultMaxMessageBytes is the default value of
+// Configuration.MaxMessageBytes.
+var DefaultMaxMessageBytes = 10 * 1024
+
+func (config *Configuration) maxBreadcrumbs() int {
+	if config.MaxBreadcrumbs > 0 {
+		return config.MaxBreadcrumbs
+	}
+	return DefaultMaxBreadcrumbs
+}
+
+func (config *Configuration) maxBaggageBytes() int {
+	if config.MaxBaggageBytes > 0 {
+		return config.MaxBaggageBytes
+	}
+	return DefaultMaxBaggageBytes
+}
+
+func (config *Configuration) maxMessageBytes() int {
+	if config.MaxMessageBytes > 0 {
+		return config.MaxMessageBytes
+	}
+	return DefaultMaxMessageBytes
+}
+
+// currentTime returns the current time from the configured clock.
+func (config *Configuration) currentTime() time.Time {
+	if config.now != nil {
+		return config.now()
+	}
+	return time.Now()
+}
+
+// notifierVersion returns the version of the notifier sent in reports.
+func (config *Configuration) notifierVersion() string {
+	if config.NotifierVersion != "" {
+		return config.NotifierVersion
+	}
+	return Version
+}
+
+func (config *Configuration) userAgent() string {
+	if config.UserAgent != "" {
+		return config.UserAgent
+	}
+	return "bugsnag-go/" + config.notifierVersion()
+}
+
 func (config *Configuration) logf(fmt string, args ...interface{}) {
 	if config != nil && config.Logger != nil {
 		config.Logger.Printf(fmt, args...)
@@ -257,6 +1052,22 @@ func (config *Configuration) logf(fmt string, args ...interface{}) {
 	}
 }
 
+// reportError passes a failure of the notifier to the OnError callback, if
+// one is configured.
+func (config *Configuration) reportError(err error, kind string) {
+	if config.OnError != nil {
+		config.OnError(err, kind)
+	}
+}
+
+// sessionReleaseStages returns the release stages to send sessions in.
+func (config *Configuration) sessionReleaseStages() []string {
+	if config.SessionReleaseStages != nil {
+		return config.SessionReleaseStages
+	}
+	return config.NotifyReleaseStages
+}
+
 func (config *Configuration) notifyInReleaseStage() bool {
 	if config.NotifyReleaseStages == nil {
 		return true
@@ -272,59 +1083,132 @@ func (config *Configuration) notifyInReleaseStage() bool {
 	return false
 }
 
+// shouldIgnore reports whether the event matches any of the IgnoreErrors,
+// checking the notified error and each of the errors it wraps.
+func (config *Configuration) shouldIgnore(event *Event) bool {
+	if len(config.IgnoreErrors) == 0 || event.Error == nil {
+		return false
+	}
+	if event.Unhandled && config.NeverIgnoreUnhandled {
+		return false
+	}
+	for err := event.Error.Err; err != nil; err = unwrapError(err) {
+		for _, ignore := range config.IgnoreErrors {
+			if ignore(err) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func unwrapError(err error) error {
+	if wrapper, ok := err.(interface{ Unwrap() error }); ok {
+		return wrapper.Unwrap()
+	}
+	return nil
+}
+
 func (config *Configuration) loadEnv() {
-	envConfig := Configuration{}
-	if apiKey := os.Getenv("BUGSNAG_API_KEY"); apiKey != "" {
+	envConfig, loaded, invalid := loadEnvConfig(os.Getenv)
+	for _, message := range invalid {
+		config.logf("bugsnag: ignoring invalid environment variable %s", message)
+	}
+	if len(loaded) > 0 {
+		config.logf("bugsnag: loaded configuration from environment variables %s", strings.Join(loaded, ", "))
+	}
+
+	metadata := loadEnvMetadata(os.Environ())
+	OnBeforeNotify(func(event *Event, config *Configuration) error {
+		for _, m := range metadata {
+			event.MetaData.Add(m.tab, m.key, m.value)
+		}
+
+		return nil
+	})
+
+	config.update(&envConfig)
+}
+
+// loadEnvConfig reads the configuration from the environment, using getenv to
+// look up each variable. It returns the names of the variables which were
+// used, and a description of any which were set but ignored as invalid.
+func loadEnvConfig(getenv func(string) string) (envConfig Configuration, loaded []string, invalid []string) {
+	lookup := func(name string) string {
+		value := getenv(name)
+		if value != "" {
+			loaded = append(loaded, name)
+		}
+		return value
+	}
+	reject := func(name, value, expected string) {
+		loaded = loaded[:len(loaded)-1]
+		invalid = append(invalid, fmt.Sprintf("%s=%q, expected %s", name, value, expected))
+	}
+	lookupEndpoint := func(name string) string {
+		endpoint := lookup(name)
+		if endpoint == "" {
+			return ""
+		}
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			reject(name, endpoint, "an http or https URL")
+			return ""
+		}
+		return endpoint
+	}
+	lookupBool := func(name string) (value bool, ok bool) {
+		switch flag := lookup(name); flag {
+		case "":
+			return false, false
+		case "0", "1":
+			return flag == "1", true
+		default:
+			reject(name, flag, "0 or 1")
+			return false, false
+		}
+	}
+
+	if apiKey := lookup("BUGSNAG_API_KEY"); apiKey != "" {
 		envConfig.APIKey = apiKey
 	}
-	if endpoint := os.Getenv("BUGSNAG_SESSIONS_ENDPOINT"); endpoint != "" {
+	if endpoint := lookupEndpoint("BUGSNAG_SESSIONS_ENDPOINT"); endpoint != "" {
 		envConfig.Endpoints.Sessions = endpoint
 	}
-	if endpoint := os.Getenv("BUGSNAG_NOTIFY_ENDPOINT"); endpoint != "" {
+	if endpoint := lookupEndpoint("BUGSNAG_NOTIFY_ENDPOINT"); endpoint != "" {
 		envConfig.Endpoints.Notify = endpoint
 	}
-	if stage := os.Getenv("BUGSNAG_RELEASE_STAGE"); stage != "" {
+	if stage := lookup("BUGSNAG_RELEASE_STAGE"); stage != "" {
 		envConfig.ReleaseStage = stage
 	}
-	if appVersion := os.Getenv("BUGSNAG_APP_VERSION"); appVersion != "" {
+	if appVersion := lookup("BUGSNAG_APP_VERSION"); appVersion != "" {
 		envConfig.AppVersion = appVersion
 	}
-	if hostname := os.Getenv("BUGSNAG_HOSTNAME"); hostname != "" {
+	if hostname := lookup("BUGSNAG_HOSTNAME"); hostname != "" {
 		envConfig.Hostname = hostname
 	}
-	if sourceRoot := os.Getenv("BUGSNAG_SOURCE_ROOT"); sourceRoot != "" {
+	if sourceRoot := lookup("BUGSNAG_SOURCE_ROOT"); sourceRoot != "" {
 		envConfig.SourceRoot = sourceRoot
 	}
-	if appType := os.Getenv("BUGSNAG_APP_TYPE"); appType != "" {
+	if appType := lookup("BUGSNAG_APP_TYPE"); appType != "" {
 		envConfig.AppType = appType
 	}
-	if stages := os.Getenv("BUGSNAG_NOTIFY_RELEASE_STAGES"); stages != "" {
+	if stages := lookup("BUGSNAG_NOTIFY_RELEASE_STAGES"); stages != "" {
 		envConfig.NotifyReleaseStages = strings.Split(stages, ",")
 	}
-	if packages := os.Getenv("BUGSNAG_PROJECT_PACKAGES"); packages != "" {
+	if packages := lookup("BUGSNAG_PROJECT_PACKAGES"); packages != "" {
 		envConfig.ProjectPackages = strings.Split(packages, ",")
 	}
-	if synchronous := os.Getenv("BUGSNAG_SYNCHRONOUS"); synchronous != "" {
-		envConfig.Synchronous = synchronous == "1"
+	if synchronous, ok := lookupBool("BUGSNAG_SYNCHRONOUS"); ok {
+		envConfig.Synchronous = synchronous
 	}
-	if disablePanics := os.Getenv("BUGSNAG_DISABLE_PANIC_HANDLER"); disablePanics == "1" {
+	if disablePanics, ok := lookupBool("BUGSNAG_DISABLE_PANIC_HANDLER"); ok && disablePanics {
 		envConfig.PanicHandler = func() {}
 	}
-	if autoSessions := os.Getenv("BUGSNAG_AUTO_CAPTURE_SESSIONS"); autoSessions != "" {
-		envConfig.AutoCaptureSessions = autoSessions == "1"
+	if autoSessions, ok := lookupBool("BUGSNAG_AUTO_CAPTURE_SESSIONS"); ok {
+		envConfig.AutoCaptureSessions = autoSessions
 	}
-	if filters := os.Getenv("BUGSNAG_PARAMS_FILTERS"); filters != "" {
+	if filters := lookup("BUGSNAG_PARAMS_FILTERS"); filters != "" {
 		envConfig.ParamsFilters = strings.Split(filters, ",")
 	}
-
-	metadata := loadEnvMetadata(os.Environ())
-	OnBeforeNotify(func(event *Event, config *Configuration) error {
-		for _, m := range metadata {
-			event.MetaData.Add(m.tab, m.key, m.value)
-		}
-
-		return nil
-	})
-
-	config.update(&envConfig)
+	return envConfig, loaded, invalid
 }
@@ -1,11 +1,23 @@
 package bugsnag
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/bugsnag/bugsnag-go/v2/errors"
+	"github.com/bugsnag/bugsnag-go/v2/sessions"
 )
 
 func TestNotifyReleaseStages(t *testing.T) {
@@ -184,6 +196,37 @@ func TestStripCustomSourceRoot(t *testing.T) {
 	}
 }
 
+func TestTrimFilePath(t *testing.T) {
+	config := &Configuration{SourceRoot: "/home/ci/go/src/", MainModulePath: "example.com/myapp"}
+	var testCases = []struct {
+		File     string
+		Package  string
+		Expected string
+	}{
+		// GOPATH builds
+		{"/home/ci/go/src/github.com/pkg/errors/errors.go", "github.com/pkg/errors", "github.com/pkg/errors/errors.go"},
+		{"/home/ci/go/src/example.com/myapp/internal/db.go", "example.com/myapp/internal", "example.com/myapp/internal/db.go"},
+		{"/home/ci/go/src/example.com/myapp/main.go", "main", "/home/ci/go/src/example.com/myapp/main.go"},
+		{"/usr/local/go/src/net/http/server.go", "net/http", "net/http/server.go"},
+
+		// Module builds
+		{"/home/ci/go/pkg/mod/github.com/pkg/errors@v0.9.1/errors.go", "github.com/pkg/errors", "github.com/pkg/errors@v0.9.1/errors.go"},
+		{"/home/ci/go/pkg/mod/github.com/bugsnag/bugsnag-go/v2@v2.4.0/notifier.go", "github.com/bugsnag/bugsnag-go/v2", "github.com/bugsnag/bugsnag-go/v2@v2.4.0/notifier.go"},
+		{"/home/ci/go/pkg/mod/github.com/!burnt!sushi/toml@v1.3.2/decode.go", "github.com/BurntSushi/toml", "github.com/!burnt!sushi/toml@v1.3.2/decode.go"},
+		{"/builds/myapp/internal/store/db.go", "example.com/myapp/internal/store", "internal/store/db.go"},
+		{"/builds/myapp/app.go", "example.com/myapp", "app.go"},
+		{"/builds/myapp/main.go", "main", "/builds/myapp/main.go"},
+		{"/builds/other/store/db.go", "example.com/myapp/internal/store", "/builds/other/store/db.go"},
+		{"/builds/myappx/db.go", "example.com/myappx", "/builds/myappx/db.go"},
+	}
+
+	for _, tc := range testCases {
+		if got := config.trimFilePath(tc.File, tc.Package); got != tc.Expected {
+			t.Errorf("Expected '%s' in package '%s' to be trimmed to '%s' but was '%s'", tc.File, tc.Package, tc.Expected, got)
+		}
+	}
+}
+
 func TestStripCustomWindowsSourceRoot(t *testing.T) {
 	if runtime.GOOS != "windows" {
 		t.Skip("not compatible with non-windows builds")
@@ -378,3 +421,602 @@ func TestIsAutoCaptureSessions(t *testing.T) {
 		t.Errorf("Expected automatic session tracking to be disabled when so configured, but enabled")
 	}
 }
+
+func TestShouldIgnore(t *testing.T) {
+	config := &Configuration{IgnoreErrors: []func(error) bool{
+		func(err error) bool { return err == context.Canceled },
+		func(err error) bool { return err == io.EOF },
+	}}
+
+	for _, tc := range []struct {
+		name      string
+		err       error
+		unhandled bool
+		exempt    bool
+		expected  bool
+	}{
+		{name: "direct match", err: context.Canceled, expected: true},
+		{name: "wrapped match", err: fmt.Errorf("reading body: %w", io.EOF), expected: true},
+		{name: "no match", err: fmt.Errorf("something else"), expected: false},
+		{name: "unhandled match", err: io.EOF, unhandled: true, expected: true},
+		{name: "exempt unhandled match", err: io.EOF, unhandled: true, exempt: true, expected: false},
+		{name: "exempt handled match", err: io.EOF, exempt: true, expected: true},
+	} {
+		t.Run(tc.name, func(st *testing.T) {
+			config.NeverIgnoreUnhandled = tc.exempt
+			event := &Event{Error: errors.New(tc.err, 0), Unhandled: tc.unhandled}
+			if got := config.shouldIgnore(event); got != tc.expected {
+				st.Errorf("Expected shouldIgnore to be %v but was %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+// setClock replaces the clock used for timestamps by the global configuration
+// and session tracking, returning a function which restores time.Now.
+func setClock(now func() time.Time) func() {
+	Config.now = now
+	sessionTrackingConfig.Update(&sessions.SessionTrackingConfiguration{Clock: now})
+	return func() {
+		Config.now = nil
+		sessionTrackingConfig.Update(&sessions.SessionTrackingConfiguration{Clock: time.Now})
+	}
+}
+
+func TestLoadEnvConfig(t *testing.T) {
+	env := map[string]string{
+		"BUGSNAG_API_KEY":               "166f5ad3590596f9aa8d601ea89af845",
+		"BUGSNAG_RELEASE_STAGE":         "staging",
+		"BUGSNAG_APP_VERSION":           "1.2.3",
+		"BUGSNAG_NOTIFY_RELEASE_STAGES": "staging,production",
+		"BUGSNAG_NOTIFY_ENDPOINT":       "https://notify.example.com",
+		"BUGSNAG_SESSIONS_ENDPOINT":     "sessions.example.com",
+		"BUGSNAG_SYNCHRONOUS":           "1",
+		"BUGSNAG_AUTO_CAPTURE_SESSIONS": "yes",
+	}
+	envConfig, loaded, invalid := loadEnvConfig(func(name string) string { return env[name] })
+
+	if envConfig.APIKey != env["BUGSNAG_API_KEY"] || envConfig.ReleaseStage != "staging" || envConfig.AppVersion != "1.2.3" {
+		t.Errorf("Unexpected configuration from the environment '%+v'", envConfig)
+	}
+	if exp := []string{"staging", "production"}; !reflect.DeepEqual(envConfig.NotifyReleaseStages, exp) {
+		t.Errorf("Expected notify release stages to be '%v' but was '%v'", exp, envConfig.NotifyReleaseStages)
+	}
+	if exp := (Endpoints{Notify: "https://notify.example.com"}); envConfig.Endpoints != exp {
+		t.Errorf("Expected endpoints to be '%+v' but was '%+v'", exp, envConfig.Endpoints)
+	}
+	if !envConfig.Synchronous || envConfig.AutoCaptureSessions != nil {
+		t.Errorf("Expected only valid flags to be loaded but got synchronous '%v' and auto capture sessions '%v'", envConfig.Synchronous, envConfig.AutoCaptureSessions)
+	}
+
+	expLoaded := []string{"BUGSNAG_API_KEY", "BUGSNAG_NOTIFY_ENDPOINT", "BUGSNAG_RELEASE_STAGE", "BUGSNAG_APP_VERSION", "BUGSNAG_NOTIFY_RELEASE_STAGES", "BUGSNAG_SYNCHRONOUS"}
+	if !reflect.DeepEqual(loaded, expLoaded) {
+		t.Errorf("Expected loaded variables to be '%v' but was '%v'", expLoaded, loaded)
+	}
+	expInvalid := []string{
+		`BUGSNAG_SESSIONS_ENDPOINT="sessions.example.com", expected an http or https URL`,
+		`BUGSNAG_AUTO_CAPTURE_SESSIONS="yes", expected 0 or 1`,
+	}
+	if !reflect.DeepEqual(invalid, expInvalid) {
+		t.Errorf("Expected invalid variables to be '%v' but was '%v'", expInvalid, invalid)
+	}
+
+	// Values set in code take precedence over the environment
+	config := Configuration{Logger: log.New(ioutil.Discard, "", 0)}
+	config.update(&envConfig)
+	config.update(&Configuration{ReleaseStage: "production"})
+	if config.ReleaseStage != "production" || config.AppVersion != "1.2.3" {
+		t.Errorf("Expected code to override the environment but got release stage '%s' and app version '%s'", config.ReleaseStage, config.AppVersion)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		config Configuration
+		exp    string
+	}{
+		{config: Configuration{APIKey: testAPIKey, Endpoints: Endpoints{Notify: "https://notify.bugsnag.com"}}, exp: ""},
+		{config: Configuration{Endpoints: Endpoints{Notify: "https://notify.bugsnag.com"}}, exp: "Bugsnag not configured: empty API key"},
+		{config: Configuration{APIKey: "abc123", Endpoints: Endpoints{Notify: "https://notify.bugsnag.com"}}, exp: "Bugsnag not configured: invalid API key 'abc123'"},
+		{config: Configuration{APIKey: testAPIKey}, exp: "Bugsnag not configured: empty notify endpoint"},
+	} {
+		got := ""
+		if err := tc.config.Validate(); err != nil {
+			got = err.Error()
+		}
+		if got != tc.exp {
+			t.Errorf("Expected validating %+v to give '%s' but got '%s'", tc.config, tc.exp, got)
+		}
+	}
+}
+
+func TestNotifyWithoutAPIKey(t *testing.T) {
+	ts, reports := setup()
+	defer ts.Close()
+	var logged strings.Builder
+	config := generateSampleConfig(ts.URL)
+	config.Synchronous = true
+	config.Logger = log.New(&logged, "", 0)
+	notifier := New(config)
+	notifier.Config.APIKey = ""
+
+	err := notifier.Notify(fmt.Errorf("oops"))
+	if err == nil || err.Error() != "Bugsnag not configured: empty API key" {
+		t.Errorf("Expected an empty API key error but got '%v'", err)
+	}
+	if !strings.Contains(logged.String(), "bugsnag.Notify: Bugsnag not configured: empty API key") {
+		t.Errorf("Expected the error to be logged but logged '%s'", logged.String())
+	}
+	select {
+	case <-reports:
+		t.Errorf("Expected no request to be made without an API key")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	notifier.Config.DryRun = true
+	logged.Reset()
+	if err := notifier.Notify(fmt.Errorf("oops")); err != nil && strings.Contains(err.Error(), "API key") {
+		t.Errorf("Expected dry runs not to need an API key but got '%v'", err)
+	}
+}
+
+func TestNotifyWithAPIKeySetByCallback(t *testing.T) {
+	resetCircuitBreakers(t)
+	ts, reports := setup()
+	defer ts.Close()
+	config := generateSampleConfig(ts.URL)
+	config.Synchronous = true
+	config.NotifyReleaseStages = []string{"test"}
+	notifier := New(config)
+	notifier.Config.APIKey = ""
+	defer middleware.Remove(middleware.add(func(event *Event, config *Configuration) error {
+		config.APIKey = testAPIKey
+		return nil
+	}, false))
+
+	if err := notifier.Notify(fmt.Errorf("oops")); err != nil {
+		t.Fatalf("Expected the API key set by the callback to be used but got '%v'", err)
+	}
+	select {
+	case <-reports:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the event to be sent with the API key set by the callback")
+	}
+}
+
+func TestSessionReleaseStages(t *testing.T) {
+	defer func(c Configuration, stages []string) {
+		Config = c
+		sessionTrackingConfig.NotifyReleaseStages = stages
+	}(*Config.Clone(), sessionTrackingConfig.NotifyReleaseStages)
+
+	Config.update(&Configuration{ReleaseStage: "staging", NotifyReleaseStages: []string{"staging", "production"}})
+	Config.SessionReleaseStages = nil
+	updateSessionConfig()
+	if exp := []string{"staging", "production"}; !reflect.DeepEqual(sessionTrackingConfig.NotifyReleaseStages, exp) {
+		t.Errorf("Expected sessions to default to the notify release stages '%v' but were '%v'", exp, sessionTrackingConfig.NotifyReleaseStages)
+	}
+
+	Config.update(&Configuration{SessionReleaseStages: []string{"production"}})
+	updateSessionConfig()
+	if !Config.notifyInReleaseStage() {
+		t.Errorf("Expected errors to still be notified in staging")
+	}
+	if exp := []string{"production"}; !reflect.DeepEqual(sessionTrackingConfig.NotifyReleaseStages, exp) {
+		t.Errorf("Expected sessions to only be sent in '%v' but were sent in '%v'", exp, sessionTrackingConfig.NotifyReleaseStages)
+	}
+}
+
+func TestSessionAppInfo(t *testing.T) {
+	defer func(c Configuration, s *sessions.SessionTrackingConfiguration) {
+		Config = c
+		sessionTrackingConfig.AppVersion, sessionTrackingConfig.ReleaseStage = s.AppVersion, s.ReleaseStage
+		sessionTrackingConfig.AppBuildID, sessionTrackingConfig.GitCommit, sessionTrackingConfig.DeployID = s.AppBuildID, s.GitCommit, s.DeployID
+	}(*Config.Clone(), &sessions.SessionTrackingConfiguration{
+		AppVersion:   sessionTrackingConfig.AppVersion,
+		ReleaseStage: sessionTrackingConfig.ReleaseStage,
+		AppBuildID:   sessionTrackingConfig.AppBuildID,
+		GitCommit:    sessionTrackingConfig.GitCommit,
+		DeployID:     sessionTrackingConfig.DeployID,
+	})
+
+	Config.update(&Configuration{AppVersion: "3.1.4", ReleaseStage: "canary", AppBuildID: "ci-42", GitCommit: "c0ffee", DeployID: "deploy-9"})
+	updateSessionConfig()
+	for name, tc := range map[string][2]string{
+		"AppVersion":   {sessionTrackingConfig.AppVersion, "3.1.4"},
+		"ReleaseStage": {sessionTrackingConfig.ReleaseStage, "canary"},
+		"AppBuildID":   {sessionTrackingConfig.AppBuildID, "ci-42"},
+		"GitCommit":    {sessionTrackingConfig.GitCommit, "c0ffee"},
+		"DeployID":     {sessionTrackingConfig.DeployID, "deploy-9"},
+	} {
+		if tc[0] != tc[1] {
+			t.Errorf("Expected sessions to be sent with the %s '%s' but it was '%s'", name, tc[1], tc[0])
+		}
+	}
+}
+
+func TestSessionOnError(t *testing.T) {
+	defer func(c Configuration) {
+		Config = c
+	}(*Config.Clone())
+
+	var got error
+	var gotKind string
+	Config.update(&Configuration{OnError: func(err error, kind string) {
+		got, gotKind = err, kind
+	}})
+	updateSessionConfig()
+	exp := fmt.Errorf("sessions rejected")
+	sessionTrackingConfig.OnError(exp)
+	if got != exp || gotKind != ErrorKindSession {
+		t.Errorf("Expected OnError to be called with '%v' for '%s' but was called with '%v' for '%s'", exp, ErrorKindSession, got, gotKind)
+	}
+}
+
+func TestUseProfile(t *testing.T) {
+	defer func(c Configuration) {
+		Config = c
+		profileBase = nil
+		updateSessionConfig()
+	}(*Config.Clone())
+
+	Config.update(&Configuration{
+		APIKey:       testAPIKey,
+		ReleaseStage: "development",
+		AppVersion:   "1.2.3",
+		Profiles: map[string]Configuration{
+			"staging": {ReleaseStage: "staging", Endpoints: Endpoints{Notify: "https://notify.staging.example.com", Sessions: "https://sessions.staging.example.com"}},
+			"production": {
+				APIKey:       "0123456789abcdef0123456789abcdef",
+				ReleaseStage: "production",
+			},
+		},
+	})
+	notifyEndpoint := Config.Endpoints.Notify
+
+	if err := UseProfile("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if Config.ReleaseStage != "staging" || Config.Endpoints.Notify != "https://notify.staging.example.com" {
+		t.Errorf("Expected the staging profile to be used but the release stage was '%s' and the endpoint '%s'", Config.ReleaseStage, Config.Endpoints.Notify)
+	}
+	if Config.APIKey != testAPIKey || Config.AppVersion != "1.2.3" {
+		t.Errorf("Expected unset fields to be inherited but the API key was '%s' and the app version '%s'", Config.APIKey, Config.AppVersion)
+	}
+	if sessionTrackingConfig.Endpoint != "https://sessions.staging.example.com" {
+		t.Errorf("Expected sessions to be sent to the staging endpoint but were sent to '%s'", sessionTrackingConfig.Endpoint)
+	}
+
+	if err := UseProfile("production"); err != nil {
+		t.Fatal(err)
+	}
+	if Config.APIKey != "0123456789abcdef0123456789abcdef" || Config.ReleaseStage != "production" {
+		t.Errorf("Expected the production profile to be used but the API key was '%s' and the release stage '%s'", Config.APIKey, Config.ReleaseStage)
+	}
+	if Config.Endpoints.Notify != notifyEndpoint {
+		t.Errorf("Expected the endpoint to be inherited rather than left over from staging but was '%s'", Config.Endpoints.Notify)
+	}
+
+	if err := UseProfile("qa"); err == nil || Config.ReleaseStage != "production" {
+		t.Errorf("Expected an unknown profile to return an error and leave the configuration unchanged but got '%v'", err)
+	}
+
+	Configure(Configuration{AppVersion: "1.2.4"})
+	if err := UseProfile("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if Config.AppVersion != "1.2.4" {
+		t.Errorf("Expected changes made with Configure to be kept when switching profile but the app version was '%s'", Config.AppVersion)
+	}
+}
+
+func TestConfigurationMerge(t *testing.T) {
+	base := &Configuration{
+		APIKey:              testAPIKey,
+		ReleaseStage:        "production",
+		ParamsFilters:       []string{"password"},
+		NotifyReleaseStages: []string{"production"},
+		Tags:                Tags{"region": "eu"},
+		DefaultMetaData:     MetaData{"service": {"name": "billing"}},
+		Synchronous:         true,
+		MaxMessageBytes:     100,
+	}
+	config := base.Clone()
+	config.Merge(&Configuration{
+		ReleaseStage:        "staging",
+		ParamsFilters:       []string{},
+		Tags:                Tags{"region": "us"},
+		AutoCaptureSessions: false,
+	})
+
+	if config.APIKey != testAPIKey || config.MaxMessageBytes != 100 || !config.Synchronous {
+		t.Errorf("Expected unset fields not to override the configuration but it was %+v", config)
+	}
+	if config.ReleaseStage != "staging" {
+		t.Errorf("Expected the release stage to be overridden but it was '%s'", config.ReleaseStage)
+	}
+	if config.ParamsFilters == nil || len(config.ParamsFilters) != 0 {
+		t.Errorf("Expected an empty slice to clear the params filters but they were %v", config.ParamsFilters)
+	}
+	if !reflect.DeepEqual(config.NotifyReleaseStages, []string{"production"}) {
+		t.Errorf("Expected a nil slice not to override the notify release stages but they were %v", config.NotifyReleaseStages)
+	}
+	if config.Tags["region"] != "us" {
+		t.Errorf("Expected the tags to be replaced but they were %v", config.Tags)
+	}
+	if !reflect.DeepEqual(config.DefaultMetaData, MetaData{"service": {"name": "billing"}}) {
+		t.Errorf("Expected a nil map not to override the default meta-data but it was %v", config.DefaultMetaData)
+	}
+	if config.IsAutoCaptureSessions() {
+		t.Errorf("Expected merging false to disable automatic session capture")
+	}
+
+	if base.ReleaseStage != "production" || len(base.ParamsFilters) != 1 || base.Tags["region"] != "eu" || base.AutoCaptureSessions != nil {
+		t.Errorf("Expected merging into a clone not to change the original but it was %+v", base)
+	}
+}
+
+func TestMergeKeepsFlushSessionsOnRepanic(t *testing.T) {
+	notifier := New(Configuration{})
+	if !notifier.Config.isFlushSessionsOnRepanic() {
+		t.Errorf("Expected sessions to be flushed on repanic by default")
+	}
+	notifier.FlushSessionsOnRepanic(false)
+	notifier.Config.Merge(&Configuration{ReleaseStage: "staging"})
+	if notifier.Config.isFlushSessionsOnRepanic() {
+		t.Errorf("Expected merging a configuration not to re-enable flushing sessions on repanic")
+	}
+	merged := &Configuration{}
+	merged.Merge(notifier.Config)
+	if merged.isFlushSessionsOnRepanic() {
+		t.Errorf("Expected disabling flushing sessions on repanic to be merged")
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	defer func(c Configuration) {
+		Config = c
+		updateSessionConfig()
+	}(*Config.Clone())
+	resetCircuitBreakers(t)
+	defer func(tracker sessions.SessionTracker) { sessionTracker = tracker }(sessionTracker)
+	sessionTracker = sessions.NewSessionTracker(&sessionTrackingConfig)
+
+	var mutex sync.Mutex
+	eventKeys := map[string]int{}
+	var sessionKeys []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Bugsnag-Api-Key")
+		mutex.Lock()
+		defer mutex.Unlock()
+		if strings.Contains(r.URL.Path, "sessions") {
+			sessionKeys = append(sessionKeys, key)
+			w.WriteHeader(http.StatusAccepted)
+		} else {
+			eventKeys[key]++
+		}
+	}))
+	defer ts.Close()
+
+	keys := []string{testAPIKey, strings.Repeat("a", 32), strings.Repeat("b", 32)}
+	Reconfigure(func(config *Configuration) {
+		*config = generateSampleConfig(ts.URL)
+		config.Endpoints.Sessions = ts.URL + "/sessions"
+		config.NotifyReleaseStages = []string{"test"}
+		config.Synchronous = true
+	})
+
+	const workers, notifies = 4, 25
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < notifies; j++ {
+				Notify(fmt.Errorf("rotating"))
+			}
+		}()
+	}
+	for i := 0; i < 30; i++ {
+		key := keys[i%len(keys)]
+		Reconfigure(func(config *Configuration) { config.APIKey = key })
+	}
+	wg.Wait()
+
+	final := strings.Repeat("c", 32)
+	Reconfigure(func(config *Configuration) { config.APIKey = final })
+	StartSession(context.Background())
+	FlushSessions()
+	Notify(fmt.Errorf("rotated"))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	valid := map[string]bool{final: true}
+	for _, key := range keys {
+		valid[key] = true
+	}
+	total := 0
+	for key, count := range eventKeys {
+		if !valid[key] {
+			t.Errorf("Expected events to be sent with one of the configured keys but got '%s'", key)
+		}
+		total += count
+	}
+	if total != workers*notifies+1 {
+		t.Errorf("Expected %d events to be delivered but %d were", workers*notifies+1, total)
+	}
+	if eventKeys[final] != 1 {
+		t.Errorf("Expected the event after the last rotation to use the key '%s' but the keys were %v", final, eventKeys)
+	}
+	if len(sessionKeys) == 0 || sessionKeys[len(sessionKeys)-1] != final {
+		t.Errorf("Expected sessions to be sent with the key '%s' but the keys were %v", final, sessionKeys)
+	}
+}
+
+func TestSampleRates(t *testing.T) {
+	resetCircuitBreakers(t)
+	defer func(random func() float64) { sampleRandom = random }(sampleRandom)
+	sampleRandom = func() float64 { return 0.5 }
+
+	delivered := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer ts.Close()
+
+	observer := &recordingMetricsObserver{}
+	config := generateSampleConfig(ts.URL)
+	config.NotifyReleaseStages = []string{"test"}
+	config.MetricsObserver = observer
+	config.HandledSampleRate = 0.1
+	notifier := New(config)
+	notifier.Config.Synchronous = true
+
+	testCases := []struct {
+		name      string
+		rawData   []interface{}
+		delivered bool
+	}{
+		{"handled", nil, false},
+		{"unhandled", []interface{}{Unhandled(true)}, true},
+		{"made unhandled by middleware", []interface{}{func(event *Event) { event.Unhandled = true }}, true},
+	}
+	for _, tc := range testCases {
+		if err := notifier.Notify(fmt.Errorf("oops"), tc.rawData...); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(delivered) == 1; got != tc.delivered {
+			t.Errorf("Expected the %s event to be delivered: %v, but it was: %v", tc.name, tc.delivered, got)
+		}
+		if len(delivered) > 0 {
+			<-delivered
+		}
+	}
+	observer.mutex.Lock()
+	if exp := "dropped " + string(DropReasonSampled); !strings.Contains(strings.Join(observer.calls, ","), exp) {
+		t.Errorf("Expected '%s' to be observed but got '%v'", exp, observer.calls)
+	}
+	observer.mutex.Unlock()
+
+	notifier.Config.UnhandledSampleRate = 0.1
+	if err := notifier.Notify(fmt.Errorf("oops"), Unhandled(true)); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 0 {
+		t.Errorf("Expected the unhandled event to be sampled by the UnhandledSampleRate")
+	}
+
+	sampleRandom = func() float64 { return 0 }
+	merged := &Configuration{HandledSampleRate: 0.5}
+	merged.Merge(&Configuration{HandledSampleRate: 0})
+	notifier.Config.HandledSampleRate = merged.HandledSampleRate
+	if err := notifier.Notify(fmt.Errorf("oops")); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 0 {
+		t.Errorf("Expected a HandledSampleRate of 0 to drop every handled event")
+	}
+}
+
+func TestNotifyNilBehavior(t *testing.T) {
+	testCases := []struct {
+		name     string
+		behavior NotifyNilPolicy
+		err      bool
+		logged   bool
+	}{
+		{"ignore", NotifyNilIgnore, false, false},
+		{"log", NotifyNilLog, false, true},
+		{"error", NotifyNilError, true, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			var logged strings.Builder
+			notifier := New(Configuration{APIKey: testAPIKey, Logger: log.New(&logged, "", 0)})
+			// Set directly, as the default can't override other tests' changes
+			// to the global configuration
+			notifier.Config.NotifyNilBehavior = tc.behavior
+			logged.Reset()
+
+			err := notifier.Notify(nil)
+			if got := err != nil; got != tc.err {
+				st.Errorf("Expected an error to be returned: %v, but got '%v'", tc.err, err)
+			}
+			if got := strings.Contains(logged.String(), "without supplying an error"); got != tc.logged {
+				st.Errorf("Expected the nil error to be logged: %v, but logged '%s'", tc.logged, logged.String())
+			}
+			if _, err := notifier.NotifyWithID(nil); (err != nil) != tc.err {
+				st.Errorf("Expected NotifyWithID to return an error: %v, but got '%v'", tc.err, err)
+			}
+		})
+	}
+
+	notifier := &Notifier{Config: &Configuration{APIKey: testAPIKey, Logger: log.New(ioutil.Discard, "", 0)}}
+	if err := notifier.Notify(nil); err != nil {
+		t.Errorf("Expected a nil error to be ignored by default but got '%v'", err)
+	}
+}
+
+func TestMinSeverity(t *testing.T) {
+	config := &Configuration{MinSeverity: SeverityError}
+	testCases := []struct {
+		name      string
+		event     *Event
+		config    *Configuration
+		delivered bool
+	}{
+		{"warning", &Event{Severity: SeverityWarning}, config, false},
+		{"error", &Event{Severity: SeverityError}, config, true},
+		{"unhandled warning", &Event{Severity: SeverityWarning, Unhandled: true}, config, true},
+		{"info without a floor", &Event{Severity: SeverityInfo}, &Configuration{}, true},
+	}
+	for _, tc := range testCases {
+		if got := tc.config.severeEnough(tc.event); got != tc.delivered {
+			t.Errorf("Expected the %s event to be sent: %v, but it was: %v", tc.name, tc.delivered, got)
+		}
+	}
+}
+
+func TestMinSeverityDropsEvents(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	delivered := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer ts.Close()
+
+	// Severities are checked after all callbacks, including final ones
+	handle := AddOnBeforeNotifyFinal(func(event *Event, config *Configuration) error {
+		if event.Context == "raised" {
+			event.Severity = SeverityError
+		}
+		return nil
+	})
+	defer RemoveOnBeforeNotify(handle)
+
+	var dropped []DropReason
+	config := generateSampleConfig(ts.URL)
+	config.NotifyReleaseStages = []string{"test"}
+	config.MinSeverity = SeverityError
+	config.OnEventDropped = func(event *Event, reason DropReason) { dropped = append(dropped, reason) }
+	notifier := New(config)
+	notifier.Config.Synchronous = true
+
+	if err := notifier.Notify(fmt.Errorf("oops"), SeverityWarning); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 0 {
+		t.Errorf("Expected the warning to be dropped")
+	}
+	if exp := []DropReason{DropReasonSeverity}; !reflect.DeepEqual(dropped, exp) {
+		t.Errorf("Expected the warning to be dropped for its severity but got '%v'", dropped)
+	}
+
+	if err := notifier.Notify(fmt.Errorf("oops"), SeverityWarning, Context{String: "raised"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 {
+		t.Errorf("Expected the warning raised to an error by a callback to be delivered")
+	}
+}
@@ -0,0 +1,246 @@
+package bugsnag
+
+import (
+	"context"
+	"sort"
+)
+
+const (
+	metaDataContextKey contextDataKey = iota
+	userContextKey
+	traceContextKey
+	logBufferContextKey
+	severityContextKey
+	suppressionContextKey
+	breadcrumbsContextKey
+	operationContextKey
+)
+
+type contextDataKey int
+
+// operationTab is the tab which operations attached with WithOperation are
+// added to.
+const operationTab = "operation"
+
+type traceContext struct {
+	traceID string
+	spanID  string
+}
+
+type operation struct {
+	id   string
+	step string
+}
+
+// WithMetaData returns a child of the given context with the given data
+// attached under the tab. Any event notified with the returned context, or a
+// context derived from it, will include the data in its MetaData. Calling
+// WithMetaData again on a derived context adds to the data already attached,
+// with values for duplicate keys in the same tab being overwritten.
+// MetaData passed directly to Notify takes precedence over data attached to
+// the context.
+func WithMetaData(ctx context.Context, tab string, data map[string]interface{}) context.Context {
+	meta := make(MetaData)
+	meta.Update(metaDataFromContext(ctx))
+	meta.Update(MetaData{tab: data})
+	return context.WithValue(ctx, metaDataContextKey, meta)
+}
+
+func metaDataFromContext(ctx context.Context) MetaData {
+	if ctx == nil {
+		return nil
+	}
+	if meta, ok := ctx.Value(metaDataContextKey).(MetaData); ok {
+		return meta
+	}
+	return nil
+}
+
+// WithUser returns a child of the given context with the user attached. Any
+// event notified with the returned context, or a context derived from it,
+// will be attributed to the user unless a User is also passed to Notify
+// directly.
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+func userFromContext(ctx context.Context) *User {
+	if ctx == nil {
+		return nil
+	}
+	if user, ok := ctx.Value(userContextKey).(User); ok {
+		return &user
+	}
+	return nil
+}
+
+// WithSeverity returns a child of the given context with a default severity
+// attached, e.g. for a best-effort code path where errors are only warnings.
+// Any event notified with the returned context, or a context derived from it,
+// has the severity unless a severity is also passed to Notify directly.
+func WithSeverity(ctx context.Context, s severity) context.Context {
+	return context.WithValue(ctx, severityContextKey, s)
+}
+
+func severityFromContext(ctx context.Context) *severity {
+	if ctx == nil {
+		return nil
+	}
+	if s, ok := ctx.Value(severityContextKey).(severity); ok {
+		return &s
+	}
+	return nil
+}
+
+// WithTraceContext returns a child of the given context with the IDs of the
+// active trace and span attached. Any event notified with the returned
+// context will be correlated with the trace in Bugsnag. This is intended for
+// tracing setups which don't store the trace in the context themselves; for
+// those that do, see Configuration.TraceContextExtractor.
+func WithTraceContext(ctx context.Context, traceID, spanID string) context.Context {
+	return context.WithValue(ctx, traceContextKey, traceContext{traceID, spanID})
+}
+
+func traceContextFromContext(ctx context.Context, config *Configuration) (string, string) {
+	if ctx == nil {
+		return "", ""
+	}
+	if trace, ok := ctx.Value(traceContextKey).(traceContext); ok {
+		return trace.traceID, trace.spanID
+	}
+	if config != nil && config.TraceContextExtractor != nil {
+		return config.TraceContextExtractor(ctx)
+	}
+	return "", ""
+}
+
+// contextMetaDataMiddleware is added OnBeforeNotify by default. It adds any
+// MetaData attached to a context.Context passed in as rawData with
+// WithMetaData to the Event, without overwriting values which have already
+// been set.
+func contextMetaDataMiddleware(event *Event, config *Configuration) error {
+	for _, datum := range event.RawData {
+		if ctx, ok := datum.(context.Context); ok && ctx != nil {
+			for tab, values := range metaDataFromContext(ctx) {
+				for key, value := range values {
+					if _, exists := event.MetaData[tab][key]; !exists {
+						event.MetaData.Add(tab, key, value)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// traceContextMiddleware is added OnBeforeNotify by default. It sets the
+// TraceID and SpanID of the Event from a context.Context passed in as rawData,
+// unless they have already been set.
+func traceContextMiddleware(event *Event, config *Configuration) error {
+	if event.TraceID != "" {
+		return nil
+	}
+	for _, datum := range event.RawData {
+		if ctx, ok := datum.(context.Context); ok && ctx != nil {
+			if traceID, spanID := traceContextFromContext(ctx, config); traceID != "" {
+				event.TraceID, event.SpanID = traceID, spanID
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// DefaultMaxBaggageBytes is the size of the baggage added to an event when
+// Configuration.MaxBaggageBytes is not set.
+const DefaultMaxBaggageBytes = 4096
+
+// baggageTab is the meta-data tab which baggage members are added to.
+const baggageTab = "baggage"
+
+// baggageMiddleware is added OnBeforeNotify by default. It adds the baggage
+// members returned by the BaggageExtractor for a context.Context passed in as
+// rawData to the "baggage" tab of the Event, up to MaxBaggageBytes.
+func baggageMiddleware(event *Event, config *Configuration) error {
+	if config.BaggageExtractor == nil {
+		return nil
+	}
+	ctx, ok := event.GetContext()
+	if !ok {
+		return nil
+	}
+	items := config.BaggageExtractor(ctx)
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	size := 0
+	for _, key := range keys {
+		size += len(key) + len(items[key])
+		if size > config.maxBaggageBytes() {
+			break
+		}
+		event.MetaData.Add(baggageTab, key, items[key])
+	}
+	return nil
+}
+
+// WithSuppression returns a child of the given context which suppresses
+// notifications, e.g. for a health check endpoint or a noisy background
+// scanner. Any event notified with the returned context, or a context derived
+// from it, is dropped without being logged. Unhandled panics are dropped too,
+// unless Configuration.NotifySuppressedPanics is set.
+func WithSuppression(ctx context.Context) context.Context {
+	return context.WithValue(ctx, suppressionContextKey, true)
+}
+
+func isSuppressed(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	suppressed, _ := ctx.Value(suppressionContextKey).(bool)
+	return suppressed
+}
+
+// WithOperation returns a child of the given context which is part of a
+// logical operation, such as a multi-step workflow, with the given ID and the
+// name of the step being performed. Any event notified with the returned
+// context, or a context derived from it, includes the operation in its
+// "operation" tab, so that the errors from one failed operation can be told
+// apart. Calling WithOperation again for the next step replaces the step.
+func WithOperation(ctx context.Context, opID, step string) context.Context {
+	return context.WithValue(ctx, operationContextKey, operation{opID, step})
+}
+
+func operationFromContext(ctx context.Context) (operation, bool) {
+	if ctx == nil {
+		return operation{}, false
+	}
+	op, ok := ctx.Value(operationContextKey).(operation)
+	return op, ok
+}
+
+// operationMiddleware is added OnBeforeNotify by default. It adds the
+// operation attached to a context.Context passed in as rawData with
+// WithOperation to the "operation" tab of the Event. When GroupByOperation is
+// set it also groups the Event by the operation ID, unless a GroupingHash has
+// already been set.
+func operationMiddleware(event *Event, config *Configuration) error {
+	for _, datum := range event.RawData {
+		if ctx, ok := datum.(context.Context); ok {
+			if op, ok := operationFromContext(ctx); ok {
+				event.MetaData.Add(operationTab, "id", op.id)
+				if op.step != "" {
+					event.MetaData.Add(operationTab, "step", op.step)
+				}
+				if config.GroupByOperation && event.GroupingHash == "" {
+					event.GroupingHash = operationTab + ":" + op.id
+				}
+				return nil
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package bugsnag
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestWithMetaDataAccumulates(t *testing.T) {
+	ctx := WithMetaData(context.Background(), "request", map[string]interface{}{"id": "abc", "attempt": 1})
+	ctx = WithMetaData(ctx, "tenant", map[string]interface{}{"name": "acme"})
+	child := WithMetaData(ctx, "request", map[string]interface{}{"attempt": 2})
+
+	exp := MetaData{
+		"request": {"id": "abc", "attempt": 2},
+		"tenant":  {"name": "acme"},
+	}
+	if got := metaDataFromContext(child); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected context metadata to be '%+v' but was '%+v'", exp, got)
+	}
+
+	parentExp := MetaData{
+		"request": {"id": "abc", "attempt": 1},
+		"tenant":  {"name": "acme"},
+	}
+	if got := metaDataFromContext(ctx); !reflect.DeepEqual(got, parentExp) {
+		t.Errorf("Expected parent context metadata to be unchanged '%+v' but was '%+v'", parentExp, got)
+	}
+}
+
+func TestContextMetaDataMiddleware(t *testing.T) {
+	ctx := WithMetaData(context.Background(), "request", map[string]interface{}{"id": "abc", "route": "/a"})
+	md := MetaData{"request": {"route": "/b"}}
+	event, config := newEvent([]interface{}{fmt.Errorf("oops"), ctx, md}, &defaultNotifier)
+
+	if err := contextMetaDataMiddleware(event, config); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := MetaData{"request": {"id": "abc", "route": "/b"}}
+	if !reflect.DeepEqual(event.MetaData, exp) {
+		t.Errorf("Expected event metadata to be '%+v' but was '%+v'", exp, event.MetaData)
+	}
+}
+
+func TestWithUserPrecedence(t *testing.T) {
+	ctx := WithUser(context.Background(), User{Id: "ctx-user"})
+	explicit := User{Id: "explicit-user"}
+
+	for _, tc := range []struct {
+		name    string
+		rawData []interface{}
+		exp     *User
+	}{
+		{name: "no user", rawData: []interface{}{context.Background()}, exp: nil},
+		{name: "context user", rawData: []interface{}{ctx}, exp: &User{Id: "ctx-user"}},
+		{name: "explicit user before context", rawData: []interface{}{explicit, ctx}, exp: &explicit},
+		{name: "explicit user after context", rawData: []interface{}{ctx, explicit}, exp: &explicit},
+	} {
+		t.Run(tc.name, func(st *testing.T) {
+			event, _ := newEvent(append([]interface{}{fmt.Errorf("oops")}, tc.rawData...), &defaultNotifier)
+			if !reflect.DeepEqual(event.User, tc.exp) {
+				st.Errorf("Expected user to be '%+v' but was '%+v'", tc.exp, event.User)
+			}
+		})
+	}
+}
+
+func TestWithUserConcurrentContexts(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			ctx := WithUser(context.Background(), User{Id: id})
+			event, _ := newEvent([]interface{}{fmt.Errorf("oops"), ctx}, &defaultNotifier)
+			if event.User == nil || event.User.Id != id {
+				t.Errorf("Expected user '%s' but was '%+v'", id, event.User)
+			}
+		}(fmt.Sprintf("user-%d", i))
+	}
+	wg.Wait()
+}
+
+func TestTraceContextMiddleware(t *testing.T) {
+	extractor := func(ctx context.Context) (string, string) { return "extracted-trace", "extracted-span" }
+	for _, tc := range []struct {
+		name              string
+		ctx               context.Context
+		extractor         func(context.Context) (string, string)
+		expTrace, expSpan string
+	}{
+		{name: "no trace", ctx: context.Background()},
+		{name: "explicit trace", ctx: WithTraceContext(context.Background(), "trace", "span"), expTrace: "trace", expSpan: "span"},
+		{name: "extracted trace", ctx: context.Background(), extractor: extractor, expTrace: "extracted-trace", expSpan: "extracted-span"},
+		{name: "explicit trace takes precedence", ctx: WithTraceContext(context.Background(), "trace", "span"), extractor: extractor, expTrace: "trace", expSpan: "span"},
+	} {
+		t.Run(tc.name, func(st *testing.T) {
+			event, config := newEvent([]interface{}{fmt.Errorf("oops"), tc.ctx}, &defaultNotifier)
+			config = config.merge(&Configuration{TraceContextExtractor: tc.extractor})
+			if err := traceContextMiddleware(event, config); err != nil {
+				st.Fatal(err)
+			}
+			if event.TraceID != tc.expTrace || event.SpanID != tc.expSpan {
+				st.Errorf("Expected trace '%s' and span '%s' but got '%s' and '%s'", tc.expTrace, tc.expSpan, event.TraceID, event.SpanID)
+			}
+		})
+	}
+}
+
+func TestWithSeverityPrecedence(t *testing.T) {
+	ctx := WithSeverity(context.Background(), SeverityInfo)
+	panicked := HandledState{SeverityReason: SeverityReasonHandledPanic, OriginalSeverity: SeverityError}
+
+	for _, tc := range []struct {
+		name    string
+		rawData []interface{}
+		exp     severity
+		reason  SeverityReason
+	}{
+		{name: "no severity", rawData: []interface{}{context.Background()}, exp: SeverityWarning, reason: SeverityReasonHandledError},
+		{name: "context severity", rawData: []interface{}{ctx}, exp: SeverityInfo, reason: SeverityReasonUserSpecified},
+		{name: "derived context", rawData: []interface{}{WithUser(ctx, User{Id: "1"})}, exp: SeverityInfo, reason: SeverityReasonUserSpecified},
+		{name: "explicit severity before context", rawData: []interface{}{SeverityError, ctx}, exp: SeverityError, reason: SeverityReasonUserSpecified},
+		{name: "explicit severity after context", rawData: []interface{}{ctx, SeverityError}, exp: SeverityError, reason: SeverityReasonUserSpecified},
+		{name: "panic", rawData: []interface{}{ctx, panicked}, exp: SeverityInfo, reason: SeverityReasonHandledPanic},
+	} {
+		t.Run(tc.name, func(st *testing.T) {
+			event, _ := newEvent(append([]interface{}{fmt.Errorf("oops")}, tc.rawData...), &defaultNotifier)
+			if event.Severity != tc.exp {
+				st.Errorf("Expected severity to be '%s' but was '%s'", tc.exp.String, event.Severity.String)
+			}
+			if event.handledState.SeverityReason != tc.reason {
+				st.Errorf("Expected severity reason to be '%s' but was '%s'", tc.reason, event.handledState.SeverityReason)
+			}
+		})
+	}
+}
+
+func TestOperationMiddleware(t *testing.T) {
+	ctx := WithOperation(context.Background(), "order-42", "reserve")
+	reserveErr, config := newEvent([]interface{}{fmt.Errorf("out of stock"), ctx}, &defaultNotifier)
+	chargeErr, _ := newEvent([]interface{}{fmt.Errorf("card declined"), WithOperation(ctx, "order-42", "charge")}, &defaultNotifier)
+	other, _ := newEvent([]interface{}{fmt.Errorf("oops")}, &defaultNotifier)
+	for _, event := range []*Event{reserveErr, chargeErr, other} {
+		if err := operationMiddleware(event, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for step, event := range map[string]*Event{"reserve": reserveErr, "charge": chargeErr} {
+		exp := map[string]interface{}{"id": "order-42", "step": step}
+		if got := event.MetaData[operationTab]; !reflect.DeepEqual(got, exp) {
+			t.Errorf("Expected the operation tab to be '%+v' but was '%+v'", exp, got)
+		}
+		if event.GroupingHash != "" {
+			t.Errorf("Expected no grouping hash by default but was '%s'", event.GroupingHash)
+		}
+	}
+	if _, ok := other.MetaData[operationTab]; ok {
+		t.Errorf("Expected no operation tab without an operation but was '%+v'", other.MetaData)
+	}
+
+	config.GroupByOperation = true
+	for _, event := range []*Event{reserveErr, chargeErr} {
+		if err := operationMiddleware(event, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reserveErr.GroupingHash == "" || reserveErr.GroupingHash != chargeErr.GroupingHash {
+		t.Errorf("Expected the errors to be grouped by operation but the grouping hashes were '%s' and '%s'", reserveErr.GroupingHash, chargeErr.GroupingHash)
+	}
+}
+
+type baggageKey struct{}
+
+func TestBaggageMiddleware(t *testing.T) {
+	ctx := context.WithValue(context.Background(), baggageKey{}, map[string]string{
+		"tenant":     "acme",
+		"experiment": "new-checkout",
+	})
+	extractor := func(ctx context.Context) map[string]string {
+		items, _ := ctx.Value(baggageKey{}).(map[string]string)
+		return items
+	}
+	testCases := []struct {
+		name     string
+		maxBytes int
+		exp      map[string]interface{}
+	}{
+		{"all", 0, map[string]interface{}{"tenant": "acme", "experiment": "new-checkout"}},
+		{"capped", 30, map[string]interface{}{"experiment": "new-checkout"}},
+		{"none", 10, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			config := &Configuration{BaggageExtractor: extractor, MaxBaggageBytes: tc.maxBytes}
+			event := &Event{RawData: []interface{}{ctx}, MetaData: make(MetaData)}
+			if err := baggageMiddleware(event, config); err != nil {
+				st.Fatal(err)
+			}
+			got := event.MetaData[baggageTab]
+			if tc.exp == nil && got != nil {
+				st.Errorf("Expected no baggage to be added but it was %v", got)
+			}
+			if tc.exp != nil && !reflect.DeepEqual(got, tc.exp) {
+				st.Errorf("Expected the baggage tab to be %v but it was %v", tc.exp, got)
+			}
+		})
+	}
+
+	for _, event := range []*Event{
+		{MetaData: make(MetaData)},
+		{RawData: []interface{}{ctx}, MetaData: make(MetaData)},
+	} {
+		config := &Configuration{}
+		if event.RawData == nil {
+			config.BaggageExtractor = extractor
+		}
+		if err := baggageMiddleware(event, config); err != nil {
+			t.Fatal(err)
+		}
+		if len(event.MetaData) != 0 {
+			t.Errorf("Expected no baggage without a context or extractor but the meta-data was %v", event.MetaData)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package bugsnag
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// duplicatesTab is the metadata tab which the number of duplicates of an
+// event is sent in.
+const duplicatesTab = "duplicates"
+
+var deduplicator = new(eventDeduplicator)
+
+// eventDeduplicator collapses identical events sent within the
+// Configuration.DedupWindow, so that an error thrown in a tight loop is only
+// sent once, followed by a count of how many times it recurred.
+type eventDeduplicator struct {
+	mutex   sync.Mutex
+	windows map[string]*dedupWindow
+}
+
+// dedupWindow tracks an event which has been sent, and how many duplicates of
+// it have been seen since.
+type dedupWindow struct {
+	first      *payload
+	timer      *time.Timer
+	duplicates int
+}
+
+// duplicate reports whether the payload is a duplicate of one seen within the
+// dedup window, in which case it is counted instead of being sent. Otherwise
+// it starts a new window for the payload.
+func (d *eventDeduplicator) duplicate(p *payload) bool {
+	key := p.dedupKey()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if w, ok := d.windows[key]; ok {
+		w.duplicates++
+		return true
+	}
+	if d.windows == nil {
+		d.windows = make(map[string]*dedupWindow)
+	}
+	w := &dedupWindow{first: p}
+	w.timer = time.AfterFunc(p.DedupWindow, func() { d.close(key, w) })
+	d.windows[key] = w
+	return false
+}
+
+// close ends the window, sending the number of duplicates seen if there were
+// any.
+func (d *eventDeduplicator) close(key string, w *dedupWindow) {
+	d.mutex.Lock()
+	if d.windows[key] != w {
+		d.mutex.Unlock()
+		return
+	}
+	delete(d.windows, key)
+	d.mutex.Unlock()
+	w.send()
+}
+
+// flush ends all open windows, sending the number of duplicates seen in each.
+func (d *eventDeduplicator) flush() {
+	d.mutex.Lock()
+	windows := d.windows
+	d.windows = nil
+	d.mutex.Unlock()
+	for _, w := range windows {
+		w.timer.Stop()
+		w.send()
+	}
+}
+
+// send delivers the number of duplicates seen, if there were any. Only the
+// details which Bugsnag groups the event by are sent along with the count, so
+// that the duplicates are recorded against the same error without the first
+// event being sent again. The count isn't associated with the session of the
+// first event, which has already counted it.
+func (w *dedupWindow) send() {
+	if w.duplicates == 0 {
+		return
+	}
+	first := w.first.Event
+	event := &Event{
+		ErrorClass:         first.ErrorClass,
+		Message:            first.Message,
+		Stacktrace:         first.Stacktrace,
+		Context:            first.Context,
+		Severity:           first.Severity,
+		GroupingHash:       first.GroupingHash,
+		MetaData:           MetaData{duplicatesTab: {"count": w.duplicates}},
+		Time:               first.Time,
+		handledState:       first.handledState,
+		Unhandled:          first.Unhandled,
+		groupingComponents: first.groupingComponents,
+		responseStatus:     first.responseStatus,
+	}
+	batch := []*payload{{event, w.first.Configuration}}
+	logBatchError(batch, deliverBatch(batch))
+}
+
+// dedupKey identifies events which are duplicates of each other. By default
+// events are duplicates if they have the same error class, message and top
+// stack frame. Events are only duplicates if they're sent to the same project
+// and endpoint, as the deduplicator is shared by all notifiers.
+func (p *payload) dedupKey() string {
+	key := p.APIKey + "\x00" + p.Endpoints.Notify + "\x00"
+	if p.DedupKeyFunc != nil {
+		return key + p.DedupKeyFunc(p.Event)
+	}
+	key += p.ErrorClass + "\x00" + p.Message
+	if len(p.Stacktrace) > 0 {
+		frame := p.Stacktrace[0]
+		key += fmt.Sprintf("\x00%s:%d:%s", frame.File, frame.LineNumber, frame.Method)
+	}
+	return key
+}
@@ -0,0 +1,164 @@
+package bugsnag
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	simplejson "github.com/bitly/go-simplejson"
+)
+
+func dedupNotifier(url string, window time.Duration) *Notifier {
+	config := generateSampleConfig(url)
+	config.NotifyReleaseStages = []string{"test"}
+	config.DedupWindow = window
+	config.Synchronous = true
+	return New(config)
+}
+
+func receiveReport(t *testing.T, reports chan []byte) *simplejson.Json {
+	select {
+	case r := <-reports:
+		report, err := simplejson.NewJson(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	case <-time.After(time.Second):
+		t.Fatal("Expected a report to be sent")
+		return nil
+	}
+}
+
+func assertNoReport(t *testing.T, reports chan []byte, msg string) {
+	select {
+	case <-reports:
+		t.Fatal(msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDedupWithinWindow(t *testing.T) {
+	defer func(d *eventDeduplicator) { deduplicator = d }(deduplicator)
+	deduplicator = new(eventDeduplicator)
+	resetCircuitBreakers(t)
+	ts, reports := setup()
+	defer ts.Close()
+	notifier := dedupNotifier(ts.URL, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		notifier.Notify(fmt.Errorf("connection refused"))
+	}
+	report := receiveReport(t, reports)
+	if count := getIndex(report, "events", 0).GetPath("metaData", duplicatesTab).Interface(); count != nil {
+		t.Errorf("Expected the first event to be sent without a count but got %v", count)
+	}
+	assertNoReport(t, reports, "Expected duplicates within the window not to be sent")
+
+	Flush()
+	report = receiveReport(t, reports)
+	event := getIndex(report, "events", 0)
+	if got := getString(getIndex(event, "exceptions", 0), "message"); got != "connection refused" {
+		t.Errorf("Expected the first event to be sent with the count but was '%s'", got)
+	}
+	if got := getInt(event, "metaData.duplicates.count"); got != 2 {
+		t.Errorf("Expected 2 duplicates to be counted but got %d", got)
+	}
+}
+
+func TestDedupAcrossWindows(t *testing.T) {
+	defer func(d *eventDeduplicator) { deduplicator = d }(deduplicator)
+	deduplicator = new(eventDeduplicator)
+	resetCircuitBreakers(t)
+	ts, reports := setup()
+	defer ts.Close()
+	notifier := dedupNotifier(ts.URL, 20*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		notifier.Notify(fmt.Errorf("connection refused"))
+		receiveReport(t, reports)
+		time.Sleep(50 * time.Millisecond)
+	}
+	assertNoReport(t, reports, "Expected no count to be sent for windows without duplicates")
+
+	for i := 0; i < 2; i++ {
+		notifier.Notify(fmt.Errorf("connection refused"))
+	}
+	receiveReport(t, reports)
+	report := receiveReport(t, reports)
+	if got := getInt(getIndex(report, "events", 0), "metaData.duplicates.count"); got != 1 {
+		t.Errorf("Expected 1 duplicate to be counted when the window closed but got %d", got)
+	}
+}
+
+func TestDedupKeyFunc(t *testing.T) {
+	defer func(d *eventDeduplicator) { deduplicator = d }(deduplicator)
+	deduplicator = new(eventDeduplicator)
+	resetCircuitBreakers(t)
+	ts, reports := setup()
+	defer ts.Close()
+	notifier := dedupNotifier(ts.URL, time.Hour)
+	notifier.Config.DedupKeyFunc = func(event *Event) string {
+		return event.Context
+	}
+
+	notifier.Notify(fmt.Errorf("timeout after 1s"), Context{String: "sync"})
+	notifier.Notify(fmt.Errorf("timeout after 2s"), Context{String: "sync"})
+	notifier.Notify(fmt.Errorf("timeout after 1s"), Context{String: "import"})
+	receiveReport(t, reports)
+	receiveReport(t, reports)
+	assertNoReport(t, reports, "Expected events with the same key to be de-duplicated")
+	Flush()
+}
+
+func TestDedupSendsOnlyTheCount(t *testing.T) {
+	defer func(d *eventDeduplicator) { deduplicator = d }(deduplicator)
+	deduplicator = new(eventDeduplicator)
+	resetCircuitBreakers(t)
+	ts, reports := setup()
+	defer ts.Close()
+	notifier := dedupNotifier(ts.URL, time.Hour)
+	sessionTracker = nil
+	startSessionTracking()
+	ctx := StartSession(context.Background())
+
+	for i := 0; i < 2; i++ {
+		notifier.Notify(fmt.Errorf("connection refused"), ctx, MetaData{"job": {"id": i}})
+	}
+	first := getIndex(receiveReport(t, reports), "events", 0)
+	if got := getInt(first, "session.events.handled"); got != 1 {
+		t.Errorf("Expected the first event to be counted against its session but the count was %d", got)
+	}
+
+	Flush()
+	event := getIndex(receiveReport(t, reports), "events", 0)
+	if got := getInt(event, "metaData.duplicates.count"); got != 1 {
+		t.Errorf("Expected 1 duplicate to be counted but got %d", got)
+	}
+	if got := getString(getIndex(event, "exceptions", 0), "errorClass"); got != getString(getIndex(first, "exceptions", 0), "errorClass") {
+		t.Errorf("Expected the count to be sent with the error class of the first event but was '%s'", got)
+	}
+	if job := event.GetPath("metaData", "job").Interface(); job != nil {
+		t.Errorf("Expected the first event's meta-data not to be sent again but got %v", job)
+	}
+	if session := event.Get("session").Interface(); session != nil {
+		t.Errorf("Expected the count not to be counted against the session but got %v", session)
+	}
+}
+
+func TestDedupIsPerProject(t *testing.T) {
+	defer func(d *eventDeduplicator) { deduplicator = d }(deduplicator)
+	deduplicator = new(eventDeduplicator)
+	resetCircuitBreakers(t)
+	ts, reports := setup()
+	defer ts.Close()
+	team := dedupNotifier(ts.URL, time.Hour)
+	central := dedupNotifier(ts.URL, time.Hour)
+	central.Config.APIKey = "0123456789abcdef0123456789abcdef"
+
+	NewMultiNotifier(team, central).Notify(fmt.Errorf("connection refused"))
+	receiveReport(t, reports)
+	receiveReport(t, reports)
+	Flush()
+}
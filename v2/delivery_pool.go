@@ -0,0 +1,130 @@
+package bugsnag
+
+import (
+	"sync"
+	"time"
+)
+
+// DeliveryOverflowPolicy decides what happens to an asynchronous event when
+// Configuration.MaxDeliveryConcurrency deliveries are already in progress.
+type DeliveryOverflowPolicy int
+
+const (
+	// DeliveryOverflowDrop drops the event, logging that it was dropped. This
+	// is the default.
+	DeliveryOverflowDrop DeliveryOverflowPolicy = iota
+	// DeliveryOverflowBlock blocks the call to Notify until a delivery
+	// finishes, for up to DeliveryBlockTimeout, and then drops the event.
+	DeliveryOverflowBlock
+	// DeliveryOverflowQueue queues the event to be delivered once a delivery
+	// finishes. Once Configuration.MaxDeliveryQueue events are queued, further
+	// events are dropped.
+	DeliveryOverflowQueue
+)
+
+// DeliveryBlockTimeout is how long Notify blocks waiting for a delivery to
+// finish when using DeliveryOverflowBlock.
+var DeliveryBlockTimeout = time.Second
+
+// DefaultMaxDeliveryQueue is the number of events which can be queued when
+// using DeliveryOverflowQueue and Configuration.MaxDeliveryQueue is not set.
+const DefaultMaxDeliveryQueue = 100
+
+var deliveries = newDeliveryPool()
+
+// deliveryPool runs asynchronous deliveries on a bounded number of
+// goroutines, so that an error storm can't start an unbounded number of them.
+type deliveryPool struct {
+	mutex  sync.Mutex
+	idle   *sync.Cond
+	active int
+	queue  []func()
+}
+
+func newDeliveryPool() *deliveryPool {
+	d := &deliveryPool{}
+	d.idle = sync.NewCond(&d.mutex)
+	return d
+}
+
+// run starts deliver in a new goroutine, unless the configured number of
+// deliveries are already in progress, in which case the overflow policy is
+// applied. It returns false if the delivery was dropped.
+func (d *deliveryPool) run(config *Configuration, deliver func()) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if config.MaxDeliveryConcurrency > 0 && d.active >= config.MaxDeliveryConcurrency {
+		switch config.DeliveryOverflow {
+		case DeliveryOverflowQueue:
+			if len(d.queue) >= config.maxDeliveryQueue() {
+				return false
+			}
+			d.queue = append(d.queue, deliver)
+			return true
+		case DeliveryOverflowBlock:
+			if !d.wait(config.MaxDeliveryConcurrency, DeliveryBlockTimeout) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	d.active++
+	go d.work(deliver)
+	return true
+}
+
+// wait blocks until fewer than max deliveries are in progress, or the
+// timeout passes. Callers must hold the mutex.
+func (d *deliveryPool) wait(max int, timeout time.Duration) bool {
+	timedOut := false
+	timer := time.AfterFunc(timeout, func() {
+		d.mutex.Lock()
+		timedOut = true
+		d.idle.Broadcast()
+		d.mutex.Unlock()
+	})
+	defer timer.Stop()
+	for d.active >= max && !timedOut {
+		d.idle.Wait()
+	}
+	return d.active < max
+}
+
+// drain blocks until all deliveries, including those queued, have finished,
+// or the timeout passes. It returns false if deliveries are still in progress.
+func (d *deliveryPool) drain(timeout time.Duration) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.wait(1, timeout)
+}
+
+// work runs deliver, followed by any queued deliveries.
+func (d *deliveryPool) work(deliver func()) {
+	for deliver != nil {
+		deliver()
+		deliver = d.next()
+	}
+}
+
+// next takes the next queued delivery, or releases the goroutine if there
+// are none.
+func (d *deliveryPool) next() func() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.queue) > 0 {
+		deliver := d.queue[0]
+		d.queue = d.queue[1:]
+		return deliver
+	}
+	d.active--
+	d.idle.Broadcast()
+	return nil
+}
+
+func (config *Configuration) maxDeliveryQueue() int {
+	if config.MaxDeliveryQueue > 0 {
+		return config.MaxDeliveryQueue
+	}
+	return DefaultMaxDeliveryQueue
+}
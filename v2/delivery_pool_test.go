@@ -0,0 +1,219 @@
+package bugsnag
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyServer records the most requests which were in progress at once,
+// holding each request until release is closed.
+type concurrencyServer struct {
+	mutex     sync.Mutex
+	active    int
+	maxActive int
+	received  int
+	release   chan struct{}
+}
+
+func (s *concurrencyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.active++
+	s.received++
+	if s.active > s.maxActive {
+		s.maxActive = s.active
+	}
+	s.mutex.Unlock()
+	<-s.release
+	s.mutex.Lock()
+	s.active--
+	s.mutex.Unlock()
+}
+
+func (s *concurrencyServer) counts() (received, maxActive int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.received, s.maxActive
+}
+
+func waitForDeliveries(t *testing.T, s *concurrencyServer, exp int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		received, _ := s.counts()
+		if received >= exp {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d deliveries but only %d were received", exp, received)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForIdle waits for the deliveries started by a test to finish, so that
+// they don't count towards the limit in the next test.
+func waitForIdle(t *testing.T, pool *deliveryPool) {
+	deadline := time.Now().Add(5 * time.Second)
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for pool.active > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected deliveries to finish but %d are in progress", pool.active)
+		}
+		pool.mutex.Unlock()
+		time.Sleep(time.Millisecond)
+		pool.mutex.Lock()
+	}
+}
+
+func TestMaxDeliveryConcurrency(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	testCases := []struct {
+		name     string
+		overflow DeliveryOverflowPolicy
+		notified int
+		exp      int
+		dropped  int
+	}{
+		{"drop", DeliveryOverflowDrop, 5, 2, 3},
+		{"queue", DeliveryOverflowQueue, 5, 4, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			server := &concurrencyServer{release: make(chan struct{})}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			observer := &recordingMetricsObserver{}
+			config := generateSampleConfig(ts.URL)
+			config.NotifyReleaseStages = []string{"test"}
+			config.Logger = log.New(ioutil.Discard, "", 0)
+			config.MetricsObserver = observer
+			config.MaxDeliveryConcurrency = 2
+			config.DeliveryOverflow = tc.overflow
+			config.MaxDeliveryQueue = 2
+			notifier := New(config)
+			// Other tests leave the global configuration synchronous
+			notifier.Config.Synchronous = false
+
+			for i := 0; i < tc.notified; i++ {
+				notifier.Notify(fmt.Errorf("error %d", i))
+			}
+			waitForDeliveries(st, server, 2)
+			close(server.release)
+			waitForDeliveries(st, server, tc.exp)
+			waitForIdle(st, deliveries)
+
+			received, maxActive := server.counts()
+			if maxActive > config.MaxDeliveryConcurrency {
+				st.Errorf("Expected at most %d deliveries at once but there were %d", config.MaxDeliveryConcurrency, maxActive)
+			}
+			if received != tc.exp {
+				st.Errorf("Expected %d events to be delivered but %d were", tc.exp, received)
+			}
+			dropped := 0
+			observer.mutex.Lock()
+			for _, call := range observer.calls {
+				if call == "dropped "+string(DropReasonDeliveryOverflow) {
+					dropped++
+				}
+			}
+			observer.mutex.Unlock()
+			if dropped != tc.dropped {
+				st.Errorf("Expected %d events to be dropped but %d were", tc.dropped, dropped)
+			}
+		})
+	}
+}
+
+func TestDeliveryOverflowBlock(t *testing.T) {
+	defer func(timeout time.Duration) { DeliveryBlockTimeout = timeout }(DeliveryBlockTimeout)
+	DeliveryBlockTimeout = 20 * time.Millisecond
+
+	pool := newDeliveryPool()
+	config := &Configuration{MaxDeliveryConcurrency: 1, DeliveryOverflow: DeliveryOverflowBlock}
+	release := make(chan struct{})
+	if !pool.run(config, func() { <-release }) {
+		t.Fatal("Expected the first delivery to start")
+	}
+	if pool.run(config, func() {}) {
+		t.Errorf("Expected the delivery to be dropped once the block timeout passed")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		close(release)
+	}()
+	DeliveryBlockTimeout = 5 * time.Second
+	if !pool.run(config, func() { close(done) }) {
+		t.Fatal("Expected the delivery to start once the first one finished")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the blocked delivery to run")
+	}
+}
+
+func TestDeliveryPoolDrain(t *testing.T) {
+	pool := newDeliveryPool()
+	config := &Configuration{}
+	release := make(chan struct{})
+	delivered := make(chan struct{})
+	pool.run(config, func() {
+		<-release
+		close(delivered)
+	})
+	if pool.drain(10 * time.Millisecond) {
+		t.Errorf("Expected drain to time out while the delivery is in progress")
+	}
+	close(release)
+	if !pool.drain(5 * time.Second) {
+		t.Fatal("Expected drain to wait for the delivery to finish")
+	}
+	select {
+	case <-delivered:
+	default:
+		t.Errorf("Expected the delivery to have finished when drain returned")
+	}
+}
+
+func TestOnShutdown(t *testing.T) {
+	defer SetAppPhase(Config.AppPhase)
+	resetCircuitBreakers(t)
+
+	server := &concurrencyServer{release: make(chan struct{})}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := generateSampleConfig(ts.URL)
+	config.NotifyReleaseStages = []string{"test"}
+	config.Logger = log.New(ioutil.Discard, "", 0)
+	notifier := New(config)
+	notifier.Config.Synchronous = false
+
+	notifier.Notify(fmt.Errorf("oops"))
+	waitForDeliveries(t, server, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(server.release)
+	}()
+	OnShutdown()
+
+	deliveries.mutex.Lock()
+	active := deliveries.active
+	deliveries.mutex.Unlock()
+	if active != 0 {
+		t.Errorf("Expected OnShutdown to wait for deliveries but %d are in progress", active)
+	}
+	if Config.AppPhase != AppPhaseStopping {
+		t.Errorf("Expected OnShutdown to set the app phase to '%s' but it was '%s'", AppPhaseStopping, Config.AppPhase)
+	}
+}
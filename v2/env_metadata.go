@@ -44,3 +44,15 @@ func parseMetadataKeypath(key string) (string, error) {
 	}
 	return "", fmt.Errorf("No metadata prefix found")
 }
+
+// loadAllowlistedEnv returns the values of the environment variables in the
+// allowlist which are set, using lookupEnv to look up each one.
+func loadAllowlistedEnv(allowlist []string, lookupEnv func(string) (string, bool)) map[string]string {
+	env := make(map[string]string, len(allowlist))
+	for _, name := range allowlist {
+		if value, ok := lookupEnv(name); ok {
+			env[name] = value
+		}
+	}
+	return env
+}
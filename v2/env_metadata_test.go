@@ -1,6 +1,10 @@
 package bugsnag
 
-import "testing"
+import (
+	"os"
+	"reflect"
+	"testing"
+)
 
 func TestParseMetadataKeypath(t *testing.T) {
 	type output struct {
@@ -63,3 +67,33 @@ func TestLoadEnvMetadata(t *testing.T) {
 		}
 	}
 }
+
+func TestEnvAllowlist(t *testing.T) {
+	for name, value := range map[string]string{
+		"BUGSNAG_TEST_REGION":      "eu-west-1",
+		"BUGSNAG_TEST_DB_PASSWORD": "hunter2",
+		"BUGSNAG_TEST_UNLISTED":    "not sent",
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	config := &Configuration{ParamsFilters: []string{"password"}}
+	config.update(&Configuration{EnvAllowlist: []string{"BUGSNAG_TEST_REGION", "BUGSNAG_TEST_DB_PASSWORD", "BUGSNAG_TEST_UNSET"}})
+	// The variables are read once, when the configuration is updated
+	os.Setenv("BUGSNAG_TEST_REGION", "us-east-1")
+
+	event := &Event{MetaData: make(MetaData)}
+	if err := envMiddleware(event, config); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]interface{}{
+		"env": map[string]interface{}{
+			"BUGSNAG_TEST_REGION":      "eu-west-1",
+			"BUGSNAG_TEST_DB_PASSWORD": "[FILTERED]",
+		},
+	}
+	if got := event.MetaData.sanitize(config.ParamsFilters); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected the env tab to be '%v' but was '%v'", exp, got)
+	}
+}
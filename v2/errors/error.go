@@ -102,9 +102,44 @@ func New(e interface{}, skip int) *Error {
 	}
 }
 
+// NewWithoutStack makes an Error from the given value in the same way as New,
+// but without capturing the stack, which saves the cost of walking it when
+// the stacktrace won't be used. An error which already has a stack keeps it.
+func NewWithoutStack(e interface{}) *Error {
+	switch e := e.(type) {
+	case *Error:
+		return e
+	case error:
+		if hasStack(e) {
+			return New(e, 0)
+		}
+		return &Error{Err: e, Cause: unwrapCause(e)}
+	default:
+		return &Error{Err: fmt.Errorf("%v", e)}
+	}
+}
+
+// NewPanic makes an Error from a value recovered from a panic. Errors and
+// strings are handled in the same way as New. Any other value is described
+// using its String method if it implements fmt.Stringer, or otherwise
+// formatted with "%v", and its concrete type is used as the TypeName so that
+// panics with different types of value can be told apart. The skip parameter
+// is the same as for New.
+func NewPanic(value interface{}, skip int) *Error {
+	switch value := value.(type) {
+	case error, string, nil:
+		return New(value, skip+1)
+	case fmt.Stringer:
+		return New(uncaughtPanic{typeName: reflect.TypeOf(value).String(), message: value.String()}, skip+1)
+	default:
+		return New(uncaughtPanic{typeName: reflect.TypeOf(value).String(), message: fmt.Sprintf("%v", value)}, skip+1)
+	}
+}
+
 // Errorf creates a new error with the given message. You can use it
 // as a drop-in replacement for fmt.Errorf() to provide descriptive
-// errors in return values.
+// errors in return values. The stacktrace is captured where Errorf is
+// called, so the error is reported from there wherever it is notified.
 func Errorf(format string, a ...interface{}) *Error {
 	return New(fmt.Errorf(format, a...), 1)
 }
@@ -306,6 +306,22 @@ func TestExampleErrorf(t *testing.T) {
 	}
 }
 
+func TestErrorfStack(t *testing.T) {
+	_, _, line, _ := runtime.Caller(0)
+	err := Errorf("wrapped: %w", io.EOF)
+
+	frame := err.StackFrames()[0]
+	if frame.Name != "TestErrorfStack" || frame.LineNumber != line+1 {
+		t.Errorf("Expected the top frame to be the call to Errorf on line %d but was %s:%d", line+1, frame.Name, frame.LineNumber)
+	}
+	if err.Error() != "wrapped: EOF" {
+		t.Errorf("Expected the message to be formatted but was '%s'", err.Error())
+	}
+	if err.Unwrap() != io.EOF {
+		t.Errorf("Expected the error to wrap io.EOF but wrapped '%v'", err.Unwrap())
+	}
+}
+
 func TestExampleNew(t *testing.T) {
 	// Wrap io.EOF with the current stack-trace and return it
 	e := New(io.EOF, 0)
@@ -324,3 +340,55 @@ func ExampleNew_skip() {
 		}
 	}()
 }
+
+type panicStruct struct {
+	Code int
+}
+
+type panicStringer struct{}
+
+func (panicStringer) String() string { return "a stringer" }
+
+func TestNewWithoutStack(t *testing.T) {
+	err := NewWithoutStack(fmt.Errorf("cache miss"))
+	if len(err.Callers()) != 0 {
+		t.Errorf("Expected no stack to be captured but there were %d callers", len(err.Callers()))
+	}
+	if err.Error() != "cache miss" {
+		t.Errorf("Expected the message to be kept but was '%s'", err.Error())
+	}
+	if got := NewWithoutStack(err); got != err {
+		t.Errorf("Expected an Error to be returned as it is")
+	}
+	if got := NewWithoutStack(42).Error(); got != "42" {
+		t.Errorf("Expected a value to be formatted as the message but was '%s'", got)
+	}
+}
+
+func TestNewPanic(t *testing.T) {
+	for _, tc := range []struct {
+		value    interface{}
+		message  string
+		typeName string
+	}{
+		{value: 42, message: "42", typeName: "int"},
+		{value: panicStruct{Code: 7}, message: "{7}", typeName: "errors.panicStruct"},
+		{value: &panicStruct{Code: 7}, message: "&{7}", typeName: "*errors.panicStruct"},
+		{value: panicStringer{}, message: "a stringer", typeName: "errors.panicStringer"},
+		{value: "a string", message: "a string", typeName: "*errors.errorString"},
+		{value: fmt.Errorf("an error"), message: "an error", typeName: "*errors.errorString"},
+	} {
+		err := func() (err *Error) {
+			defer func() {
+				err = NewPanic(recover(), 0)
+			}()
+			panic(tc.value)
+		}()
+		if got := err.Error(); got != tc.message {
+			t.Errorf("Expected panic with %#v to have message '%s' but was '%s'", tc.value, tc.message, got)
+		}
+		if got := err.TypeName(); got != tc.typeName {
+			t.Errorf("Expected panic with %#v to have type '%s' but was '%s'", tc.value, tc.typeName, got)
+		}
+	}
+}
@@ -2,12 +2,23 @@ package bugsnag
 
 import (
 	"context"
+	"encoding/base64"
 	"net/http"
+	"reflect"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/bugsnag/bugsnag-go/v2/errors"
 )
 
+// MaxAttachmentSize is the maximum number of bytes of attachment data which
+// can be added to a single event with Event.AddAttachment.
+var MaxAttachmentSize = 32 * 1024
+
+// attachmentsTab is the metadata tab which attachments are sent in.
+const attachmentsTab = "attachments"
+
 // Context is the context of the error in Bugsnag.
 // This can be passed to Notify, Recover or AutoNotify as rawData.
 type Context struct {
@@ -23,12 +34,90 @@ type User struct {
 	Email string `json:"email,omitempty"`
 }
 
+// Tags are searchable key-value labels, such as the tenant or region, which
+// are attached to an event separately from its MetaData. Tags are not
+// redacted by ParamsFilters. This can be passed to Notify, Recover or
+// AutoNotify as rawData.
+type Tags map[string]string
+
+// Job describes a failed background job. The job is added to the "job" tab
+// and the queue name is used as the context of the event, unless a context
+// is set some other way. This can be passed to Notify, Recover or AutoNotify
+// as rawData.
+type Job struct {
+	// Queue is the name of the queue the job was taken from.
+	Queue string
+	// ID identifies the job, e.g. its ID in the queue or a digest of its
+	// payload.
+	ID string
+	// Attempt is the number of times the job has been tried, including the
+	// failed attempt, which distinguishes failures that will be retried from
+	// terminal ones.
+	Attempt int
+}
+
+// SQLContext describes the database query which failed. The query is added to
+// the "sql" tab. Its arguments can contain personal data, so are replaced with
+// [FILTERED] unless Configuration.SendSQLArgs is set. This can be passed to
+// Notify, Recover or AutoNotify as rawData.
+type SQLContext struct {
+	// Query is the SQL statement, with placeholders for its arguments.
+	Query string
+	// Args are the arguments of the statement.
+	Args []interface{}
+	// Duration is how long the query ran for before failing.
+	Duration time.Duration
+}
+
+// OutboundRequest describes a failed request made by the application to
+// another service. The request is added to the "outboundRequest" tab, with any
+// query parameters matching the ParamsFilters filtered out of the URL. This
+// can be passed to Notify, Recover or AutoNotify as rawData.
+type OutboundRequest struct {
+	// Method is the HTTP method of the request, e.g. "GET".
+	Method string
+	// URL is the URL which the request was sent to.
+	URL string
+	// Status is the HTTP status code of the response, or zero if no response
+	// was received.
+	Status int
+	// Duration is how long the request took, until the response headers
+	// were received or it failed.
+	Duration time.Duration
+}
+
 // ErrorClass overrides the error class in Bugsnag.
 // This struct enables you to group errors as you like.
 type ErrorClass struct {
 	Name string
 }
 
+// Unhandled sets whether the error is reported as unhandled, and so counts
+// against the stability score, regardless of whether it was reported with
+// Notify or AutoNotify. This can be passed to Notify, Recover or AutoNotify
+// as rawData.
+type Unhandled bool
+
+// OccurredAt is when the error occurred, for events which are reported
+// later, such as those replayed from persistent storage or forwarded from a
+// queue. Events are otherwise reported as occurring when they were notified.
+// This can be passed to Notify, Recover or AutoNotify as rawData.
+type OccurredAt time.Time
+
+// ResponseStatus is the HTTP response status which caused an event, which is
+// reported as part of its severity reason. Pass it as rawData along with a
+// HandledState whose SeverityReason is SeverityReasonHandledServerError.
+type ResponseStatus int
+
+// StackSkip is the number of extra frames to leave off the top of the
+// stacktrace captured by Notify, e.g. to leave out the frame of a function
+// which wraps Notify. It takes precedence over Configuration.StackSkip. This
+// can be passed to Notify as rawData.
+type StackSkip int
+
+// messageErrorClass is the error class of reports sent with NotifyMessage.
+const messageErrorClass = "Message"
+
 // Sets the severity of the error on Bugsnag. These values can be
 // passed to Notify, Recover or AutoNotify as rawData.
 var (
@@ -42,7 +131,23 @@ type severity struct {
 	String string
 }
 
-// The form of stacktrace that Bugsnag expects
+// rank orders severities from SeverityInfo, the lowest, to SeverityError.
+// Unknown severities rank below SeverityInfo.
+func (s severity) rank() int {
+	switch s {
+	case SeverityInfo:
+		return 1
+	case SeverityWarning:
+		return 2
+	case SeverityError:
+		return 3
+	}
+	return 0
+}
+
+// The form of stacktrace that Bugsnag expects. A []StackFrame can be passed to
+// Notify as rawData to report an error with a stacktrace from elsewhere, such
+// as one forwarded from another process, instead of the current one.
 type StackFrame struct {
 	Method     string `json:"method"`
 	File       string `json:"file"`
@@ -50,23 +155,100 @@ type StackFrame struct {
 	InProject  bool   `json:"inProject,omitempty"`
 }
 
+// SeverityReason describes why an event has its severity, and is shown as the
+// reason for the severity in the Bugsnag dashboard. The severity reason is set
+// automatically, but can be overridden by passing one of these values to
+// Notify, Recover or AutoNotify as rawData, e.g. to group errors which are
+// reported from logs separately from those reported as exceptions.
 type SeverityReason string
 
 const (
-	SeverityReasonCallbackSpecified        SeverityReason = "userCallbackSetSeverity"
-	SeverityReasonHandledError                            = "handledError"
-	SeverityReasonHandledPanic                            = "handledPanic"
-	SeverityReasonUnhandledError                          = "unhandledError"
-	SeverityReasonUnhandledMiddlewareError                = "unhandledErrorMiddleware"
-	SeverityReasonUnhandledPanic                          = "unhandledPanic"
-	SeverityReasonUserSpecified                           = "userSpecifiedSeverity"
+	// SeverityReasonCallbackSpecified is for events whose severity was
+	// changed by an OnBeforeNotify callback. It is set automatically.
+	SeverityReasonCallbackSpecified SeverityReason = "userCallbackSetSeverity"
+	// SeverityReasonHandledError is for errors passed to Notify. It is the
+	// default for handled events.
+	SeverityReasonHandledError SeverityReason = "handledError"
+	// SeverityReasonHandledPanic is for panics recovered by AutoNotify or
+	// Recover.
+	SeverityReasonHandledPanic SeverityReason = "handledPanic"
+	// SeverityReasonHandledServerError is for HTTP responses with a server
+	// error status, along with its ResponseStatus.
+	SeverityReasonHandledServerError SeverityReason = "handledServerError"
+	// SeverityReasonUnhandledError is for errors which were not handled by
+	// the application. It is the default for unhandled events.
+	SeverityReasonUnhandledError SeverityReason = "unhandledError"
+	// SeverityReasonUnhandledMiddlewareError is for errors and panics caught
+	// by framework middleware, along with HandledState.Framework.
+	SeverityReasonUnhandledMiddlewareError SeverityReason = "unhandledErrorMiddleware"
+	// SeverityReasonUnhandledPanic is for panics which would have crashed the
+	// application, such as those caught by the panic handler.
+	SeverityReasonUnhandledPanic SeverityReason = "unhandledPanic"
+	// SeverityReasonUserSpecified is for events given a severity in the
+	// rawData. It is set automatically.
+	SeverityReasonUserSpecified SeverityReason = "userSpecifiedSeverity"
+
+	// SeverityReasonHandledException is for exceptions which were caught
+	// and reported, e.g. when forwarding errors from another runtime.
+	SeverityReasonHandledException SeverityReason = "handledException"
+	// SeverityReasonUnhandledException is for exceptions which were not
+	// caught, e.g. when forwarding errors from another runtime.
+	SeverityReasonUnhandledException SeverityReason = "unhandledException"
+	// SeverityReasonLog is for errors reported from a log message.
+	SeverityReasonLog SeverityReason = "log"
+	// SeverityReasonSignal is for errors caused by the process receiving a
+	// signal.
+	SeverityReasonSignal SeverityReason = "signal"
+	// SeverityReasonErrorClass is for errors whose severity was chosen based
+	// on their error class.
+	SeverityReasonErrorClass SeverityReason = "errorClass"
 )
 
+// HandledState describes how an event came to be reported, which Bugsnag
+// uses for the severity reason and stability score. It can be passed to
+// Notify, Recover or AutoNotify as rawData, e.g. by framework integrations to
+// report panics in route handlers as unhandled. Use NewHandledState to create
+// one.
 type HandledState struct {
-	SeverityReason   SeverityReason
+	// SeverityReason is why the event has its severity.
+	SeverityReason SeverityReason
+	// OriginalSeverity is the severity of the event before any
+	// OnBeforeNotify callbacks changed it.
 	OriginalSeverity severity
-	Unhandled        bool
-	Framework        string
+	// Unhandled is whether the error wasn't handled by the application, and
+	// so counts against its stability score.
+	Unhandled bool
+	// Framework is the name of the framework which caught the error, when the
+	// SeverityReason is SeverityReasonUnhandledMiddlewareError.
+	Framework string
+}
+
+// NewHandledState creates a HandledState for an event reported with the given
+// reason and severity. Integrations should report errors and panics which
+// would otherwise have escaped the application, e.g. a panic in a route
+// handler, as unhandled, and errors which the application caught and returned
+// as handled. If reason is empty SeverityReasonUnhandledError or
+// SeverityReasonHandledError is used, and if sev is the zero value
+// SeverityError or SeverityWarning is used, depending on unhandled.
+func NewHandledState(reason SeverityReason, sev severity, unhandled bool, framework string) HandledState {
+	if reason == "" {
+		reason = SeverityReasonHandledError
+		if unhandled {
+			reason = SeverityReasonUnhandledError
+		}
+	}
+	if sev == (severity{}) {
+		sev = SeverityWarning
+		if unhandled {
+			sev = SeverityError
+		}
+	}
+	return HandledState{
+		SeverityReason:   reason,
+		OriginalSeverity: sev,
+		Unhandled:        unhandled,
+		Framework:        framework,
+	}
 }
 
 // Event represents a payload of data that gets sent to Bugsnag.
@@ -100,22 +282,46 @@ type Event struct {
 	User *User
 	// Other MetaData to send to Bugsnag. Appears as a set of tabbed tables in the dashboard.
 	MetaData MetaData
+	// Tags to send to Bugsnag. These appear in the "tags" tab in the dashboard
+	// and are not subject to ParamsFilters.
+	Tags Tags
+	// Breadcrumbs left with LeaveBreadcrumb before the event, oldest first.
+	Breadcrumbs []Breadcrumb
+	// Time is when the event occurred. This defaults to when it was notified,
+	// unless OccurredAt is passed in as rawData.
+	Time time.Time
 	// Ctx is the context of the session the event occurred in. This allows Bugsnag to associate the event with the session.
 	Ctx context.Context
 	// Request is the request information that populates the Request tab in the dashboard.
 	Request *RequestJSON
+	// TraceID and SpanID identify the distributed trace the event occurred
+	// in. When using payload version 5 these are sent as the event's
+	// correlation, otherwise they appear in the "trace" tab in the dashboard.
+	TraceID string
+	SpanID  string
 	// The reason for the severity and original value
 	handledState HandledState
 	// True if the event was caused by an automatic event
 	Unhandled bool
+	// The number of attachment bytes added with AddAttachment
+	attachmentSize int
+	// The components set with SetGroupingComponents
+	groupingComponents []string
+	// The notifier which built the event, whose events are batched together
+	notifier *Notifier
+	// The ResponseStatus which caused the event
+	responseStatus int
 }
 
 func newEvent(rawData []interface{}, notifier *Notifier) (*Event, *Configuration) {
-	config := notifier.Config
+	// Each event has its own copy of the configuration, so that middleware
+	// can change it for just this event
+	config := cloneConfig(notifier.Config)
 	event := &Event{
 		RawData:  append(notifier.RawData, rawData...),
 		Severity: SeverityWarning,
 		MetaData: make(MetaData),
+		Tags:     make(Tags),
 		handledState: HandledState{
 			SeverityReason:   SeverityReasonHandledError,
 			OriginalSeverity: SeverityWarning,
@@ -123,10 +329,18 @@ func newEvent(rawData []interface{}, notifier *Notifier) (*Event, *Configuration
 			Framework:        "",
 		},
 		Unhandled: false,
+		notifier:  notifier,
 	}
 
 	var err *errors.Error
 	var callbacks []func(*Event)
+	var explicitUser bool
+	var contextUser *User
+	var explicitStack []StackFrame
+	var explicitUnhandled *Unhandled
+	var explicitReason SeverityReason
+	var explicitSeverity bool
+	var contextSeverity *severity
 
 	for _, datum := range event.RawData {
 		switch datum := datum.(type) {
@@ -139,12 +353,12 @@ func newEvent(rawData []interface{}, notifier *Notifier) (*Event, *Configuration
 				event.ErrorClass = err.TypeName()
 			}
 			event.Message = err.Error()
-			event.Stacktrace = make([]StackFrame, len(err.StackFrames()))
 
 		case bool:
 			config = config.merge(&Configuration{Synchronous: bool(datum)})
 
 		case severity:
+			explicitSeverity = true
 			event.Severity = datum
 			event.handledState.OriginalSeverity = datum
 			event.handledState.SeverityReason = SeverityReasonUserSpecified
@@ -154,6 +368,12 @@ func newEvent(rawData []interface{}, notifier *Notifier) (*Event, *Configuration
 
 		case context.Context:
 			populateEventWithContext(datum, event)
+			if user := userFromContext(datum); user != nil {
+				contextUser = user
+			}
+			if s := severityFromContext(datum); s != nil {
+				contextSeverity = s
+			}
 
 		case *http.Request:
 			populateEventWithRequest(datum, event)
@@ -164,8 +384,17 @@ func newEvent(rawData []interface{}, notifier *Notifier) (*Event, *Configuration
 		case MetaData:
 			event.MetaData.Update(datum)
 
+		case MetaDataSnapshot:
+			event.MetaData.Update(MetaData{datum.tab: datum.data})
+
 		case User:
 			event.User = &datum
+			explicitUser = true
+
+		case Tags:
+			for key, value := range datum {
+				event.Tags[key] = value
+			}
 
 		case ErrorClass:
 			event.ErrorClass = datum.Name
@@ -174,12 +403,76 @@ func newEvent(rawData []interface{}, notifier *Notifier) (*Event, *Configuration
 			event.handledState = datum
 			event.Severity = datum.OriginalSeverity
 			event.Unhandled = datum.Unhandled
+		case ResponseStatus:
+			event.responseStatus = int(datum)
+		case Unhandled:
+			explicitUnhandled = &datum
+
+		case OccurredAt:
+			event.Time = time.Time(datum)
+
+		case SeverityReason:
+			explicitReason = datum
+
+		case []StackFrame:
+			explicitStack = validStackFrames(datum)
+
 		case func(*Event):
 			callbacks = append(callbacks, datum)
 		}
 	}
 
-	event.Stacktrace = generateStacktrace(err, config)
+	// Applied after the loop so that the HandledState added by AutoNotify and
+	// Recover doesn't replace them
+	if explicitUnhandled != nil {
+		event.handledState.setUnhandled(bool(*explicitUnhandled))
+		event.Unhandled = event.handledState.Unhandled
+	}
+	// A severity attached to the context replaces the default, but not one
+	// passed in explicitly. Panics keep their reason.
+	if contextSeverity != nil && !explicitSeverity {
+		event.Severity = *contextSeverity
+		event.handledState.OriginalSeverity = *contextSeverity
+		if event.handledState.SeverityReason == SeverityReasonHandledError {
+			event.handledState.SeverityReason = SeverityReasonUserSpecified
+		}
+	}
+	if explicitReason != "" {
+		event.handledState.SeverityReason = explicitReason
+	}
+	if event.Time.IsZero() {
+		event.Time = config.currentTime()
+	}
+
+	if len(explicitStack) > 0 {
+		event.Stacktrace = explicitStack
+	} else if config.DisableStacktraces {
+		event.Stacktrace = []StackFrame{}
+	} else {
+		event.Stacktrace = generateStacktrace(err, config)
+	}
+
+	// A user attached to the context takes precedence over the default
+	// derived from the request, but not over one passed in explicitly.
+	if !explicitUser && contextUser != nil {
+		event.User = contextUser
+	} else if !explicitUser {
+		request, _ := event.GetHTTPRequest()
+		if request == nil {
+			request = getRequestIfPresent(event.Ctx)
+		}
+		if user := config.userFromRequest(request); user != nil {
+			event.User = user
+		}
+	}
+
+	event.Breadcrumbs = eventBreadcrumbs(event.Ctx, config.maxBreadcrumbs())
+
+	for key, value := range config.Tags {
+		if _, ok := event.Tags[key]; !ok {
+			event.Tags[key] = value
+		}
+	}
 
 	for _, callback := range callbacks {
 		callback(event)
@@ -188,22 +481,211 @@ func newEvent(rawData []interface{}, notifier *Notifier) (*Event, *Configuration
 		}
 	}
 
+	if message, truncated := truncateMessage(event.Message, config.maxMessageBytes()); truncated {
+		if config.PreserveFullMessage {
+			event.MetaData.Add("error", "fullMessage", event.Message)
+		}
+		event.Message = message
+	}
+
 	return event, config
 }
 
-func generateStacktrace(err *errors.Error, config *Configuration) []StackFrame {
-	stack := make([]StackFrame, len(err.StackFrames()))
-	for i, frame := range err.StackFrames() {
-		file := frame.File
-		inProject := config.isProjectPackage(frame.Package)
+// truncatedMessageSuffix ends messages which were longer than
+// MaxMessageBytes.
+const truncatedMessageSuffix = "…[truncated]"
 
-		// This will trim path before package name for external packages and golang default packages
-		// Excluding main package as it's special case
-		// This will NOT trim paths for packages in current module because path won't contain the package name
-		// Example: path is "/user/name/work/internal/internal.go" and module package name is "example.com/mymodule/internal"
-		if idx := strings.Index(file, frame.Package); idx > -1 && frame.Package != "main" {
-			file = file[idx:]
+// truncateMessage shortens a message to at most max bytes, including the
+// truncatedMessageSuffix, without splitting a UTF-8 character.
+func truncateMessage(message string, max int) (string, bool) {
+	if len(message) <= max {
+		return message, false
+	}
+	end := max - len(truncatedMessageSuffix)
+	if end < 0 {
+		end = 0
+	}
+	for end > 0 && !utf8.RuneStart(message[end]) {
+		end--
+	}
+	return message[:end] + truncatedMessageSuffix, true
+}
+
+// setUnhandled changes the handled-ness of the state. The severity reason of
+// an error is updated to match, but a panic keeps its reason, as AutoNotify
+// and Recover already report panics as "handledPanic" whether or not they are
+// unhandled, and a severity set by the user keeps its reason too.
+func (h *HandledState) setUnhandled(unhandled bool) {
+	if h.Unhandled == unhandled {
+		return
+	}
+	h.Unhandled = unhandled
+	switch h.SeverityReason {
+	case SeverityReasonHandledError, SeverityReasonHandledServerError,
+		SeverityReasonUnhandledError, SeverityReasonUnhandledMiddlewareError:
+		if unhandled {
+			h.SeverityReason = SeverityReasonUnhandledError
+		} else {
+			h.SeverityReason = SeverityReasonHandledError
+		}
+	}
+}
+
+// First finds the first value in the event's RawData which can be assigned to
+// the value target points to, and if there is one, sets target to it and
+// returns true. Nil pointers are skipped. It is intended for OnBeforeNotify
+// callbacks, e.g.
+//
+//	var job MyJob
+//	if event.First(&job) {
+//		event.MetaData.Add("job", "name", job.Name)
+//	}
+//
+// First panics if target is not a non-nil pointer.
+func (event *Event) First(target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		panic("bugsnag: First target must be a non-nil pointer")
+	}
+	targetType := val.Type().Elem()
+	for _, datum := range event.RawData {
+		if datum == nil {
+			continue
+		}
+		v := reflect.ValueOf(datum)
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			continue
 		}
+		if v.Type().AssignableTo(targetType) {
+			val.Elem().Set(v)
+			return true
+		}
+	}
+	return false
+}
+
+// GetHTTPRequest returns the first *http.Request in the event's RawData.
+func (event *Event) GetHTTPRequest() (*http.Request, bool) {
+	for _, datum := range event.RawData {
+		if request, ok := datum.(*http.Request); ok && request != nil {
+			return request, true
+		}
+	}
+	return nil, false
+}
+
+// GetContext returns the first context.Context in the event's RawData.
+func (event *Event) GetContext() (context.Context, bool) {
+	for _, datum := range event.RawData {
+		if ctx, ok := datum.(context.Context); ok && ctx != nil {
+			return ctx, true
+		}
+	}
+	return nil, false
+}
+
+// AddTag adds a searchable key-value label to the event. If the key already
+// exists, it will be overwritten.
+func (event *Event) AddTag(key, value string) {
+	if event.Tags == nil {
+		event.Tags = make(Tags)
+	}
+	event.Tags[key] = value
+}
+
+// SetGroupingComponents groups the event by its error class along with the
+// given components, e.g. the name of the downstream service which failed, so
+// that errors are grouped per class per service. The class and components
+// are joined, in the order given, into the grouping hash when the event is
+// sent, so the class is the final one set by any OnBeforeNotify callback.
+// The components take precedence over the GroupingHash, including one set
+// by the default callbacks, such as for a ValidationError. Calling it with
+// no components goes back to using the GroupingHash.
+func (event *Event) SetGroupingComponents(components ...string) {
+	event.groupingComponents = append([]string(nil), components...)
+}
+
+// groupingHash returns the grouping hash to send, built from the grouping
+// components if any were set. Separators within the class and components
+// are escaped so that different components always give different hashes.
+func (event *Event) groupingHash() string {
+	if len(event.groupingComponents) == 0 {
+		return event.GroupingHash
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, "|", `\|`)
+	parts := make([]string, 0, len(event.groupingComponents)+1)
+	for _, part := range append([]string{event.ErrorClass}, event.groupingComponents...) {
+		parts = append(parts, escaper.Replace(part))
+	}
+	return strings.Join(parts, "|")
+}
+
+// AddAttachment attaches a small file, such as the last lines of a worker's
+// output, to the event. The Bugsnag event API doesn't accept attachments, so
+// the data is sent in the "attachments" tab of the dashboard instead. Text
+// and JSON content is sent as a string, anything else is base64 encoded.
+// Data beyond MaxAttachmentSize bytes in total across the event's
+// attachments is truncated.
+func (event *Event) AddAttachment(name, contentType string, data []byte) {
+	remaining := MaxAttachmentSize - event.attachmentSize
+	if remaining < 0 {
+		remaining = 0
+	}
+	truncated := len(data) > remaining
+	if truncated {
+		data = data[:remaining]
+	}
+	event.attachmentSize += len(data)
+
+	var encoded string
+	if isTextContentType(contentType) {
+		encoded = string(data)
+	} else {
+		encoded = base64.StdEncoding.EncodeToString(data)
+	}
+	if event.MetaData == nil {
+		event.MetaData = make(MetaData)
+	}
+	event.MetaData.Add(attachmentsTab, name, map[string]interface{}{
+		"contentType": contentType,
+		"data":        encoded,
+		"truncated":   truncated,
+	})
+}
+
+func isTextContentType(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// validStackFrames returns a copy of the given frames without any that have
+// neither a method nor a file, as they can't be displayed.
+func validStackFrames(frames []StackFrame) []StackFrame {
+	valid := make([]StackFrame, 0, len(frames))
+	for _, frame := range frames {
+		if frame.Method == "" && frame.File == "" {
+			continue
+		}
+		if frame.LineNumber < 0 {
+			frame.LineNumber = 0
+		}
+		valid = append(valid, frame)
+	}
+	return valid
+}
+
+func generateStacktrace(err *errors.Error, config *Configuration) []StackFrame {
+	frames := err.StackFrames()
+	stack := make([]StackFrame, 0, len(frames))
+	for i := range frames {
+		frame := &frames[i]
+		if config.StackFrameFilter != nil && !config.StackFrameFilter(frame) {
+			continue
+		}
+		inProject := config.isProjectPackage(frame.Package)
+		file := config.trimFilePath(frame.File, frame.Package)
 
 		// This should trim path for main and other current module packages with correct config
 		// If input path is "/user/name/work/internal/internal.go"
@@ -214,12 +696,12 @@ func generateStacktrace(err *errors.Error, config *Configuration) []StackFrame {
 			file = config.stripProjectPackages(file)
 		}
 
-		stack[i] = StackFrame{
+		stack = append(stack, StackFrame{
 			Method:     frame.Name,
 			File:       file,
 			LineNumber: frame.LineNumber,
 			InProject:  inProject,
-		}
+		})
 	}
 
 	return stack
@@ -2,11 +2,14 @@ package bugsnag
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/bugsnag/bugsnag-go/v2/errors"
 )
 
 func TestPopulateEvent(t *testing.T) {
@@ -35,3 +38,266 @@ func TestPopulateEvent(t *testing.T) {
 		}
 	}
 }
+
+func TestPopulateEventTags(t *testing.T) {
+	notifier := New(Configuration{Tags: Tags{"region": "us-east-1", "tenant": "default"}})
+	event, _ := newEvent([]interface{}{fmt.Errorf("oops"), Tags{"tenant": "acme"}}, notifier)
+	event.AddTag("shard", "7")
+
+	exp := Tags{"region": "us-east-1", "tenant": "acme", "shard": "7"}
+	if !reflect.DeepEqual(event.Tags, exp) {
+		t.Errorf("Expected tags to be '%+v' but was '%+v'", exp, event.Tags)
+	}
+}
+
+func TestAddAttachment(t *testing.T) {
+	defer func(size int) { MaxAttachmentSize = size }(MaxAttachmentSize)
+	MaxAttachmentSize = 10
+
+	event := &Event{}
+	event.AddAttachment("output.log", "text/plain; charset=utf-8", []byte("line 1\nline 2\n"))
+	event.AddAttachment("state.bin", "application/octet-stream", []byte{0xff})
+
+	exp := MetaData{attachmentsTab: {
+		"output.log": map[string]interface{}{
+			"contentType": "text/plain; charset=utf-8",
+			"data":        "line 1\nlin",
+			"truncated":   true,
+		},
+		"state.bin": map[string]interface{}{
+			"contentType": "application/octet-stream",
+			"data":        "",
+			"truncated":   true,
+		},
+	}}
+	if !reflect.DeepEqual(event.MetaData, exp) {
+		t.Errorf("Expected attachments to be '%+v' but was '%+v'", exp, event.MetaData)
+	}
+
+	MaxAttachmentSize = 1024
+	event = &Event{}
+	event.AddAttachment("state.bin", "application/octet-stream", []byte{0xff, 0x00})
+	if got := event.MetaData[attachmentsTab]["state.bin"].(map[string]interface{})["data"]; got != "/wA=" {
+		t.Errorf("Expected binary attachment to be base64 encoded but was '%v'", got)
+	}
+}
+
+func TestPopulateEventExplicitStacktrace(t *testing.T) {
+	frames := []StackFrame{
+		{Method: "worker.(*Pool).run", File: "worker/pool.go", LineNumber: 88, InProject: true},
+		{},
+		{Method: "main.main", File: "main.go", LineNumber: -1},
+	}
+	event, _ := newEvent([]interface{}{fmt.Errorf("forwarded"), frames}, &defaultNotifier)
+
+	exp := []StackFrame{
+		{Method: "worker.(*Pool).run", File: "worker/pool.go", LineNumber: 88, InProject: true},
+		{Method: "main.main", File: "main.go", LineNumber: 0},
+	}
+	if !reflect.DeepEqual(event.Stacktrace, exp) {
+		t.Errorf("Expected stacktrace to be '%+v' but was '%+v'", exp, event.Stacktrace)
+	}
+
+	event, _ = newEvent([]interface{}{fmt.Errorf("forwarded"), []StackFrame{{}}}, &defaultNotifier)
+	if len(event.Stacktrace) == 0 || event.Stacktrace[0].Method != "TestPopulateEventExplicitStacktrace" {
+		t.Errorf("Expected the current stacktrace to be used when no valid frames are given but was '%+v'", event.Stacktrace)
+	}
+}
+
+func TestPopulateEventDisableStacktraces(t *testing.T) {
+	event, config := newEvent([]interface{}{fmt.Errorf("cache miss"), Configuration{DisableStacktraces: true}}, &defaultNotifier)
+	if event.Stacktrace == nil || len(event.Stacktrace) != 0 {
+		t.Errorf("Expected an empty stacktrace but was '%+v'", event.Stacktrace)
+	}
+	bytes, _ := (&payload{event, config}).MarshalJSON()
+	if got := string(bytes); !strings.Contains(got, `"stacktrace":[]`) {
+		t.Errorf("Expected payload to contain an empty stacktrace but was '%s'", got)
+	}
+
+	event, _ = newEvent([]interface{}{fmt.Errorf("cache miss")}, &defaultNotifier)
+	if len(event.Stacktrace) == 0 {
+		t.Errorf("Expected stacktraces to be captured by default")
+	}
+	if err := newError(fmt.Errorf("cache miss"), 0, &Config, []interface{}{Configuration{DisableStacktraces: true}}); len(err.Callers()) != 0 {
+		t.Errorf("Expected no stack to be captured but there were %d callers", len(err.Callers()))
+	}
+}
+
+func TestEventRawDataHelpers(t *testing.T) {
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-8a1f")
+	req := httptest.NewRequest("GET", "/invoices", nil)
+	var nilReq *http.Request
+	event := &Event{RawData: []interface{}{nil, nilReq, Job{Queue: "invoices"}, ctx, req, context.Background()}}
+
+	if got, ok := event.GetHTTPRequest(); !ok || got != req {
+		t.Errorf("Expected GetHTTPRequest to return the non-nil request but got %v, %v", got, ok)
+	}
+	if got, ok := event.GetContext(); !ok || got != ctx {
+		t.Errorf("Expected GetContext to return the first context but got %v, %v", got, ok)
+	}
+
+	var job Job
+	if !event.First(&job) || job.Queue != "invoices" {
+		t.Errorf("Expected First to find the job but got %+v", job)
+	}
+	var firstCtx context.Context
+	if !event.First(&firstCtx) || firstCtx != ctx {
+		t.Errorf("Expected First to find the first context but got %v", firstCtx)
+	}
+	var firstReq *http.Request
+	if !event.First(&firstReq) || firstReq != req {
+		t.Errorf("Expected First to skip the nil request but got %v", firstReq)
+	}
+	var user User
+	if event.First(&user) {
+		t.Errorf("Expected First not to find a user but got %+v", user)
+	}
+
+	empty := &Event{}
+	if _, ok := empty.GetHTTPRequest(); ok {
+		t.Errorf("Expected GetHTTPRequest to find nothing in empty rawData")
+	}
+	if _, ok := empty.GetContext(); ok {
+		t.Errorf("Expected GetContext to find nothing in empty rawData")
+	}
+}
+
+func TestPopulateEventStackFrameFilter(t *testing.T) {
+	unfiltered, _ := newEvent([]interface{}{fmt.Errorf("oops")}, &defaultNotifier)
+	config := Configuration{
+		ProjectPackages: []string{"github.com/bugsnag/bugsnag-go/v2"},
+		StackFrameFilter: func(frame *errors.StackFrame) bool {
+			return frame.Package != "testing"
+		},
+	}
+	event, _ := newEvent([]interface{}{fmt.Errorf("oops"), config}, &defaultNotifier)
+
+	if len(event.Stacktrace) == 0 || len(event.Stacktrace) >= len(unfiltered.Stacktrace) {
+		t.Fatalf("Expected some but not all frames to be removed, but got %d of %d", len(event.Stacktrace), len(unfiltered.Stacktrace))
+	}
+	for _, frame := range event.Stacktrace {
+		if strings.HasPrefix(frame.Method, "tRunner") || strings.HasSuffix(frame.File, "testing/testing.go") {
+			t.Errorf("Expected frames from the testing package to be removed but got %+v", frame)
+		}
+	}
+	if top := event.Stacktrace[0]; top.Method != "TestPopulateEventStackFrameFilter" || !top.InProject {
+		t.Errorf("Expected the top frame to be kept in the project but was %+v", top)
+	}
+}
+
+func TestPopulateEventUnhandled(t *testing.T) {
+	panicState := HandledState{SeverityReason: SeverityReasonHandledPanic, OriginalSeverity: SeverityError, Unhandled: true}
+	for _, tc := range []struct {
+		name      string
+		rawData   []interface{}
+		unhandled bool
+		reason    SeverityReason
+	}{
+		{name: "default", rawData: nil, unhandled: false, reason: SeverityReasonHandledError},
+		{name: "unhandled error", rawData: []interface{}{Unhandled(true)}, unhandled: true, reason: SeverityReasonUnhandledError},
+		{name: "handled error", rawData: []interface{}{Unhandled(false)}, unhandled: false, reason: SeverityReasonHandledError},
+		// As appended by AutoNotify after the caller's rawData
+		{name: "handled panic", rawData: []interface{}{Unhandled(false), panicState}, unhandled: false, reason: SeverityReasonHandledPanic},
+		{name: "user severity", rawData: []interface{}{SeverityInfo, Unhandled(true)}, unhandled: true, reason: SeverityReasonUserSpecified},
+	} {
+		t.Run(tc.name, func(st *testing.T) {
+			event, config := newEvent(append([]interface{}{fmt.Errorf("oops")}, tc.rawData...), &defaultNotifier)
+			if event.Unhandled != tc.unhandled || event.handledState.Unhandled != tc.unhandled {
+				st.Errorf("Expected unhandled to be %v but was %v (handled state %v)", tc.unhandled, event.Unhandled, event.handledState.Unhandled)
+			}
+			if event.handledState.SeverityReason != tc.reason {
+				st.Errorf("Expected severity reason '%s' but was '%s'", tc.reason, event.handledState.SeverityReason)
+			}
+			bytes, _ := (&payload{event, config}).MarshalJSON()
+			if got := string(bytes); strings.Contains(got, "unhandledOverridden") {
+				st.Errorf("Expected an explicit Unhandled not to be reported as overridden but was '%s'", got)
+			}
+		})
+	}
+}
+
+func BenchmarkNewEvent(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		newEvent([]interface{}{fmt.Errorf("cache miss")}, &defaultNotifier)
+	}
+}
+
+func BenchmarkNewError(b *testing.B) {
+	for _, disable := range []bool{false, true} {
+		b.Run(fmt.Sprintf("DisableStacktraces=%v", disable), func(b *testing.B) {
+			config := &Configuration{DisableStacktraces: disable}
+			for i := 0; i < b.N; i++ {
+				err := newError(fmt.Errorf("cache miss"), 0, config)
+				newEvent([]interface{}{err, *config}, &defaultNotifier)
+			}
+		})
+	}
+}
+
+func TestMaxMessageBytes(t *testing.T) {
+	// "é" is two bytes, so the cap falls in the middle of a character
+	message := strings.Repeat("é", 20)
+	notifier := New(Configuration{MaxMessageBytes: 25})
+
+	event, _ := newEvent([]interface{}{fmt.Errorf("%s", message)}, notifier)
+	exp := strings.Repeat("é", 5) + "…[truncated]"
+	if event.Message != exp {
+		t.Errorf("Expected the message to be truncated to '%s' but was '%s'", exp, event.Message)
+	}
+	if len(event.Message) > 25 {
+		t.Errorf("Expected the message to be at most 25 bytes but was %d", len(event.Message))
+	}
+	if _, ok := event.MetaData["error"]; ok {
+		t.Errorf("Expected the full message to only be kept when PreserveFullMessage is set")
+	}
+
+	event, _ = newEvent([]interface{}{fmt.Errorf("%s", message), Configuration{PreserveFullMessage: true}}, notifier)
+	if got := event.MetaData["error"]["fullMessage"]; got != message {
+		t.Errorf("Expected the full message to be preserved but was '%v'", got)
+	}
+
+	event, _ = newEvent([]interface{}{fmt.Errorf("short")}, notifier)
+	if event.Message != "short" {
+		t.Errorf("Expected a short message to be unchanged but was '%s'", event.Message)
+	}
+}
+
+func TestNewHandledStateDefaults(t *testing.T) {
+	if got, exp := NewHandledState("", severity{}, true, "gin"), (HandledState{SeverityReasonUnhandledError, SeverityError, true, "gin"}); got != exp {
+		t.Errorf("Expected an unhandled state to default to '%v' but was '%v'", exp, got)
+	}
+	if got, exp := NewHandledState("", severity{}, false, ""), (HandledState{SeverityReasonHandledError, SeverityWarning, false, ""}); got != exp {
+		t.Errorf("Expected a handled state to default to '%v' but was '%v'", exp, got)
+	}
+}
+
+func TestSetGroupingComponents(t *testing.T) {
+	hash := func(class string, components ...string) string {
+		event := &Event{ErrorClass: class, GroupingHash: "ignored"}
+		event.SetGroupingComponents(components...)
+		return (&payload{event, &Configuration{}}).eventJSON(true).GroupingHash
+	}
+
+	if got, exp := hash("*net.OpError", "billing"), "*net.OpError|billing"; got != exp {
+		t.Errorf("Expected the grouping hash to be '%s' but was '%s'", exp, got)
+	}
+	if hash("*net.OpError", "billing", "eu") != hash("*net.OpError", "billing", "eu") {
+		t.Errorf("Expected the same components in the same order to give the same grouping hash")
+	}
+	for _, other := range []string{
+		hash("*net.OpError", "eu", "billing"),
+		hash("*net.OpError", "billing|eu"),
+		hash("*url.Error", "billing", "eu"),
+	} {
+		if other == hash("*net.OpError", "billing", "eu") {
+			t.Errorf("Expected different components or classes to give a different grouping hash but got '%s'", other)
+		}
+	}
+
+	event := &Event{ErrorClass: "*net.OpError", GroupingHash: "custom"}
+	event.SetGroupingComponents("billing")
+	event.SetGroupingComponents()
+	if got := event.groupingHash(); got != "custom" {
+		t.Errorf("Expected clearing the components to go back to the GroupingHash but got '%s'", got)
+	}
+}
@@ -0,0 +1,119 @@
+package bugsnag
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// execErrorClass is the error class of events for errors created by
+// ExecError.
+const execErrorClass = "ExecError"
+
+// commandTab is the metadata tab which the details of a failed command are
+// sent in.
+const commandTab = "command"
+
+// execError is the failure of a command run with os/exec.
+type execError struct {
+	path     string
+	args     []string
+	dir      string
+	exitCode int
+	stderr   string
+	err      error
+}
+
+// ExecError wraps the error returned by running cmd so that, when it is
+// notified, the command line, exit code and standard error of the command
+// are sent in the "command" tab of the event. Arguments which look like
+// ParamsFilters, such as "--password=x" or the value following "--token",
+// are filtered from the command line, and stderr is cut short at
+// MaxMessageBytes. When stderr is nil the output captured by Cmd.Output is
+// used, if any. ExecError returns nil if err is nil.
+func ExecError(cmd *exec.Cmd, err error, stderr []byte) error {
+	if err == nil {
+		return nil
+	}
+	e := &execError{exitCode: -1, err: err}
+	if cmd != nil {
+		e.path = cmd.Path
+		e.args = append([]string(nil), cmd.Args...)
+		e.dir = cmd.Dir
+		if cmd.ProcessState != nil {
+			e.exitCode = cmd.ProcessState.ExitCode()
+		}
+	}
+	for wrapped := err; wrapped != nil; wrapped = unwrapError(wrapped) {
+		if exitErr, ok := wrapped.(*exec.ExitError); ok {
+			e.exitCode = exitErr.ExitCode()
+			if stderr == nil {
+				stderr = exitErr.Stderr
+			}
+			break
+		}
+	}
+	e.stderr = string(stderr)
+	return e
+}
+
+func (e *execError) Error() string {
+	if name := e.name(); name != "" {
+		return fmt.Sprintf("%s: %v", name, e.err)
+	}
+	return e.err.Error()
+}
+
+func (e *execError) Unwrap() error {
+	return e.err
+}
+
+// name is the base name of the program which was run, as the arguments may
+// contain values which should not appear in the error message.
+func (e *execError) name() string {
+	if len(e.args) > 0 {
+		return filepath.Base(e.args[0])
+	}
+	if e.path != "" {
+		return filepath.Base(e.path)
+	}
+	return ""
+}
+
+// commandLine joins the arguments of the command, replacing the values of
+// those which match filters with "[FILTERED]".
+func (e *execError) commandLine(filters []string) string {
+	s := sanitizer{Filters: filters}
+	args := make([]string, len(e.args))
+	filterNext := false
+	for i, arg := range e.args {
+		switch {
+		case filterNext:
+			args[i] = "[FILTERED]"
+			filterNext = false
+		case i > 0 && strings.Contains(arg, "="):
+			key := arg[:strings.Index(arg, "=")]
+			if s.shouldRedact(strings.TrimLeft(key, "-")) {
+				args[i] = key + "=[FILTERED]"
+			} else {
+				args[i] = arg
+			}
+		default:
+			args[i] = arg
+			filterNext = i > 0 && strings.HasPrefix(arg, "-") && s.shouldRedact(strings.TrimLeft(arg, "-"))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// findExecError returns the execError which err is or wraps.
+func findExecError(err error) (*execError, bool) {
+	for err != nil {
+		if e, ok := err.(*execError); ok {
+			return e, true
+		}
+		err = unwrapError(err)
+	}
+	return nil, false
+}
@@ -0,0 +1,58 @@
+package bugsnag
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExecError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	if ExecError(nil, nil, nil) != nil {
+		t.Errorf("Expected no error for a command which succeeded")
+	}
+
+	cmd := exec.Command("sh", "-c", "echo oops >&2; exit 3", "sh", "--password=hunter2", "--token", "abc123", "--verbose")
+	_, runErr := cmd.Output()
+	if runErr == nil {
+		t.Fatal("Expected the command to fail")
+	}
+	err := ExecError(cmd, runErr, nil)
+	if err.Error() != "sh: exit status 3" {
+		t.Errorf("Expected the message to name only the program but was '%s'", err.Error())
+	}
+
+	notifier := New(Configuration{APIKey: testAPIKey, MaxMessageBytes: 30, ParamsFilters: []string{"password", "token"}})
+	event, config := newEvent([]interface{}{err}, notifier)
+	if err := execMiddleware(event, config); err != nil {
+		t.Fatal(err)
+	}
+	if event.ErrorClass != execErrorClass {
+		t.Errorf("Expected the error class to be '%s' but was '%s'", execErrorClass, event.ErrorClass)
+	}
+	tab := event.MetaData[commandTab]
+	if got := tab["exitCode"]; got != 3 {
+		t.Errorf("Expected the exit code to be 3 but was '%v'", got)
+	}
+	if got := tab["stderr"]; got != "oops\n" {
+		t.Errorf("Expected the stderr of the command but was '%v'", got)
+	}
+	command, _ := tab["command"].(string)
+	if strings.Contains(command, "hunter2") || strings.Contains(command, "abc123") {
+		t.Errorf("Expected sensitive arguments to be filtered but the command was '%s'", command)
+	}
+	if !strings.Contains(command, "--password=[FILTERED] --token [FILTERED] --verbose") {
+		t.Errorf("Expected the filtered command line but was '%s'", command)
+	}
+
+	long := ExecError(cmd, runErr, []byte(strings.Repeat("x", 100)))
+	event, config = newEvent([]interface{}{long}, notifier)
+	execMiddleware(event, config)
+	stderr, _ := event.MetaData[commandTab]["stderr"].(string)
+	if len(stderr) > 30 || !strings.HasSuffix(stderr, truncatedMessageSuffix) {
+		t.Errorf("Expected stderr to be truncated to MaxMessageBytes but was '%s'", stderr)
+	}
+}
@@ -0,0 +1,88 @@
+package bugsnag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// dataFields hold the application's own data, such as meta-data tabs, rather
+// than fields of the report, so their keys aren't renamed by the
+// FieldNameMapper.
+var dataFields = map[string]bool{
+	"metaData":   true,
+	"headers":    true,
+	"attributes": true,
+}
+
+// renameFields rewrites the field names of a marshalled report with the
+// mapper, keeping the fields in the same order.
+func renameFields(buf []byte, mapper func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var out bytes.Buffer
+	if err := copyRenamed(dec, &out, mapper, true); err != nil {
+		return nil, fmt.Errorf("bugsnag/payload.deliver: unable to rename fields: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// copyRenamed copies the next value from dec to out, renaming the keys of any
+// objects within it if rename is set.
+func copyRenamed(dec *json.Decoder, out *bytes.Buffer, mapper func(string) string, rename bool) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		out.WriteByte('{')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			token, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := token.(string)
+			name := key
+			if rename {
+				name = mapper(key)
+			}
+			if err := writeToken(out, name); err != nil {
+				return err
+			}
+			out.WriteByte(':')
+			if err := copyRenamed(dec, out, mapper, rename && !dataFields[key]); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	case json.Delim('['):
+		out.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := copyRenamed(dec, out, mapper, rename); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	default:
+		return writeToken(out, token)
+	}
+	// The closing delimiter
+	_, err = dec.Token()
+	return err
+}
+
+func writeToken(out *bytes.Buffer, token json.Token) error {
+	buf, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	out.Write(buf)
+	return nil
+}
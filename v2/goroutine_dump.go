@@ -0,0 +1,51 @@
+package bugsnag
+
+import (
+	"runtime"
+	"strings"
+)
+
+// MaxGoroutineDumpBytes is the largest dump WithGoroutineDump captures.
+// Dumps of programs with many goroutines are cut short at this size.
+var MaxGoroutineDumpBytes = 256 * 1024
+
+// goroutinesTab is the tab the stacks of a GoroutineDump are added to.
+const goroutinesTab = "goroutines"
+
+// GoroutineDump is the stacks of every goroutine at a moment, in the format
+// printed when a Go program receives SIGQUIT. This can be passed to Notify as
+// rawData, which adds the stack of each goroutine to the "goroutines" tab,
+// keyed by the goroutine's ID.
+type GoroutineDump struct {
+	// Stacks is the dump, as written by runtime.Stack
+	Stacks string
+	// Truncated is set if the dump was cut short at MaxGoroutineDumpBytes
+	Truncated bool
+}
+
+// WithGoroutineDump captures the stacks of every goroutine, to be passed to
+// Notify as rawData. This is for diagnosing hangs which never panic, where
+// the stack of the stuck goroutine matters rather than the stack of the
+// code which noticed it, e.g. in a watchdog:
+//
+//	bugsnag.Notify(fmt.Errorf("job %s has been running for an hour", id), bugsnag.WithGoroutineDump())
+func WithGoroutineDump() GoroutineDump {
+	buf := make([]byte, MaxGoroutineDumpBytes)
+	n := runtime.Stack(buf, true)
+	return GoroutineDump{Stacks: string(buf[:n]), Truncated: n == len(buf)}
+}
+
+// goroutines splits the dump into the stack of each goroutine, keyed by the
+// goroutine's ID.
+func (dump GoroutineDump) goroutines() map[string]string {
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(strings.TrimSpace(dump.Stacks), "\n\n") {
+		// Each stack starts with a header like "goroutine 1 [running]:"
+		fields := strings.Fields(stack)
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		stacks[fields[1]] = stack
+	}
+	return stacks
+}
@@ -0,0 +1,135 @@
+// Package bugsnaglambda reports errors and panics from AWS Lambda functions.
+//
+// The Lambda runtime freezes the process as soon as a handler returns, so
+// events delivered in the background and sessions waiting for the next
+// publish interval would otherwise never reach Bugsnag. Wrap delivers
+// everything before each invocation returns.
+//
+// The package is imported from the lambda directory but named bugsnaglambda,
+// so that it doesn't clash with the AWS Lambda packages:
+//
+//	import bugsnaglambda "github.com/bugsnag/bugsnag-go/v2/lambda"
+package bugsnaglambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bugsnag/bugsnag-go/v2"
+	"github.com/bugsnag/bugsnag-go/v2/errors"
+)
+
+// FrameworkName is the name of the framework this wrapper applies to
+const FrameworkName string = "AWS Lambda"
+
+// Handler is a Lambda handler which receives the raw JSON event of each
+// invocation, as accepted by lambda.Start from github.com/aws/aws-lambda-go.
+type Handler func(ctx context.Context, event json.RawMessage) (interface{}, error)
+
+// RequestIDFromContext returns the request ID of the invocation with the
+// given context, which is added to the "lambda" tab of each event. The
+// aws-lambda-go runtime stores the ID in the context where only its
+// lambdacontext package can read it, so set this to use that package:
+//
+//	bugsnaglambda.RequestIDFromContext = func(ctx context.Context) string {
+//		if lc, ok := lambdacontext.FromContext(ctx); ok {
+//			return lc.AwsRequestID
+//		}
+//		return ""
+//	}
+var RequestIDFromContext = func(ctx context.Context) string { return "" }
+
+var middlewareOnce sync.Once
+
+type invocationKey struct{}
+
+// invocation identifies the Lambda invocation which an event happened in.
+type invocation struct {
+	requestID       string
+	functionName    string
+	functionVersion string
+}
+
+// Wrap returns a Handler which reports panics in handler to Bugsnag and
+// returns them as an error, so the invocation fails rather than the process
+// crashing. A session is started for each invocation, unless
+// AutoCaptureSessions is disabled when Wrap is called, and the context passed
+// to handler should be passed to Notify so that errors are attributed to it.
+//
+// As the process may be frozen between invocations, Wrap makes the delivery
+// of all events synchronous, and before each invocation returns it flushes
+// any batched events and the sessions which have been started. The rawData
+// is used to send extra information along with any panics, and may include a
+// bugsnag.Configuration which is applied to the global configuration.
+func Wrap(handler Handler, rawData ...interface{}) Handler {
+	rawData = updateGlobalConfig(rawData)
+	var autoCaptureSessions bool
+	bugsnag.Reconfigure(func(config *bugsnag.Configuration) {
+		config.Synchronous = true
+		autoCaptureSessions = config.IsAutoCaptureSessions()
+	})
+	middlewareOnce.Do(func() { bugsnag.OnBeforeNotify(lambdaMiddleware) })
+	state := bugsnag.HandledState{
+		SeverityReason:   bugsnag.SeverityReasonUnhandledPanic,
+		OriginalSeverity: bugsnag.SeverityError,
+		Unhandled:        true,
+		Framework:        FrameworkName,
+	}
+	notifier := bugsnag.New(append(rawData, state)...)
+
+	return func(ctx context.Context, event json.RawMessage) (response interface{}, err error) {
+		ctx = context.WithValue(ctx, invocationKey{}, invocation{
+			requestID:       RequestIDFromContext(ctx),
+			functionName:    os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
+			functionVersion: os.Getenv("AWS_LAMBDA_FUNCTION_VERSION"),
+		})
+		if autoCaptureSessions {
+			ctx = bugsnag.StartSession(ctx)
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				// Strip the runtime frames of the panic itself, as
+				// Notifier.Recover does
+				notifier.NotifySync(errors.NewPanic(p, 2), true, ctx)
+				response, err = nil, fmt.Errorf("bugsnag/lambda.Wrap: handler panicked: %v", p)
+			}
+			bugsnag.Flush()
+			bugsnag.FlushSessions()
+		}()
+		return handler(ctx, event)
+	}
+}
+
+// lambdaMiddleware adds the invocation an event happened in to the "lambda"
+// tab, when the event has the context passed to a wrapped handler.
+func lambdaMiddleware(event *bugsnag.Event, config *bugsnag.Configuration) error {
+	ctx, ok := event.GetContext()
+	if !ok {
+		return nil
+	}
+	if inv, ok := ctx.Value(invocationKey{}).(invocation); ok {
+		if inv.requestID != "" {
+			event.MetaData.Add("lambda", "requestId", inv.requestID)
+		}
+		event.MetaData.Add("lambda", "functionName", inv.functionName)
+		event.MetaData.Add("lambda", "functionVersion", inv.functionVersion)
+	}
+	return nil
+}
+
+// updateGlobalConfig applies any Configuration in the rawData to the global
+// configuration, and returns a copy of the rawData without it.
+func updateGlobalConfig(rawData []interface{}) []interface{} {
+	filtered := make([]interface{}, 0, len(rawData))
+	for _, datum := range rawData {
+		if c, ok := datum.(bugsnag.Configuration); ok {
+			bugsnag.Configure(c)
+			continue
+		}
+		filtered = append(filtered, datum)
+	}
+	return filtered
+}
@@ -0,0 +1,31 @@
+package bugsnaglambda_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/bugsnag/bugsnag-go/v2"
+	bugsnaglambda "github.com/bugsnag/bugsnag-go/v2/lambda"
+)
+
+type order struct {
+	ID string `json:"id"`
+}
+
+func ExampleWrap() {
+	handler := bugsnaglambda.Wrap(func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		var o order
+		if err := json.Unmarshal(event, &o); err != nil {
+			// Pass ctx so the error is attributed to this invocation's
+			// session and includes its request ID
+			bugsnag.Notify(err, ctx)
+			return nil, err
+		}
+		return map[string]string{"status": "accepted", "id": o.ID}, nil
+	}, bugsnag.Configuration{APIKey: "YOUR_API_KEY_HERE"})
+
+	// Pass the wrapped handler to the runtime, e.g. using aws-lambda-go:
+	//
+	//	lambda.Start(handler)
+	_ = handler
+}
@@ -0,0 +1,127 @@
+package bugsnaglambda_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	simplejson "github.com/bitly/go-simplejson"
+	"github.com/bugsnag/bugsnag-go/v2"
+	bugsnaglambda "github.com/bugsnag/bugsnag-go/v2/lambda"
+	. "github.com/bugsnag/bugsnag-go/v2/testutil"
+)
+
+type requestIDKey struct{}
+
+// recordingServer records the events and session payloads it receives.
+type recordingServer struct {
+	mutex    sync.Mutex
+	events   []*simplejson.Json
+	sessions []*simplejson.Json
+}
+
+func (s *recordingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	payload, err := simplejson.NewJson(body)
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if strings.Contains(r.URL.Path, "sessions") {
+		s.sessions = append(s.sessions, payload)
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		s.events = append(s.events, payload)
+	}
+}
+
+func (s *recordingServer) received() (events, sessions []*simplejson.Json) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.events, s.sessions
+}
+
+func TestWrap(t *testing.T) {
+	server := &recordingServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "checkout")
+	defer os.Unsetenv("AWS_LAMBDA_FUNCTION_NAME")
+	defer func(f func(context.Context) string) { bugsnaglambda.RequestIDFromContext = f }(bugsnaglambda.RequestIDFromContext)
+	bugsnaglambda.RequestIDFromContext = func(ctx context.Context) string {
+		id, _ := ctx.Value(requestIDKey{}).(string)
+		return id
+	}
+
+	handler := bugsnaglambda.Wrap(func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		if string(event) == `"panic"` {
+			panic("something went terribly wrong")
+		}
+		return "ok", nil
+	}, bugsnag.Configuration{
+		APIKey:       TestAPIKey,
+		Endpoints:    bugsnag.Endpoints{Notify: ts.URL, Sessions: ts.URL + "/sessions"},
+		PanicHandler: func() {},
+	})
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "c6af9ac6-7b61-11e6-9a41-93e8deadbeef")
+	response, err := handler(ctx, json.RawMessage(`"panic"`))
+	if err == nil || response != nil {
+		t.Fatalf("Expected the panic to be returned as an error but got '%v', '%v'", response, err)
+	}
+
+	// Everything must have been delivered by the time the handler returned,
+	// as the process may then be frozen
+	events, sessions := server.received()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event to be delivered but %d were", len(events))
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session payload to be delivered but %d were", len(sessions))
+	}
+	event := GetIndex(events[0], "events", 0)
+	for path, exp := range map[string]string{
+		"severityReason.type":          string(bugsnag.SeverityReasonUnhandledPanic),
+		"metaData.lambda.requestId":    "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+		"metaData.lambda.functionName": "checkout",
+	} {
+		if got := Get(event, path).MustString(); got != exp {
+			t.Errorf("Expected '%s' to be '%s' but was '%s'", path, exp, got)
+		}
+	}
+	if !event.Get("unhandled").MustBool() {
+		t.Errorf("Expected the panic to be reported as unhandled")
+	}
+	if got := GetIndex(sessions[0], "sessionCounts", 0).Get("sessionsStarted").MustInt(); got != 1 {
+		t.Errorf("Expected 1 session to be started but there were %d", got)
+	}
+
+	response, err = handler(ctx, json.RawMessage(`"hello"`))
+	if err != nil || response != "ok" {
+		t.Errorf("Expected the handler's response to be returned but got '%v', '%v'", response, err)
+	}
+	if events, sessions := server.received(); len(events) != 1 || len(sessions) != 2 {
+		t.Errorf("Expected only the session of the second invocation to be delivered but got %d events and %d session payloads", len(events), len(sessions))
+	}
+}
+
+func TestWrapDoesNotChangeRawData(t *testing.T) {
+	rawData := []interface{}{
+		bugsnag.Configuration{APIKey: TestAPIKey, PanicHandler: func() {}},
+		bugsnag.MetaData{"lambda": {"team": "checkout"}},
+	}
+	bugsnaglambda.Wrap(func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		return nil, nil
+	}, rawData...)
+	if _, ok := rawData[0].(bugsnag.Configuration); !ok {
+		t.Errorf("Expected the caller's rawData to be left unchanged but it was %v", rawData)
+	}
+}
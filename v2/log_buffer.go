@@ -0,0 +1,98 @@
+package bugsnag
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultLogBufferSize is the number of log lines kept by WithLogBuffer when
+// no size is given.
+const DefaultLogBufferSize = 50
+
+// logsTab is the metadata tab which log lines are sent in.
+const logsTab = "logs"
+
+// logBuffer keeps the most recent log lines in a fixed size ring, so that
+// long-lived contexts don't accumulate logs without bound.
+type logBuffer struct {
+	mutex sync.Mutex
+	lines []logLine
+	next  int
+	full  bool
+}
+
+type logLine struct {
+	Timestamp time.Time `json:"timestamp"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+}
+
+// WithLogBuffer returns a child of the given context which keeps the most
+// recent size lines passed to AppendLog with it, or any context derived from
+// it. The lines are included in the "logs" tab of any event notified with the
+// context. If size is not positive, DefaultLogBufferSize is used.
+func WithLogBuffer(ctx context.Context, size int) context.Context {
+	if size <= 0 {
+		size = DefaultLogBufferSize
+	}
+	return context.WithValue(ctx, logBufferContextKey, &logBuffer{lines: make([]logLine, size)})
+}
+
+// AppendLog records a log line in the buffer attached to the context with
+// WithLogBuffer, dropping the oldest line if the buffer is full. This is a
+// no-op if the context has no buffer attached.
+func AppendLog(ctx context.Context, level, message string) {
+	if buffer := logBufferFromContext(ctx); buffer != nil {
+		buffer.append(logLine{Timestamp: Config.currentTime(), Level: level, Message: message})
+	}
+}
+
+func logBufferFromContext(ctx context.Context) *logBuffer {
+	if ctx == nil {
+		return nil
+	}
+	if buffer, ok := ctx.Value(logBufferContextKey).(*logBuffer); ok {
+		return buffer
+	}
+	return nil
+}
+
+func (buffer *logBuffer) append(line logLine) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	buffer.lines[buffer.next] = line
+	buffer.next = (buffer.next + 1) % len(buffer.lines)
+	if buffer.next == 0 {
+		buffer.full = true
+	}
+}
+
+// snapshot returns the buffered lines, oldest first.
+func (buffer *logBuffer) snapshot() []logLine {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if !buffer.full {
+		return append([]logLine(nil), buffer.lines[:buffer.next]...)
+	}
+	lines := make([]logLine, 0, len(buffer.lines))
+	lines = append(lines, buffer.lines[buffer.next:]...)
+	return append(lines, buffer.lines[:buffer.next]...)
+}
+
+// logBufferMiddleware is added OnBeforeNotify by default. It adds the lines
+// from the log buffer of a context.Context passed in as rawData to the
+// "logs" tab of the Event.
+func logBufferMiddleware(event *Event, config *Configuration) error {
+	for _, datum := range event.RawData {
+		if ctx, ok := datum.(context.Context); ok && ctx != nil {
+			if buffer := logBufferFromContext(ctx); buffer != nil {
+				if lines := buffer.snapshot(); len(lines) > 0 {
+					event.MetaData.Add(logsTab, "lines", lines)
+				}
+				return nil
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package bugsnag
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLogBufferDropsOldestLines(t *testing.T) {
+	ctx := WithLogBuffer(context.Background(), 3)
+	for i := 1; i <= 5; i++ {
+		AppendLog(ctx, "info", fmt.Sprintf("line %d", i))
+	}
+
+	var got []string
+	for _, line := range logBufferFromContext(ctx).snapshot() {
+		got = append(got, line.Message)
+	}
+	if exp := []string{"line 3", "line 4", "line 5"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected buffered lines to be '%v' but were '%v'", exp, got)
+	}
+}
+
+func TestAppendLogWithoutBuffer(t *testing.T) {
+	AppendLog(context.Background(), "info", "dropped")
+	AppendLog(nil, "info", "dropped")
+}
+
+func TestLogBufferMiddleware(t *testing.T) {
+	ctx := WithLogBuffer(context.Background(), 0)
+	AppendLog(ctx, "warn", "disk nearly full")
+	event, config := newEvent([]interface{}{fmt.Errorf("oops"), ctx}, &defaultNotifier)
+
+	if err := logBufferMiddleware(event, config); err != nil {
+		t.Fatal(err)
+	}
+	lines, ok := event.MetaData[logsTab]["lines"].([]logLine)
+	if !ok || len(lines) != 1 {
+		t.Fatalf("Expected one log line in the logs tab but got '%+v'", event.MetaData[logsTab])
+	}
+	if lines[0].Level != "warn" || lines[0].Message != "disk nearly full" {
+		t.Errorf("Unexpected log line '%+v'", lines[0])
+	}
+}
@@ -1,10 +1,13 @@
 package bugsnag
 
 import (
+	"bytes"
 	"encoding"
 	"encoding/json"
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 )
@@ -30,6 +33,15 @@ func (meta MetaData) Update(other MetaData) {
 	}
 }
 
+// withDefaults returns a copy of the meta-data merged with the given defaults.
+// Tabs are merged together such that keys from the receiver take precedence.
+func (meta MetaData) withDefaults(defaults MetaData) MetaData {
+	merged := make(MetaData, len(meta)+len(defaults))
+	merged.Update(defaults)
+	merged.Update(meta)
+	return merged
+}
+
 // Add creates a tab of Bugsnag meta-data.
 // If the tab doesn't yet exist it will be created.
 // If the key already exists, it will be overwritten.
@@ -58,20 +70,105 @@ func (meta MetaData) AddStruct(tab string, obj interface{}) {
 
 }
 
+// MetaDataSnapshot is a tab of meta-data copied by Snapshot. This can be
+// passed to Notify, Recover or AutoNotify as rawData.
+type MetaDataSnapshot struct {
+	tab  string
+	data map[string]interface{}
+}
+
+// Snapshot copies the data, including any maps, slices and structs nested
+// within it, so that it can be reported in the given tab of an error which
+// happens later, with the values it has now rather than after it has been
+// changed. Values matching the global ParamsFilters are filtered out as the
+// copy is made, so secrets aren't kept in the snapshot.
+//
+//	snapshot := bugsnag.Snapshot("order", order.State())
+//	...
+//	bugsnag.Notify(err, snapshot)
+func Snapshot(tab string, data map[string]interface{}) MetaDataSnapshot {
+	config := cloneConfig(&Config)
+	s := sanitizer{Filters: config.ParamsFilters, MaxDepth: config.maxMetaDataDepth()}
+	copied, _ := s.Sanitize(data).(map[string]interface{})
+	return MetaDataSnapshot{tab: tab, data: copied}
+}
+
+// orderedTabs serializes sanitized meta-data with the named tabs first, in
+// the given order, followed by any other tabs in alphabetical order. Keys
+// within tabs are always serialized in alphabetical order.
+type orderedTabs struct {
+	tabs  map[string]interface{}
+	order []string
+}
+
+func (o orderedTabs) MarshalJSON() ([]byte, error) {
+	names := make([]string, 0, len(o.tabs))
+	seen := make(map[string]bool, len(o.order))
+	for _, name := range o.order {
+		if _, ok := o.tabs[name]; ok && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	rest := make([]string, 0, len(o.tabs)-len(names))
+	for name := range o.tabs {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	names = append(names, rest...)
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(o.tabs[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
 // Remove any values from meta-data that have keys matching the filters,
 // and any that are recursive data-structures
 func (meta MetaData) sanitize(filters []string) interface{} {
+	return meta.sanitizeToDepth(filters, 0)
+}
+
+// sanitizeToDepth sanitizes the meta-data in the same way as sanitize, and
+// also replaces any maps, slices or structs nested more than maxDepth levels
+// deep within a tab. There is no limit if maxDepth is 0.
+func (meta MetaData) sanitizeToDepth(filters []string, maxDepth int) interface{} {
 	return sanitizer{
-		Filters: filters,
-		Seen:    make([]interface{}, 0),
+		Filters:  filters,
+		Seen:     make([]interface{}, 0),
+		MaxDepth: maxDepth,
+		// The meta-data and its tabs are not counted towards the depth
+		depth: -2,
 	}.Sanitize(meta)
-
 }
 
+// maxDepthMarker replaces values nested too deeply within meta-data.
+const maxDepthMarker = "[MAX DEPTH REACHED]"
+
 // sanitizer is used to remove filtered params and recursion from meta-data.
 type sanitizer struct {
-	Filters []string
-	Seen    []interface{}
+	Filters  []string
+	Seen     []interface{}
+	MaxDepth int
+	depth    int
 }
 
 func (s sanitizer) Sanitize(data interface{}) interface{} {
@@ -128,8 +225,15 @@ func (s sanitizer) Sanitize(data interface{}) interface{} {
 	switch t.Kind() {
 	case reflect.Bool,
 		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
-		reflect.Float32, reflect.Float64:
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return data
+
+	case reflect.Float32, reflect.Float64:
+		// JSON can't represent NaN or infinity, and including them would
+		// cause the whole payload to fail to serialize
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Sprintf("%v", f)
+		}
 		return data
 
 	case reflect.String:
@@ -138,6 +242,13 @@ func (s sanitizer) Sanitize(data interface{}) interface{} {
 	case reflect.Interface, reflect.Ptr:
 		return s.Sanitize(v.Elem().Interface())
 
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.Struct:
+		if s.depth++; s.MaxDepth > 0 && s.depth > s.MaxDepth {
+			return maxDepthMarker
+		}
+	}
+
+	switch t.Kind() {
 	case reflect.Array, reflect.Slice:
 		ret := make([]interface{}, v.Len())
 		for i := 0; i < v.Len(); i++ {
@@ -1,9 +1,12 @@
 package bugsnag
 
 import (
+	"context"
 	"encoding/json"
 	stderrors "errors"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 	"unsafe"
@@ -330,6 +333,38 @@ func TestMetaDataSanitize(t *testing.T) {
 
 }
 
+type _failingJSONMarshaller struct {
+	Data string
+}
+
+func (_failingJSONMarshaller) MarshalJSON() ([]byte, error) {
+	return nil, stderrors.New("cannot marshal")
+}
+
+func TestMarshalPayloadWithUnserializableMetaData(t *testing.T) {
+	event := &Event{
+		Ctx: context.Background(),
+		MetaData: MetaData{
+			"one": {
+				"chan":    make(chan int),
+				"func":    func() {},
+				"nan":     math.NaN(),
+				"inf":     []float64{math.Inf(1), math.Inf(-1)},
+				"failing": _failingJSONMarshaller{Data: "ohai"},
+			},
+		},
+	}
+
+	bytes, err := (&payload{event, &Configuration{}}).MarshalJSON()
+	if err != nil {
+		t.Fatalf("Expected payload with unserializable meta-data to marshal but got %v", err)
+	}
+	exp := `"metaData":{"one":{"chan":"[chan int]","failing":{"Data":"ohai"},"func":"[func()]","inf":["+Inf","-Inf"],"nan":"NaN"}}`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+}
+
 func TestSanitizerSanitize(t *testing.T) {
 	var (
 		nilPointer   *int
@@ -360,3 +395,67 @@ func ExampleMetaData() {
 			"paying?": account.Plan.Premium,
 		}})
 }
+
+func TestMetaDataSanitizeToDepth(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	cycle := &node{Name: "a"}
+	cycle.Next = &node{Name: "b", Next: cycle}
+
+	m := MetaData{
+		"one": {
+			"flat":   1,
+			"nested": map[string]interface{}{"list": []interface{}{map[string]interface{}{"deep": true}}},
+			"cycle":  cycle,
+		},
+	}
+
+	n := m.sanitizeToDepth(nil, 1)
+	exp := map[string]interface{}{
+		"one": map[string]interface{}{
+			"flat":   1,
+			"nested": map[string]interface{}{"list": "[MAX DEPTH REACHED]"},
+			"cycle": map[string]interface{}{
+				"Name": "a",
+				"Next": "[MAX DEPTH REACHED]",
+			},
+		},
+	}
+	if !reflect.DeepEqual(n, exp) {
+		t.Errorf("Expected sanitized meta-data to be '%#v' but was '%#v'", exp, n)
+	}
+
+	n = m.sanitizeToDepth(nil, 10)
+	got := n.(map[string]interface{})["one"].(map[string]interface{})["cycle"].(map[string]interface{})["Next"]
+	if exp := (map[string]interface{}{"Name": "b", "Next": "[RECURSION]"}); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected cycle to be detected as '%#v' but was '%#v'", exp, got)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	defer func(filters []string) { Config.ParamsFilters = filters }(Config.ParamsFilters)
+	Config.ParamsFilters = []string{"password"}
+
+	items := []interface{}{"apple"}
+	address := map[string]interface{}{"city": "London"}
+	state := map[string]interface{}{"items": items, "address": address, "total": 3, "password": "hunter2"}
+	snapshot := Snapshot("order", state)
+
+	items[0] = "banana"
+	address["city"] = "Paris"
+	state["total"] = 4
+	state["coupon"] = "SAVE10"
+
+	event, _ := newEvent([]interface{}{errors.New("oops", 0), snapshot}, &defaultNotifier)
+	exp := map[string]interface{}{
+		"items":    []interface{}{"apple"},
+		"address":  map[string]interface{}{"city": "London"},
+		"total":    3,
+		"password": "[FILTERED]",
+	}
+	if got := event.MetaData["order"]; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected the snapshot to be unchanged as '%v' but got '%v'", exp, got)
+	}
+}
@@ -0,0 +1,84 @@
+package bugsnag
+
+import (
+	"time"
+)
+
+// MetricsObserver is told about the outcome of each notification, so that the
+// health of error reporting can be monitored, e.g. by exporting the counts
+// and latencies to Prometheus or statsd. Set it using
+// Configuration.MetricsObserver. The methods may be called concurrently.
+type MetricsObserver interface {
+	// Notified is called for every error passed to Notify, before deciding
+	// whether to send it.
+	Notified()
+	// Dropped is called for each event which is not sent to Bugsnag, with
+	// the reason it was dropped.
+	Dropped(reason DropReason)
+	// Delivered is called when a request sending events to Bugsnag has
+	// completed, with the number of events in the request, how long it
+	// took, and the error if it failed.
+	Delivered(events int, latency time.Duration, err error)
+}
+
+// DropReason describes why an event was not sent to Bugsnag.
+type DropReason string
+
+const (
+	// DropReasonIgnored means the error matched Configuration.IgnoreErrors.
+	DropReasonIgnored DropReason = "ignored"
+	// DropReasonMiddleware means an OnBeforeNotify callback returned an error.
+	DropReasonMiddleware DropReason = "middleware"
+	// DropReasonReleaseStage means the release stage is not one of the
+	// Configuration.NotifyReleaseStages.
+	DropReasonReleaseStage DropReason = "releaseStage"
+	// DropReasonCircuitOpen means that recent deliveries failed, and events
+	// are not being sent until Bugsnag is reachable again.
+	DropReasonCircuitOpen DropReason = "circuitOpen"
+	// DropReasonNotConfigured means the configuration failed
+	// Configuration.Validate, e.g. because the API key is empty.
+	DropReasonNotConfigured DropReason = "notConfigured"
+	// DropReasonDuplicate means the event was identical to one sent within
+	// the Configuration.DedupWindow, and was counted instead.
+	DropReasonDuplicate DropReason = "duplicate"
+	// DropReasonDeliveryOverflow means Configuration.MaxDeliveryConcurrency
+	// deliveries were already in progress, and the DeliveryOverflow policy
+	// dropped the event.
+	DropReasonDeliveryOverflow DropReason = "deliveryOverflow"
+	// DropReasonSuppressed means the event was notified with a context
+	// created by WithSuppression.
+	DropReasonSuppressed DropReason = "suppressed"
+	// DropReasonSampled means the event wasn't chosen by the
+	// HandledSampleRate or UnhandledSampleRate.
+	DropReasonSampled DropReason = "sampled"
+	// DropReasonSeverity means the handled event was less severe than the
+	// Configuration.MinSeverity.
+	DropReasonSeverity DropReason = "severity"
+	// DropReasonDeliveryFailed means the request sending the event to
+	// Bugsnag failed. This is only passed to Configuration.OnEventDropped,
+	// as a MetricsObserver is told about failed requests by Delivered.
+	DropReasonDeliveryFailed DropReason = "deliveryFailed"
+)
+
+type nopMetricsObserver struct{}
+
+func (nopMetricsObserver) Notified()                                              {}
+func (nopMetricsObserver) Dropped(reason DropReason)                              {}
+func (nopMetricsObserver) Delivered(events int, latency time.Duration, err error) {}
+
+// dropped tells the MetricsObserver and OnEventDropped that the event will
+// not be sent to Bugsnag.
+func (config *Configuration) dropped(event *Event, reason DropReason) {
+	config.metrics().Dropped(reason)
+	if config.OnEventDropped != nil {
+		config.OnEventDropped(event, reason)
+	}
+}
+
+// metrics returns the configured MetricsObserver, or one which does nothing.
+func (config *Configuration) metrics() MetricsObserver {
+	if config.MetricsObserver != nil {
+		return config.MetricsObserver
+	}
+	return nopMetricsObserver{}
+}
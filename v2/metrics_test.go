@@ -0,0 +1,133 @@
+package bugsnag
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetricsObserver struct {
+	mutex     sync.Mutex
+	calls     []string
+	latencies []time.Duration
+}
+
+func (o *recordingMetricsObserver) record(call string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.calls = append(o.calls, call)
+}
+
+func (o *recordingMetricsObserver) Notified() {
+	o.record("notified")
+}
+
+func (o *recordingMetricsObserver) Dropped(reason DropReason) {
+	o.record("dropped " + string(reason))
+}
+
+func (o *recordingMetricsObserver) Delivered(events int, latency time.Duration, err error) {
+	o.record(fmt.Sprintf("delivered %d, failed: %v", events, err != nil))
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.latencies = append(o.latencies, latency)
+}
+
+func TestMetricsObserver(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	abort := fmt.Errorf("abort")
+	testCases := []struct {
+		name   string
+		config Configuration
+		status int
+		exp    []string
+	}{
+		{"delivered", Configuration{}, http.StatusOK, []string{"notified", "delivered 1, failed: false"}},
+		{"failed", Configuration{}, http.StatusInternalServerError, []string{"notified", "delivered 1, failed: true"}},
+		{"ignored", Configuration{IgnoreErrors: []func(error) bool{func(error) bool { return true }}}, http.StatusOK, []string{"notified", "dropped ignored"}},
+		{"release stage", Configuration{NotifyReleaseStages: []string{"production"}}, http.StatusOK, []string{"notified", "dropped releaseStage"}},
+		{"middleware", Configuration{AppType: "abort"}, http.StatusOK, []string{"notified", "dropped middleware"}},
+	}
+
+	handle := AddOnBeforeNotify(func(event *Event, config *Configuration) error {
+		if config.AppType == "abort" {
+			return abort
+		}
+		return nil
+	})
+	defer RemoveOnBeforeNotify(handle)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			status = tc.status
+			observer := &recordingMetricsObserver{}
+			notifier := New(generateSampleConfig(ts.URL), Configuration{MetricsObserver: observer, Synchronous: true, NotifyReleaseStages: []string{"test"}}, tc.config)
+			notifier.Notify(fmt.Errorf("oops"))
+
+			if !reflect.DeepEqual(observer.calls, tc.exp) {
+				st.Errorf("Expected observer calls '%v' but got '%v'", tc.exp, observer.calls)
+			}
+		})
+	}
+}
+
+func TestOnEventDropped(t *testing.T) {
+	resetCircuitBreakers(t)
+	defer func(random func() float64) { sampleRandom = random }(sampleRandom)
+	sampleRandom = func() float64 { return 0.5 }
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	handle := AddOnBeforeNotify(func(event *Event, config *Configuration) error {
+		if event.Context == "filtered" {
+			return fmt.Errorf("filtered")
+		}
+		return nil
+	})
+	defer RemoveOnBeforeNotify(handle)
+
+	var mutex sync.Mutex
+	var dropped []string
+	onDropped := func(event *Event, reason DropReason) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		dropped = append(dropped, event.Context+": "+string(reason))
+	}
+
+	testCases := []struct {
+		name   string
+		config Configuration
+		exp    []string
+	}{
+		{"sampled", Configuration{HandledSampleRate: 0.1}, []string{"sampled: sampled"}},
+		{"filtered", Configuration{}, []string{"filtered: middleware"}},
+		{"delivery failed", Configuration{}, []string{"delivery failed: deliveryFailed"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			dropped = nil
+			notifier := New(generateSampleConfig(ts.URL), Configuration{OnEventDropped: onDropped, Synchronous: true, NotifyReleaseStages: []string{"test"}}, tc.config)
+			notifier.Notify(fmt.Errorf("oops"), Context{String: tc.name})
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !reflect.DeepEqual(dropped, tc.exp) {
+				st.Errorf("Expected OnEventDropped calls '%v' but got '%v'", tc.exp, dropped)
+			}
+		})
+	}
+}
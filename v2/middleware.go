@@ -3,17 +3,48 @@ package bugsnag
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
 )
 
+// ErrAbortNotification can be returned from an OnBeforeNotify callback to
+// prevent the event from being sent to Bugsnag. Any other error has the same
+// effect, but this error makes the intent explicit.
+var ErrAbortNotification = fmt.Errorf("bugsnag: notification aborted by middleware")
+
+// errSuppressed is returned by suppressionMiddleware to drop an event
+// without it being logged as a failure.
+var errSuppressed = fmt.Errorf("bugsnag: notification suppressed by context")
+
 type (
 	beforeFunc func(*Event, *Configuration) error
 
+	// MiddlewareHandle identifies a middleware registered with
+	// AddOnBeforeNotify so that it can later be removed again.
+	MiddlewareHandle struct {
+		id uint64
+	}
+
+	middlewareEntry struct {
+		handle MiddlewareHandle
+		before beforeFunc
+		final  bool
+	}
+
 	// MiddlewareStacks keep middleware in the correct order. They are
 	// called in reverse order, so if you add a new middleware it will
-	// be called before all existing middleware.
+	// be called before all existing middleware. Final middleware are
+	// called after all other middleware, again in reverse order, and
+	// are called even if earlier middleware aborted the notification.
+	// Middleware may be added concurrently with notifications being run.
 	middlewareStack struct {
-		before []beforeFunc
+		mutex  sync.RWMutex
+		before []middlewareEntry
+		lastID uint64
 	}
 )
 
@@ -21,27 +52,110 @@ type (
 // when the middlewareStack is Run it will be run before all middleware that
 // have been added before.
 func (stack *middlewareStack) OnBeforeNotify(middleware beforeFunc) {
-	stack.before = append(stack.before, middleware)
+	stack.add(middleware, false)
+}
+
+// OnBeforeNotifyFinal adds a new middleware which is run after all middleware
+// added with OnBeforeNotify, regardless of whether any of them returned an
+// error.
+func (stack *middlewareStack) OnBeforeNotifyFinal(middleware beforeFunc) {
+	stack.add(middleware, true)
+}
+
+// add registers the middleware in the same way as OnBeforeNotify, or
+// OnBeforeNotifyFinal if final is true, and returns a handle which can be
+// passed to Remove.
+func (stack *middlewareStack) add(middleware beforeFunc, final bool) MiddlewareHandle {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+	stack.lastID++
+	handle := MiddlewareHandle{stack.lastID}
+	stack.before = append(stack.before, middlewareEntry{handle, middleware, final})
+	return handle
+}
+
+// Remove unregisters the middleware identified by the handle. Removing a
+// middleware that has already been removed is a no-op. Notifications that are
+// already running will still call the removed middleware.
+func (stack *middlewareStack) Remove(handle MiddlewareHandle) {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+	for i, entry := range stack.before {
+		if entry.handle == handle {
+			before := make([]middlewareEntry, 0, len(stack.before)-1)
+			before = append(before, stack.before[:i]...)
+			stack.before = append(before, stack.before[i+1:]...)
+			return
+		}
+	}
+}
+
+// Clear unregisters all middleware, including the default HTTP request
+// middleware.
+func (stack *middlewareStack) Clear() {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+	stack.before = nil
+}
+
+// snapshot returns the middleware registered at the time of calling, so that
+// the stack can be run without holding the lock while callbacks execute.
+func (stack *middlewareStack) snapshot() (before []beforeFunc, final []beforeFunc) {
+	stack.mutex.RLock()
+	defer stack.mutex.RUnlock()
+	for _, entry := range stack.before {
+		if entry.final {
+			final = append(final, entry.before)
+		} else {
+			before = append(before, entry.before)
+		}
+	}
+	return before, final
 }
 
 // Run causes all the middleware to be run. If they all permit it the next callback
 // will be called with all the middleware on the stack.
 func (stack *middlewareStack) Run(event *Event, config *Configuration, next func() error) error {
-	// run all the before filters in reverse order
-	for i := range stack.before {
-		before := stack.before[len(stack.before)-i-1]
+	befores, finals := stack.snapshot()
+
+	// run all the before filters in reverse order, stopping at the first error
+	err := stack.runBeforeFilters(befores, event, config, true)
+
+	// run all the final filters in reverse order, even if a before filter
+	// returned an error
+	if finalErr := stack.runBeforeFilters(finals, event, config, false); err == nil {
+		err = finalErr
+	}
+
+	if err != nil {
+		return err
+	}
+	return next()
+}
+
+// runBeforeFilters runs the given filters in reverse order and returns the
+// first error returned by any of them. If abort is true then no further
+// filters are run after an error is returned.
+func (stack *middlewareStack) runBeforeFilters(befores []beforeFunc, event *Event, config *Configuration, abort bool) error {
+	var firstErr error
+	for i := range befores {
+		before := befores[len(befores)-i-1]
 
 		severity := event.Severity
 		err := stack.runBeforeFilter(before, event, config)
-		if err != nil {
-			return err
-		}
 		if event.Severity != severity {
 			event.handledState.SeverityReason = SeverityReasonCallbackSpecified
 		}
+		if err != nil {
+			if abort {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
 	}
-
-	return next()
+	return firstErr
 }
 
 func (stack *middlewareStack) runBeforeFilter(f beforeFunc, event *Event, config *Configuration) error {
@@ -70,8 +184,9 @@ func httpRequestMiddleware(event *Event, config *Configuration) error {
 	return nil
 }
 
-// httpRequestBodyMiddleware is added OnBeforeNotify by default.
-// TODO: description
+// httpRequestBodyMiddleware is added OnBeforeNotify by default. It adds the
+// request body attached to a context.Context passed in as rawData, by
+// CaptureRequestBody or AttachRequestData, to the "request" tab of the Event.
 func httpRequestBodyMiddleware(event *Event, config *Configuration) error {
 	for _, datum := range event.RawData {
 		if ctx, ok := datum.(context.Context); ok && ctx != nil {
@@ -93,3 +208,302 @@ func httpRequestBodyMiddleware(event *Event, config *Configuration) error {
 	}
 	return nil
 }
+
+// contextFuncMiddleware is added OnBeforeNotify by default. It sets the
+// Context of the Event using the configured ContextFunc, if any.
+func contextFuncMiddleware(event *Event, config *Configuration) error {
+	if config.ContextFunc == nil {
+		return nil
+	}
+	if context := config.ContextFunc(event); context != "" {
+		event.Context = context
+	}
+	return nil
+}
+
+// requestIDMiddleware is added OnBeforeNotify by default. It adds the ID
+// returned by the configured RequestIDFunc for a context.Context passed in as
+// rawData to the "request" tab of the Event.
+func requestIDMiddleware(event *Event, config *Configuration) error {
+	if config.RequestIDFunc == nil {
+		return nil
+	}
+	for _, datum := range event.RawData {
+		if ctx, ok := datum.(context.Context); ok && ctx != nil {
+			if id := config.RequestIDFunc(ctx); id != "" {
+				event.MetaData.Add("request", "id", id)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// sqlMiddleware is added OnBeforeNotify by default. It adds the details of a
+// SQLContext passed in as rawData to the "sql" tab of the Event, filtering the
+// arguments unless SendSQLArgs is set.
+func sqlMiddleware(event *Event, config *Configuration) error {
+	for _, datum := range event.RawData {
+		if query, ok := datum.(SQLContext); ok {
+			tab := map[string]interface{}{"query": query.Query}
+			if len(query.Args) > 0 {
+				args := make([]interface{}, len(query.Args))
+				for i, arg := range query.Args {
+					if config.SendSQLArgs {
+						args[i] = arg
+					} else {
+						args[i] = "[FILTERED]"
+					}
+				}
+				tab["args"] = args
+			}
+			if query.Duration != 0 {
+				tab["durationMs"] = query.Duration.Milliseconds()
+			}
+			event.MetaData.Update(MetaData{"sql": tab})
+		}
+	}
+	return nil
+}
+
+// outboundRequestMiddleware is added OnBeforeNotify by default. It adds the
+// details of an OutboundRequest passed in as rawData to the "outboundRequest"
+// tab of the Event.
+func outboundRequestMiddleware(event *Event, config *Configuration) error {
+	for _, datum := range event.RawData {
+		if request, ok := datum.(OutboundRequest); ok {
+			tab := map[string]interface{}{"url": filterURL(request.URL, config.ParamsFilters)}
+			if request.Method != "" {
+				tab["method"] = request.Method
+			}
+			if request.Status != 0 {
+				tab["status"] = request.Status
+			}
+			if request.Duration != 0 {
+				tab["durationMs"] = request.Duration.Milliseconds()
+			}
+			event.MetaData.Update(MetaData{"outboundRequest": tab})
+		}
+	}
+	return nil
+}
+
+// deadlineMiddleware is added OnBeforeNotify by default. It adds the deadline
+// of a context.Context passed in as rawData to the "deadline" tab of the
+// Event, along with how long was left before the deadline, or how long ago it
+// passed, and the context's error, so that a timeout shows what the budget
+// was. Contexts without a deadline are skipped.
+func deadlineMiddleware(event *Event, config *Configuration) error {
+	for _, datum := range event.RawData {
+		ctx, ok := datum.(context.Context)
+		if !ok || ctx == nil {
+			continue
+		}
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			continue
+		}
+		tab := map[string]interface{}{"deadline": deadline.UTC().Format(time.RFC3339Nano)}
+		if remaining := deadline.Sub(config.currentTime()); remaining >= 0 {
+			tab["remainingMs"] = remaining.Milliseconds()
+		} else {
+			tab["overrunMs"] = (-remaining).Milliseconds()
+		}
+		if err := ctx.Err(); err != nil {
+			tab["error"] = err.Error()
+		}
+		event.MetaData.Update(MetaData{"deadline": tab})
+		return nil
+	}
+	return nil
+}
+
+// validationMiddleware is added OnBeforeNotify by default. When the error is
+// or wraps one created by ValidationError it adds the field errors to the
+// "validation" tab of the Event and, unless a GroupingHash has already been
+// set, groups the Event by the fields which failed.
+func validationMiddleware(event *Event, config *Configuration) error {
+	if event.Error == nil {
+		return nil
+	}
+	validation, ok := findValidationError(event.Error.Err)
+	if !ok {
+		return nil
+	}
+	for field, message := range validation.fields {
+		event.MetaData.Add(validationTab, field, message)
+	}
+	if event.GroupingHash == "" {
+		event.GroupingHash = validation.groupingHash()
+	}
+	if event.ErrorClass == event.Error.TypeName() && event.Error.Err == error(validation) {
+		event.ErrorClass = validationErrorClass
+	}
+	return nil
+}
+
+// execMiddleware is added OnBeforeNotify by default. When the error is or
+// wraps one created by ExecError it adds the filtered command line, exit code
+// and standard error of the command to the "command" tab of the Event.
+func execMiddleware(event *Event, config *Configuration) error {
+	if event.Error == nil {
+		return nil
+	}
+	failure, ok := findExecError(event.Error.Err)
+	if !ok {
+		return nil
+	}
+	tab := map[string]interface{}{
+		"command":  failure.commandLine(config.ParamsFilters),
+		"exitCode": failure.exitCode,
+	}
+	if failure.dir != "" {
+		tab["dir"] = failure.dir
+	}
+	if failure.stderr != "" {
+		tab["stderr"], _ = truncateMessage(failure.stderr, config.maxMessageBytes())
+	}
+	event.MetaData.Update(MetaData{commandTab: tab})
+	if event.ErrorClass == event.Error.TypeName() && event.Error.Err == error(failure) {
+		event.ErrorClass = execErrorClass
+	}
+	return nil
+}
+
+// rootCauseGroupingMiddleware is added OnBeforeNotify by default. When
+// GroupByRootCause is set and the error wraps another, it groups the Event by
+// the class and message of the innermost cause, unless a GroupingHash has
+// already been set.
+func rootCauseGroupingMiddleware(event *Event, config *Configuration) error {
+	if !config.GroupByRootCause || event.Error == nil || event.Error.Cause == nil {
+		return nil
+	}
+	if event.GroupingHash == "" {
+		root := event.Error.Cause
+		for root.Cause != nil {
+			root = root.Cause
+		}
+		event.GroupingHash = root.TypeName() + ":" + root.Error()
+	}
+	return nil
+}
+
+// suppressionMiddleware is added OnBeforeNotify by default. It drops the
+// Event if a context.Context passed in as rawData was created by
+// WithSuppression, unless it's an unhandled panic and NotifySuppressedPanics
+// is set.
+func suppressionMiddleware(event *Event, config *Configuration) error {
+	if config.NotifySuppressedPanics && isUnhandledPanic(event) {
+		return nil
+	}
+	for _, datum := range event.RawData {
+		if ctx, ok := datum.(context.Context); ok && isSuppressed(ctx) {
+			return errSuppressed
+		}
+	}
+	return nil
+}
+
+// panicErrorClassPrefix is added to the error class of unhandled panics when
+// SeparatePanicGrouping is set.
+const panicErrorClassPrefix = "[panic] "
+
+// panicGroupingMiddleware is added OnBeforeNotify by default. When
+// SeparatePanicGrouping is set it prefixes the error class of unhandled
+// panics, so that they aren't grouped with handled errors of the same type.
+func panicGroupingMiddleware(event *Event, config *Configuration) error {
+	if config.SeparatePanicGrouping && isUnhandledPanic(event) {
+		if !strings.HasPrefix(event.ErrorClass, panicErrorClassPrefix) {
+			event.ErrorClass = panicErrorClassPrefix + event.ErrorClass
+		}
+	}
+	return nil
+}
+
+// isUnhandledPanic reports whether the event is for a panic which wasn't
+// handled, e.g. one repanicked by AutoNotify.
+func isUnhandledPanic(event *Event) bool {
+	if !event.Unhandled {
+		return false
+	}
+	reason := event.handledState.SeverityReason
+	return reason == SeverityReasonHandledPanic || reason == SeverityReasonUnhandledPanic
+}
+
+// appPhaseMiddleware is added OnBeforeNotify by default. It adds the
+// configured AppPhase to the "app" tab of the Event.
+func appPhaseMiddleware(event *Event, config *Configuration) error {
+	if config.AppPhase != "" {
+		event.MetaData.Add(appPhaseTab, "phase", string(config.AppPhase))
+	}
+	return nil
+}
+
+// envMiddleware is added OnBeforeNotify by default. It adds the environment
+// variables named in the EnvAllowlist to the "env" tab of the Event.
+func envMiddleware(event *Event, config *Configuration) error {
+	for name, value := range config.env {
+		event.MetaData.Add("env", name, value)
+	}
+	return nil
+}
+
+// goroutineLabelsMiddleware is added OnBeforeNotify by default. When
+// CollectGoroutineLabels is set it adds the pprof labels of a context.Context
+// passed in as rawData to the "goroutine" tab of the Event.
+func goroutineLabelsMiddleware(event *Event, config *Configuration) error {
+	if !config.CollectGoroutineLabels {
+		return nil
+	}
+	for _, datum := range event.RawData {
+		if ctx, ok := datum.(context.Context); ok && ctx != nil {
+			pprof.ForLabels(ctx, func(key, value string) bool {
+				event.MetaData.Add("goroutine", key, value)
+				return true
+			})
+		}
+	}
+	return nil
+}
+
+// goroutineDumpMiddleware is added OnBeforeNotify by default. It adds the
+// stack of each goroutine in a GoroutineDump passed in as rawData to the
+// "goroutines" tab of the Event.
+func goroutineDumpMiddleware(event *Event, config *Configuration) error {
+	for _, datum := range event.RawData {
+		if dump, ok := datum.(GoroutineDump); ok {
+			for id, stack := range dump.goroutines() {
+				event.MetaData.Add(goroutinesTab, id, stack)
+			}
+			if dump.Truncated {
+				event.MetaData.Add(goroutinesTab, "truncated", true)
+			}
+		}
+	}
+	return nil
+}
+
+// jobMiddleware is added OnBeforeNotify by default. It adds the details of a
+// Job passed in as rawData to the "job" tab of the Event, and sets the Context
+// to the queue name if it isn't already set.
+func jobMiddleware(event *Event, config *Configuration) error {
+	for _, datum := range event.RawData {
+		if job, ok := datum.(Job); ok {
+			tab := map[string]interface{}{}
+			if job.Queue != "" {
+				tab["queue"] = job.Queue
+			}
+			if job.ID != "" {
+				tab["id"] = job.ID
+			}
+			if job.Attempt != 0 {
+				tab["attempt"] = job.Attempt
+			}
+			event.MetaData.Update(MetaData{"job": tab})
+			if event.Context == "" {
+				event.Context = job.Queue
+			}
+		}
+	}
+	return nil
+}
@@ -2,11 +2,20 @@ package bugsnag
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bugsnag/bugsnag-go/v2/errors"
 )
@@ -109,3 +118,540 @@ func TestHttpRequestMiddleware(t *testing.T) {
 		t.Errorf("Should not happen")
 	}
 }
+
+func TestMiddlewareConcurrentRegistration(t *testing.T) {
+	stack := middlewareStack{}
+	err := fmt.Errorf("test")
+	config := &Configuration{Logger: log.New(ioutil.Discard, log.Prefix(), 0)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			stack.OnBeforeNotify(func(e *Event, c *Configuration) error {
+				return nil
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			event, _ := newEvent([]interface{}{errors.New(err, 1)}, &defaultNotifier)
+			stack.Run(event, config, func() error { return nil })
+		}()
+	}
+	wg.Wait()
+
+	if befores, _ := stack.snapshot(); len(befores) != 10 {
+		t.Errorf("expected 10 middleware to be registered but got %d", len(befores))
+	}
+}
+
+func TestMiddlewareRemove(t *testing.T) {
+	err := fmt.Errorf("test")
+	event, config := newEvent([]interface{}{errors.New(err, 1)}, &defaultNotifier)
+
+	result := make([]int, 0, 3)
+	stack := middlewareStack{}
+	stack.OnBeforeNotify(func(e *Event, c *Configuration) error {
+		result = append(result, 2)
+		return nil
+	})
+	handle := stack.add(func(e *Event, c *Configuration) error {
+		result = append(result, 1)
+		return nil
+	}, false)
+	stack.OnBeforeNotify(func(e *Event, c *Configuration) error {
+		result = append(result, 0)
+		return nil
+	})
+
+	stack.Remove(handle)
+	stack.Remove(handle)
+	stack.Run(event, config, func() error { return nil })
+
+	if !reflect.DeepEqual(result, []int{0, 2}) {
+		t.Errorf("unexpected middleware order after removal %v", result)
+	}
+}
+
+func TestMiddlewareRemoveDuringRun(t *testing.T) {
+	err := fmt.Errorf("test")
+	event, config := newEvent([]interface{}{errors.New(err, 1)}, &defaultNotifier)
+
+	result := make([]int, 0, 3)
+	stack := middlewareStack{}
+	var handle MiddlewareHandle
+	stack.OnBeforeNotify(func(e *Event, c *Configuration) error {
+		result = append(result, 1)
+		return nil
+	})
+	handle = stack.add(func(e *Event, c *Configuration) error {
+		stack.Remove(handle)
+		result = append(result, 0)
+		return nil
+	}, false)
+
+	stack.Run(event, config, func() error { return nil })
+	stack.Run(event, config, func() error { return nil })
+
+	if !reflect.DeepEqual(result, []int{0, 1, 1}) {
+		t.Errorf("unexpected middleware calls when removing during run %v", result)
+	}
+}
+
+func TestMiddlewareClear(t *testing.T) {
+	err := fmt.Errorf("test")
+	event, config := newEvent([]interface{}{errors.New(err, 1)}, &defaultNotifier)
+
+	stack := middlewareStack{}
+	stack.OnBeforeNotify(func(e *Event, c *Configuration) error {
+		t.Errorf("cleared middleware should not be called")
+		return nil
+	})
+	stack.Clear()
+
+	called := false
+	stack.Run(event, config, func() error {
+		called = true
+		return nil
+	})
+	if !called {
+		t.Errorf("Notify was not called after clearing middleware")
+	}
+}
+
+func TestMiddlewareFinalPhase(t *testing.T) {
+	err := fmt.Errorf("test")
+	event, config := newEvent([]interface{}{errors.New(err, 1)}, &defaultNotifier)
+
+	result := make([]int, 0, 4)
+	stack := middlewareStack{}
+	stack.OnBeforeNotifyFinal(func(e *Event, c *Configuration) error {
+		result = append(result, 3)
+		return nil
+	})
+	stack.OnBeforeNotifyFinal(func(e *Event, c *Configuration) error {
+		result = append(result, 2)
+		return nil
+	})
+	stack.OnBeforeNotify(func(e *Event, c *Configuration) error {
+		result = append(result, 1)
+		return nil
+	})
+	stack.OnBeforeNotify(func(e *Event, c *Configuration) error {
+		result = append(result, 0)
+		return ErrAbortNotification
+	})
+
+	called := false
+	e := stack.Run(event, config, func() error {
+		called = true
+		return nil
+	})
+
+	if e != ErrAbortNotification {
+		t.Errorf("Expected the abort error to be returned but got %v", e)
+	}
+	if called {
+		t.Errorf("Notify was called when a middleware aborted the notification")
+	}
+	if !reflect.DeepEqual(result, []int{0, 2, 3}) {
+		t.Errorf("unexpected middleware order %v", result)
+	}
+}
+
+type jobName string
+
+func TestContextFuncMiddleware(t *testing.T) {
+	config := &Configuration{ContextFunc: func(event *Event) string {
+		for _, datum := range event.RawData {
+			if name, ok := datum.(jobName); ok {
+				return string(name)
+			}
+		}
+		return ""
+	}}
+
+	event := &Event{RawData: []interface{}{jobName("send-invoices")}, Context: "default"}
+	if err := contextFuncMiddleware(event, config); err != nil {
+		t.Fatal(err)
+	}
+	if event.Context != "send-invoices" {
+		t.Errorf("Expected context to be set from rawData but was '%s'", event.Context)
+	}
+
+	event = &Event{Context: "default"}
+	contextFuncMiddleware(event, config)
+	if event.Context != "default" {
+		t.Errorf("Expected context to be unchanged when ContextFunc returns empty but was '%s'", event.Context)
+	}
+}
+
+type requestIDKey struct{}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	config := &Configuration{RequestIDFunc: func(ctx context.Context) string {
+		id, _ := ctx.Value(requestIDKey{}).(string)
+		return id
+	}}
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-8a1f")
+	event := &Event{
+		RawData:  []interface{}{ctx},
+		MetaData: MetaData{"request": {"params": "a=1"}},
+	}
+
+	if err := requestIDMiddleware(event, config); err != nil {
+		t.Fatal(err)
+	}
+	exp := MetaData{"request": {"params": "a=1", "id": "req-8a1f"}}
+	if !reflect.DeepEqual(event.MetaData, exp) {
+		t.Errorf("Expected meta-data to be '%+v' but was '%+v'", exp, event.MetaData)
+	}
+
+	event = &Event{RawData: []interface{}{context.Background()}, MetaData: MetaData{}}
+	requestIDMiddleware(event, config)
+	if len(event.MetaData) != 0 {
+		t.Errorf("Expected no request ID to be added but meta-data was '%+v'", event.MetaData)
+	}
+}
+
+// stuckGoroutine blocks until release is closed, to appear in goroutine
+// dumps.
+func stuckGoroutine(release chan struct{}) {
+	<-release
+}
+
+func TestGoroutineDumpMiddleware(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	go stuckGoroutine(release)
+	runtime.Gosched()
+
+	event := &Event{RawData: []interface{}{WithGoroutineDump()}, MetaData: MetaData{}}
+	if err := goroutineDumpMiddleware(event, &Configuration{}); err != nil {
+		t.Fatal(err)
+	}
+	stacks := event.MetaData[goroutinesTab]
+	if len(stacks) < 2 {
+		t.Fatalf("Expected the stacks of every goroutine but got '%+v'", stacks)
+	}
+	header := regexp.MustCompile(`^goroutine (\d+) \[[^\]]+\]:\n`)
+	stuck := false
+	for id, stack := range stacks {
+		match := header.FindStringSubmatch(stack.(string))
+		if match == nil || match[1] != id {
+			t.Errorf("Expected the stack of goroutine %s to start with its header but was '%s'", id, stack)
+		}
+		if strings.Contains(stack.(string), "bugsnag-go/v2.stuckGoroutine") && strings.Contains(stack.(string), "[chan receive") {
+			stuck = true
+		}
+	}
+	if !stuck {
+		t.Errorf("Expected the dump to include the stuck goroutine but got '%+v'", stacks)
+	}
+
+	defer func(max int) { MaxGoroutineDumpBytes = max }(MaxGoroutineDumpBytes)
+	MaxGoroutineDumpBytes = 100
+	dump := WithGoroutineDump()
+	if len(dump.Stacks) != 100 || !dump.Truncated {
+		t.Errorf("Expected the dump to be truncated to 100 bytes but it was %d bytes", len(dump.Stacks))
+	}
+}
+
+func TestJobMiddleware(t *testing.T) {
+	event := &Event{
+		RawData:  []interface{}{Job{Queue: "invoices", ID: "a1b2c3", Attempt: 3}},
+		MetaData: MetaData{},
+	}
+	if err := jobMiddleware(event, &Configuration{}); err != nil {
+		t.Fatal(err)
+	}
+	exp := MetaData{"job": {"queue": "invoices", "id": "a1b2c3", "attempt": 3}}
+	if !reflect.DeepEqual(event.MetaData, exp) {
+		t.Errorf("Expected meta-data to be '%+v' but was '%+v'", exp, event.MetaData)
+	}
+	if event.Context != "invoices" {
+		t.Errorf("Expected context to be the queue name but was '%s'", event.Context)
+	}
+
+	event = &Event{RawData: []interface{}{Job{Queue: "invoices"}}, MetaData: MetaData{}, Context: "billing"}
+	jobMiddleware(event, &Configuration{})
+	if event.Context != "billing" {
+		t.Errorf("Expected an existing context to be kept but was '%s'", event.Context)
+	}
+}
+
+func TestGoroutineLabelsMiddleware(t *testing.T) {
+	config := &Configuration{CollectGoroutineLabels: true}
+	labels := pprof.Labels("handler", "checkout", "tenant", "acme")
+	pprof.Do(context.Background(), labels, func(ctx context.Context) {
+		event := &Event{RawData: []interface{}{ctx}, MetaData: MetaData{}}
+		if err := goroutineLabelsMiddleware(event, config); err != nil {
+			t.Fatal(err)
+		}
+		exp := MetaData{"goroutine": {"handler": "checkout", "tenant": "acme"}}
+		if !reflect.DeepEqual(event.MetaData, exp) {
+			t.Errorf("Expected meta-data to be '%+v' but was '%+v'", exp, event.MetaData)
+		}
+
+		event = &Event{RawData: []interface{}{ctx}, MetaData: MetaData{}}
+		goroutineLabelsMiddleware(event, &Configuration{})
+		if len(event.MetaData) != 0 {
+			t.Errorf("Expected no labels to be added unless enabled but meta-data was '%+v'", event.MetaData)
+		}
+	})
+}
+
+func TestSQLMiddleware(t *testing.T) {
+	query := SQLContext{
+		Query:    "SELECT * FROM users WHERE email = $1 AND tenant = $2",
+		Args:     []interface{}{"ada@example.com", 42},
+		Duration: 1500 * time.Millisecond,
+	}
+	event := &Event{RawData: []interface{}{query}, MetaData: MetaData{}}
+	if err := sqlMiddleware(event, &Configuration{}); err != nil {
+		t.Fatal(err)
+	}
+	exp := MetaData{"sql": {
+		"query":      query.Query,
+		"args":       []interface{}{"[FILTERED]", "[FILTERED]"},
+		"durationMs": int64(1500),
+	}}
+	if !reflect.DeepEqual(event.MetaData, exp) {
+		t.Errorf("Expected arguments to be filtered by default but meta-data was '%+v'", event.MetaData)
+	}
+
+	event = &Event{RawData: []interface{}{query}, MetaData: MetaData{}}
+	sqlMiddleware(event, &Configuration{SendSQLArgs: true})
+	if args := event.MetaData["sql"]["args"]; !reflect.DeepEqual(args, query.Args) {
+		t.Errorf("Expected arguments to be sent when enabled but were '%v'", args)
+	}
+}
+
+func TestOutboundRequestMiddleware(t *testing.T) {
+	config := &Configuration{ParamsFilters: []string{"token"}}
+	event := &Event{
+		RawData:  []interface{}{OutboundRequest{Method: "POST", URL: "https://api.example.com/charges?token=abc&id=7", Status: 502}},
+		MetaData: MetaData{},
+	}
+	if err := outboundRequestMiddleware(event, config); err != nil {
+		t.Fatal(err)
+	}
+	exp := MetaData{"outboundRequest": {
+		"method": "POST",
+		"url":    "https://api.example.com/charges?id=7&token=[FILTERED]",
+		"status": 502,
+	}}
+	if !reflect.DeepEqual(event.MetaData, exp) {
+		t.Errorf("Expected meta-data to be '%+v' but was '%+v'", exp, event.MetaData)
+	}
+}
+
+func TestMiddlewareForcesSynchronousDelivery(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	handle := AddOnBeforeNotify(func(event *Event, config *Configuration) error {
+		if event.ErrorClass == "FatalConfigError" {
+			config.Synchronous = true
+		}
+		return nil
+	})
+	defer RemoveOnBeforeNotify(handle)
+
+	config := generateSampleConfig(ts.URL)
+	config.NotifyReleaseStages = []string{"test"}
+	config.Logger = log.New(ioutil.Discard, "", 0)
+	notifier := New(config)
+	notifier.Config.Synchronous = false
+
+	err := notifier.Notify(fmt.Errorf("missing key"), ErrorClass{Name: "FatalConfigError"})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Expected the delivery error to be returned for a synchronous delivery but got '%v'", err)
+	}
+	if err := notifier.Notify(fmt.Errorf("timeout")); err != nil {
+		t.Errorf("Expected other errors to be delivered asynchronously but got '%v'", err)
+	}
+	if !deliveries.drain(5 * time.Second) {
+		t.Fatal("Expected the asynchronous delivery to finish")
+	}
+	if _, _, err := notifier.BuildEvent(fmt.Errorf("missing key"), ErrorClass{Name: "FatalConfigError"}); err != nil {
+		t.Fatal(err)
+	}
+	if notifier.Config.Synchronous {
+		t.Errorf("Expected the change to the configuration to be local to the event")
+	}
+}
+
+func TestDeadlineMiddleware(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	config := &Configuration{now: func() time.Time { return now }}
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(-1500*time.Millisecond))
+	defer cancel()
+	event := &Event{RawData: []interface{}{context.Background(), ctx}, MetaData: MetaData{}}
+	if err := deadlineMiddleware(event, config); err != nil {
+		t.Fatal(err)
+	}
+	exp := MetaData{"deadline": {
+		"deadline":  "2021-06-01T11:59:58.5Z",
+		"overrunMs": int64(1500),
+		"error":     context.DeadlineExceeded.Error(),
+	}}
+	if !reflect.DeepEqual(event.MetaData, exp) {
+		t.Errorf("Expected meta-data to be '%+v' but was '%+v'", exp, event.MetaData)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), now.Add(time.Minute))
+	defer cancel()
+	event = &Event{RawData: []interface{}{ctx}, MetaData: MetaData{}}
+	deadlineMiddleware(event, config)
+	if remaining := event.MetaData["deadline"]["remainingMs"]; remaining != int64(60000) {
+		t.Errorf("Expected 60000ms to be remaining but was '%v'", remaining)
+	}
+
+	event = &Event{RawData: []interface{}{context.Background()}, MetaData: MetaData{}}
+	deadlineMiddleware(event, config)
+	if len(event.MetaData) != 0 {
+		t.Errorf("Expected nothing to be added for a context without a deadline but meta-data was '%+v'", event.MetaData)
+	}
+}
+
+func TestSeparatePanicGrouping(t *testing.T) {
+	errTimeout := fmt.Errorf("timeout")
+	panicked := HandledState{SeverityReason: SeverityReasonHandledPanic, OriginalSeverity: SeverityError, Unhandled: true}
+
+	for _, separate := range []bool{false, true} {
+		notifier := New(Configuration{APIKey: testAPIKey, SeparatePanicGrouping: separate})
+		handledEvent, config := newEvent([]interface{}{errTimeout}, notifier)
+		panicEvent, _ := newEvent([]interface{}{errTimeout, panicked}, notifier)
+		for _, event := range []*Event{handledEvent, panicEvent} {
+			if err := panicGroupingMiddleware(event, config); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		handled, crashed := handledEvent.ErrorClass, panicEvent.ErrorClass
+		if separate && crashed != panicErrorClassPrefix+handled {
+			t.Errorf("Expected the panic to have the error class '%s' but it was '%s'", panicErrorClassPrefix+handled, crashed)
+		}
+		if !separate && crashed != handled {
+			t.Errorf("Expected the panic to be grouped with the handled error by default, but the error classes were '%s' and '%s'", crashed, handled)
+		}
+	}
+}
+
+func TestGroupByRootCause(t *testing.T) {
+	errRefused := fmt.Errorf("connection refused")
+	wrap := func(outer, inner string) error {
+		return fmt.Errorf("%s: %w", outer, fmt.Errorf("%s: %w", inner, errRefused))
+	}
+
+	for _, group := range []bool{false, true} {
+		notifier := New(Configuration{APIKey: testAPIKey, GroupByRootCause: group})
+		saved, config := newEvent([]interface{}{wrap("failed to save", "failed to connect")}, notifier)
+		loaded, _ := newEvent([]interface{}{wrap("failed to load", "failed to dial")}, notifier)
+		explicit, _ := newEvent([]interface{}{wrap("failed to load", "failed to dial")}, notifier)
+		explicit.GroupingHash = "explicit"
+		for _, event := range []*Event{saved, loaded, explicit} {
+			if err := rootCauseGroupingMiddleware(event, config); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		exp := "*errors.errorString:connection refused"
+		if group && (saved.GroupingHash != exp || loaded.GroupingHash != exp) {
+			t.Errorf("Expected both events to have the grouping hash '%s' but they were '%s' and '%s'", exp, saved.GroupingHash, loaded.GroupingHash)
+		}
+		if !group && (saved.GroupingHash != "" || loaded.GroupingHash != "") {
+			t.Errorf("Expected no grouping hash by default but they were '%s' and '%s'", saved.GroupingHash, loaded.GroupingHash)
+		}
+		if explicit.GroupingHash != "explicit" {
+			t.Errorf("Expected an explicit grouping hash to be kept but it was '%s'", explicit.GroupingHash)
+		}
+	}
+}
+
+func TestAppPhaseMiddleware(t *testing.T) {
+	defer SetAppPhase(Config.AppPhase)
+
+	for _, phase := range []AppPhase{"", AppPhaseStartup, AppPhaseStopping} {
+		SetAppPhase(phase)
+		event, config := newEvent([]interface{}{fmt.Errorf("oops")}, New(Configuration{APIKey: testAPIKey}))
+		if err := appPhaseMiddleware(event, config); err != nil {
+			t.Fatal(err)
+		}
+		bytes, err := (&payload{event, config}).MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := `"app":{"phase":"` + string(phase) + `"}`
+		got := string(bytes)
+		if phase != "" && !strings.Contains(got, exp) {
+			t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+		}
+		if phase == "" && strings.Contains(got, `"phase"`) {
+			t.Errorf("Expected no phase to be reported unless set but was '%s'", got)
+		}
+	}
+}
+
+func TestWithSuppression(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	var logged bytes.Buffer
+	delivered := make(chan struct{}, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer ts.Close()
+
+	// Other tests clear the default middleware
+	handle := AddOnBeforeNotify(suppressionMiddleware)
+	defer RemoveOnBeforeNotify(handle)
+
+	observer := &recordingMetricsObserver{}
+	config := generateSampleConfig(ts.URL)
+	config.NotifyReleaseStages = []string{"test"}
+	config.Logger = log.New(&logged, "", 0)
+	config.MetricsObserver = observer
+	notifier := New(config)
+	notifier.Config.Synchronous = true
+	logged.Reset()
+
+	ctx := WithSuppression(context.Background())
+	if err := notifier.Notify(fmt.Errorf("database unreachable"), ctx); err != nil {
+		t.Errorf("Expected a suppressed notification not to return an error but got '%v'", err)
+	}
+	if len(delivered) != 0 {
+		t.Errorf("Expected a suppressed notification not to be delivered")
+	}
+	if logged.Len() != 0 {
+		t.Errorf("Expected a suppressed notification not to be logged but logged '%s'", logged.String())
+	}
+	observer.mutex.Lock()
+	if exp := "dropped " + string(DropReasonSuppressed); !strings.Contains(strings.Join(observer.calls, ","), exp) {
+		t.Errorf("Expected '%s' to be observed but got '%v'", exp, observer.calls)
+	}
+	observer.mutex.Unlock()
+
+	if err := notifier.Notify(fmt.Errorf("database unreachable"), context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 {
+		t.Errorf("Expected an unsuppressed notification to be delivered")
+	}
+
+	panicked := HandledState{SeverityReason: SeverityReasonHandledPanic, OriginalSeverity: SeverityError, Unhandled: true}
+	for _, notifyPanics := range []bool{false, true} {
+		event, c := newEvent([]interface{}{fmt.Errorf("crash"), ctx, panicked, Configuration{NotifySuppressedPanics: notifyPanics}}, notifier)
+		if err := suppressionMiddleware(event, c); (err == nil) != notifyPanics {
+			t.Errorf("Expected NotifySuppressedPanics=%v to decide whether unhandled panics are suppressed but got '%v'", notifyPanics, err)
+		}
+	}
+}
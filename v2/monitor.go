@@ -0,0 +1,75 @@
+package bugsnag
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// monitorErrorClass is the error class of events for operations which did
+// not finish within the timeout given to Monitor.
+const monitorErrorClass = "MonitorTimeout"
+
+// monitorTab is the metadata tab which the details of an operation which
+// did not finish are sent in.
+const monitorTab = "monitor"
+
+// monitorTimeout is an operation which did not finish within the timeout
+// given to Monitor.
+type monitorTimeout struct {
+	name    string
+	timeout time.Duration
+}
+
+func (e *monitorTimeout) Error() string {
+	return fmt.Sprintf("%s did not finish within %s", e.name, e.timeout)
+}
+
+// Monitor starts a watchdog for an operation which could hang without
+// failing, such as one waiting on a channel or a lock. See Notifier.Monitor.
+func Monitor(ctx context.Context, name string, timeout time.Duration) (done func()) {
+	return defaultNotifier.monitor(ctx, name, timeout, 1)
+}
+
+// Monitor starts a watchdog for an operation which could hang without
+// failing, such as one waiting on a channel or a lock. Unless the returned
+// done func is called within the timeout, an unhandled event is sent with
+// the name of the operation, the stacktrace of the caller of Monitor and a
+// GoroutineDump, which shows where the operation is stuck. Events are
+// grouped by the name of the operation. Only done stops the watchdog: an
+// operation can be stuck whether or not ctx is done, as a hung operation often
+// ignores its context.
+//
+//	done := notifier.Monitor(ctx, "sync users", time.Minute)
+//	defer done()
+//
+// done can be called more than once, and releases the watchdog's timer and
+// goroutine straight away.
+func (notifier *Notifier) Monitor(ctx context.Context, name string, timeout time.Duration) (done func()) {
+	return notifier.monitor(ctx, name, timeout, 1)
+}
+
+// monitor starts the watchdog of Monitor, skipping the given number of
+// frames above it in the stacktrace of the event.
+func (notifier *Notifier) monitor(ctx context.Context, name string, timeout time.Duration, skip int) func() {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	err := newError(&monitorTimeout{name: name, timeout: timeout}, skip+1, notifier.Config)
+	stop := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-stop:
+		case <-timer.C:
+			notifier.Notify(err, ctx, Unhandled(true), SeverityError,
+				ErrorClass{Name: monitorErrorClass}, WithGoroutineDump(),
+				MetaData{monitorTab: {"name": name, "timeoutMs": timeout.Milliseconds()}},
+				func(event *Event) { event.GroupingHash = monitorErrorClass + ":" + name })
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(stop) }) }
+}
@@ -0,0 +1,89 @@
+package bugsnag
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	events := make(chan *Event, 10)
+	handle := AddOnBeforeNotifyFinal(func(event *Event, config *Configuration) error {
+		if event.ErrorClass == monitorErrorClass {
+			events <- event
+		}
+		return fmt.Errorf("not delivering")
+	})
+	defer RemoveOnBeforeNotify(handle)
+
+	config := generateSampleConfig("http://localhost:9")
+	config.NotifyReleaseStages = []string{"test"}
+	config.Logger = log.New(ioutil.Discard, "", 0)
+	notifier := New(config)
+
+	t.Run("timeout", func(st *testing.T) {
+		done := notifier.Monitor(context.Background(), "sync users", 10*time.Millisecond)
+		defer done()
+		var event *Event
+		select {
+		case event = <-events:
+		case <-time.After(5 * time.Second):
+			st.Fatal("Expected an event when done wasn't called within the timeout")
+		}
+		if !event.Unhandled {
+			st.Errorf("Expected the event to be unhandled")
+		}
+		if event.Error.Error() != "sync users did not finish within 10ms" {
+			st.Errorf("Expected the message to name the operation but was '%s'", event.Error.Error())
+		}
+		if event.GroupingHash != "MonitorTimeout:sync users" {
+			st.Errorf("Expected the event to be grouped by the operation but the grouping hash was '%s'", event.GroupingHash)
+		}
+		if got := event.MetaData[monitorTab]["name"]; got != "sync users" {
+			st.Errorf("Expected the operation in the monitor tab but got '%v'", got)
+		}
+		if len(event.MetaData[goroutinesTab]) == 0 {
+			st.Errorf("Expected a goroutine dump to be added")
+		}
+		if len(event.Stacktrace) == 0 || !strings.Contains(event.Stacktrace[0].Method, "TestMonitor") {
+			st.Errorf("Expected the stacktrace to start at the caller of Monitor but got '%+v'", event.Stacktrace)
+		}
+	})
+
+	t.Run("cancelled context", func(st *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := notifier.Monitor(ctx, "sync users", 10*time.Millisecond)
+		defer done()
+		cancel()
+		select {
+		case <-events:
+		case <-time.After(5 * time.Second):
+			st.Fatal("Expected an event when done wasn't called within the timeout, even though ctx was done")
+		}
+	})
+
+	t.Run("done", func(st *testing.T) {
+		before := runtime.NumGoroutine()
+		for i := 0; i < 100; i++ {
+			done := notifier.Monitor(context.Background(), "sync users", 50*time.Millisecond)
+			done()
+			done()
+		}
+
+		for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		if got := runtime.NumGoroutine(); got > before {
+			st.Errorf("Expected the watchdogs to exit but there were %d goroutines, up from %d", got, before)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if len(events) != 0 {
+			st.Errorf("Expected no events for operations which finished in time")
+		}
+	})
+}
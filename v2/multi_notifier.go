@@ -0,0 +1,63 @@
+package bugsnag
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MultiNotifier sends each error to several Bugsnag projects, e.g. to a
+// team's own project and a central one. Each Notifier builds and delivers its
+// own event using its configuration, so OnBeforeNotify callbacks run once for
+// each project and a project which can't be reached doesn't stop the error
+// being sent to the others.
+type MultiNotifier struct {
+	Notifiers []*Notifier
+}
+
+// NewMultiNotifier creates a MultiNotifier which sends errors using each of
+// the notifiers.
+func NewMultiNotifier(notifiers ...*Notifier) *MultiNotifier {
+	return &MultiNotifier{Notifiers: notifiers}
+}
+
+// Notify sends an error to Bugsnag with each of the notifiers, as for
+// Notifier.Notify. If any of the notifiers fail, the returned error describes
+// each of the failures.
+func (m *MultiNotifier) Notify(err error, rawData ...interface{}) error {
+	errs := make([]error, len(m.Notifiers))
+	var wg sync.WaitGroup
+	for i, notifier := range m.Notifiers {
+		if empty, e := checkForEmptyError(err, notifier.Config); empty {
+			errs[i] = e
+			continue
+		}
+		// Stripping one stackframe to not include this function in the
+		// stacktrace for a manual notification. Each notifier gets its own
+		// error so that its StackSkip is used, and the frames are resolved
+		// here as they are cached lazily by the error, which may be shared
+		// if err is already an *errors.Error.
+		skipFrames := 1
+		notifierErr := newError(err, skipFrames, notifier.Config, notifier.RawData, rawData)
+		notifierErr.StackFrames()
+		wg.Add(1)
+		go func(i int, notifier *Notifier) {
+			defer wg.Done()
+			// Each notifier appends to the rawData, so needs its own copy
+			data := append([]interface{}(nil), rawData...)
+			errs[i] = notifier.NotifySync(notifierErr, cloneConfig(notifier.Config).Synchronous, data...)
+		}(i, notifier)
+	}
+	wg.Wait()
+
+	var failures []string
+	for _, e := range errs {
+		if e != nil {
+			failures = append(failures, e.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("bugsnag.MultiNotifier: %d of %d notifiers failed: %s", len(failures), len(m.Notifiers), strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package bugsnag
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/bitly/go-simplejson"
+)
+
+func TestMultiNotifier(t *testing.T) {
+	resetCircuitBreakers(t)
+	teamServer, teamReports := setup()
+	defer teamServer.Close()
+	centralServer, centralReports := setup()
+	defer centralServer.Close()
+
+	newProjectNotifier := func(url, apiKey, team string) *Notifier {
+		config := generateSampleConfig(url)
+		config.APIKey = apiKey
+		config.Synchronous = true
+		config.NotifyReleaseStages = []string{"test"}
+		return New(config, func(event *Event) { event.AddTag("team", team) })
+	}
+	notifier := NewMultiNotifier(
+		newProjectNotifier(teamServer.URL, testAPIKey, "billing"),
+		newProjectNotifier(centralServer.URL, "0123456789abcdef0123456789abcdef", "central"),
+	)
+
+	if err := notifier.Notify(fmt.Errorf("payment declined"), Context{String: "checkout"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		reports chan []byte
+		apiKey  string
+		team    string
+	}{
+		{reports: teamReports, apiKey: testAPIKey, team: "billing"},
+		{reports: centralReports, apiKey: "0123456789abcdef0123456789abcdef", team: "central"},
+	} {
+		select {
+		case r := <-tc.reports:
+			report, _ := simplejson.NewJson(r)
+			event := getIndex(report, "events", 0)
+			if got := getString(report, "apiKey"); got != tc.apiKey {
+				t.Errorf("Expected the report to be sent with API key '%s' but was '%s'", tc.apiKey, got)
+			}
+			if got := getString(event, "context"); got != "checkout" {
+				t.Errorf("Expected the rawData to be sent to each project but context was '%s'", got)
+			}
+			if got := getString(event, "metaData.tags.team"); got != tc.team {
+				t.Errorf("Expected the event to be enriched by its own notifier with team '%s' but was '%s'", tc.team, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the error to be sent to the project with API key '%s'", tc.apiKey)
+		}
+	}
+}
+
+func TestMultiNotifierPartialFailure(t *testing.T) {
+	resetCircuitBreakers(t)
+	server, reports := setup()
+	defer server.Close()
+	unreachable, _ := setup()
+	unreachable.Close()
+
+	newProjectNotifier := func(url string) *Notifier {
+		config := generateSampleConfig(url)
+		config.Synchronous = true
+		config.NotifyReleaseStages = []string{"test"}
+		return New(config)
+	}
+	notifier := NewMultiNotifier(newProjectNotifier(unreachable.URL), newProjectNotifier(server.URL))
+
+	err := notifier.Notify(fmt.Errorf("payment declined"))
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 notifiers failed") {
+		t.Errorf("Expected the failure to be reported but got '%v'", err)
+	}
+	select {
+	case <-reports:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the error to still be sent to the reachable project")
+	}
+}
+
+func TestMultiNotifierNilErrorUsesEachNotifiersConfig(t *testing.T) {
+	strict := New(Configuration{NotifyNilBehavior: NotifyNilError, Logger: log.New(ioutil.Discard, "", 0)})
+	lenient := New()
+	lenient.Config.NotifyNilBehavior = NotifyNilIgnore
+
+	err := NewMultiNotifier(strict, lenient).Notify(nil)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 notifiers failed") {
+		t.Errorf("Expected only the notifier configured to return an error to fail but got '%v'", err)
+	}
+}
@@ -1,7 +1,13 @@
 package bugsnag
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
 	"github.com/bugsnag/bugsnag-go/v2/errors"
+	uuid "github.com/google/uuid"
 )
 
 var publisher reportPublisher = new(defaultReportPublisher)
@@ -16,7 +22,7 @@ type Notifier struct {
 // You can pass an instance of bugsnag.Configuration in rawData to change the configuration.
 // Other values of rawData will be passed to Notify.
 func New(rawData ...interface{}) *Notifier {
-	config := Config.clone()
+	config := cloneConfig(&Config)
 	for i, datum := range rawData {
 		if c, ok := datum.(Configuration); ok {
 			config.update(&c)
@@ -30,6 +36,54 @@ func New(rawData ...interface{}) *Notifier {
 	}
 }
 
+// WithContext returns a copy of the notifier which includes ctx with every
+// error it reports, so that the session, user and meta-data attached to the
+// context are sent without passing ctx to each call. The copy shares the
+// notifier's Config. A context passed to Notify itself is applied after ctx,
+// so its session is used instead.
+func (notifier *Notifier) WithContext(ctx context.Context) *Notifier {
+	rawData := make([]interface{}, 0, len(notifier.RawData)+1)
+	rawData = append(rawData, ctx)
+	return &Notifier{
+		Config:  notifier.Config,
+		RawData: append(rawData, notifier.RawData...),
+	}
+}
+
+// newError takes the stacktrace for a notification of err, skipping frames as
+// for errors.New along with the StackSkip of the configuration or rawData.
+// The extra frames are only skipped if some frames are left, so that the
+// origin of the error is never lost. No stack is captured when
+// DisableStacktraces is set by the configuration or a Configuration in the
+// rawData.
+func newError(err interface{}, skip int, config *Configuration, rawData ...[]interface{}) *errors.Error {
+	extra := 0
+	disableStacktraces := false
+	if config != nil {
+		c := cloneConfig(config)
+		extra, disableStacktraces = c.StackSkip, c.DisableStacktraces
+	}
+	for _, data := range rawData {
+		for _, datum := range data {
+			switch datum := datum.(type) {
+			case StackSkip:
+				extra = int(datum)
+			case Configuration:
+				disableStacktraces = disableStacktraces || datum.DisableStacktraces
+			}
+		}
+	}
+	if disableStacktraces {
+		return errors.NewWithoutStack(err)
+	}
+	if extra > 0 {
+		if e := errors.New(err, skip+1+extra); len(e.Callers()) > 0 {
+			return e
+		}
+	}
+	return errors.New(err, skip+1)
+}
+
 // FlushSessionsOnRepanic takes a boolean that indicates whether sessions
 // should be flushed when AutoNotify repanics. In the case of a fatal panic the
 // sessions might not get sent to Bugsnag before the application shuts down.
@@ -39,7 +93,7 @@ func New(rawData ...interface{}) *Notifier {
 // needs only be called if you wish to inform Bugsnag that there is an error
 // handler that will take care of panics that AutoNotify will re-raise.
 func (notifier *Notifier) FlushSessionsOnRepanic(shouldFlush bool) {
-	notifier.Config.flushSessionsOnRepanic = shouldFlush
+	notifier.Config.flushSessionsOnRepanic = &shouldFlush
 }
 
 // Notify sends an error to Bugsnag. Any rawData you pass here will be sent to
@@ -47,13 +101,84 @@ func (notifier *Notifier) FlushSessionsOnRepanic(shouldFlush bool) {
 // or bugsnag.MetaData. Any bools in rawData overrides the
 // notifier.Config.Synchronous flag.
 func (notifier *Notifier) Notify(err error, rawData ...interface{}) (e error) {
-	if e := checkForEmptyError(err); e != nil {
+	if empty, e := checkForEmptyError(err, notifier.Config); empty {
 		return e
 	}
 	// Stripping one stackframe to not include this function in the stacktrace
 	// for a manual notification.
 	skipFrames := 1
-	return notifier.NotifySync(errors.New(err, skipFrames), notifier.Config.Synchronous, rawData...)
+	config := cloneConfig(notifier.Config)
+	return notifier.NotifySync(newError(err, skipFrames, config, notifier.RawData, rawData), config.Synchronous, rawData...)
+}
+
+// EventIDTag is the tag which holds the ID of an event sent by NotifyWithID,
+// so that the event can be found in the dashboard by searching for its ID.
+const EventIDTag = "eventId"
+
+// NotifyWithID sends an error to Bugsnag in the same way as Notify, and also
+// returns a unique ID for the event, e.g. to show to a user as a reference
+// for support requests. Bugsnag doesn't return an ID for an event, so the ID
+// is generated before the event is sent and added to it as the EventIDTag
+// tag. The ID is returned straight away if the event is sent asynchronously.
+func (notifier *Notifier) NotifyWithID(err error, rawData ...interface{}) (string, error) {
+	if empty, e := checkForEmptyError(err, notifier.Config); empty {
+		return "", e
+	}
+	id := uuid.New().String()
+	// Stripping one stackframe to not include this function in the stacktrace
+	// for a manual notification.
+	skipFrames := 1
+	err = newError(err, skipFrames, notifier.Config, notifier.RawData, rawData)
+	return id, notifier.NotifySync(err, notifier.Config.Synchronous, append(rawData, Tags{EventIDTag: id})...)
+}
+
+// NotifyMessage sends a message to Bugsnag without needing to create an error
+// first. The message is formatted as for fmt.Sprintf, and is reported with the
+// error class "Message" and the stacktrace of the caller.
+func (notifier *Notifier) NotifyMessage(format string, args ...interface{}) error {
+	// Stripping one stackframe to not include this function in the stacktrace
+	// for a manual notification.
+	skipFrames := 1
+	err := newError(fmt.Sprintf(format, args...), skipFrames, notifier.Config, notifier.RawData)
+	return notifier.Notify(err, ErrorClass{Name: messageErrorClass})
+}
+
+// The error class and message of the error sent by SendTestError, which can be
+// used to filter test errors out of the dashboard.
+const (
+	TestErrorClass   = "BugsnagTestError"
+	TestErrorMessage = "Bugsnag Go test error"
+)
+
+// SendTestError synchronously sends an unmistakable test error to Bugsnag, to
+// confirm that the API key and network path to Bugsnag work, e.g. after
+// deploying to a new environment. The error has the class TestErrorClass and
+// "test" set in its "bugsnag" tab. OnBeforeNotify callbacks are run as for
+// Notify, but the test error isn't subject to IgnoreErrors, sampling,
+// MinSeverity, de-duplication or batching, so that it's sent whenever it
+// can be. It returns an error describing why the test error wasn't
+// delivered, if it wasn't.
+func (notifier *Notifier) SendTestError() error {
+	err := newError(TestErrorMessage, 1, notifier.Config)
+	event, config := newEvent([]interface{}{err, true,
+		ErrorClass{Name: TestErrorClass},
+		MetaData{"bugsnag": {"test": true}},
+		SeverityInfo,
+	}, notifier)
+	e := middleware.Run(event, config, func() error {
+		if e := config.Validate(); e != nil {
+			return e
+		}
+		p := &payload{event, config}
+		if !p.notifyInReleaseStage() {
+			return fmt.Errorf("not notifying in %s", config.ReleaseStage)
+		}
+		return p.deliver()
+	})
+	if e != nil {
+		return fmt.Errorf("bugsnag.SendTestError: test error was not delivered to %s: %v", config.Endpoints.Notify, e)
+	}
+	return nil
 }
 
 // NotifySync sends an error to Bugsnag. A boolean parameter specifies whether
@@ -62,27 +187,140 @@ func (notifier *Notifier) Notify(err error, rawData ...interface{}) (e error) {
 // being converted to JSON. E.g. bugsnag.SeverityError, bugsnag.Context, or
 // bugsnag.MetaData.
 func (notifier *Notifier) NotifySync(err error, sync bool, rawData ...interface{}) error {
-	if e := checkForEmptyError(err); e != nil {
+	if empty, e := checkForEmptyError(err, notifier.Config); empty {
 		return e
 	}
 	// Stripping one stackframe to not include this function in the stacktrace
 	// for a manual notification.
 	skipFrames := 1
-	event, config := newEvent(append(rawData, errors.New(err, skipFrames), sync), notifier)
+	event, config := newEvent(append(rawData, newError(err, skipFrames, notifier.Config, notifier.RawData, rawData), sync), notifier)
+	config.metrics().Notified()
+	if config.shouldIgnore(event) {
+		config.dropped(event, DropReasonIgnored)
+		return nil
+	}
 
 	// Never block, start throwing away errors if we have too many.
+	published := false
+	var dropReason DropReason
 	e := middleware.Run(event, config, func() error {
+		// Checked after all middleware has run, as it may change the API
+		// key, the severity or whether the event is unhandled
+		if !config.DryRun {
+			if e := config.Validate(); e != nil {
+				dropReason = DropReasonNotConfigured
+				return e
+			}
+		}
+		if !config.severeEnough(event) {
+			dropReason = DropReasonSeverity
+			return nil
+		}
+		if !config.sampled(event) {
+			dropReason = DropReasonSampled
+			return nil
+		}
+		published = true
 		return publisher.publishReport(&payload{event, config})
 	})
 
+	if e == errSuppressed {
+		config.dropped(event, DropReasonSuppressed)
+		return nil
+	}
+	if dropReason != "" {
+		config.dropped(event, dropReason)
+		if e == nil {
+			return nil
+		}
+	} else if e != nil && !published {
+		config.dropped(event, DropReasonMiddleware)
+	}
 	if e != nil {
 		config.logf("bugsnag.Notify: %v", e)
 	}
 	return e
 }
 
+// BuildEvent creates the event which Notify would send for the error and
+// rawData, including running all OnBeforeNotify callbacks, without sending it.
+// The event can then be inspected or modified, and sent with Deliver. If the
+// event should not be sent, because it is ignored or a callback returned an
+// error, that error is returned along with the event.
+func (notifier *Notifier) BuildEvent(err error, rawData ...interface{}) (*Event, *Configuration, error) {
+	if empty, e := checkForEmptyError(err, notifier.Config); empty {
+		// There's no event to return, even if the error is ignored
+		if e == nil {
+			e = errNilError
+		}
+		return nil, nil, e
+	}
+	// Stripping one stackframe to not include this function in the stacktrace
+	// for a manual notification.
+	return notifier.buildEvent(err, 1, rawData)
+}
+
+// buildEvent creates the event for BuildEvent and RenderPayload, skipping
+// the given number of frames above it in the stacktrace.
+func (notifier *Notifier) buildEvent(err error, skipFrames int, rawData []interface{}) (*Event, *Configuration, error) {
+	event, config := newEvent(append(rawData, newError(err, skipFrames+1, notifier.Config, notifier.RawData, rawData)), notifier)
+	if config.shouldIgnore(event) {
+		return event, config, ErrAbortNotification
+	}
+
+	e := middleware.Run(event, config, func() error {
+		return nil
+	})
+	return event, config, e
+}
+
+// RenderPayload builds the event which Notify would send for the error and
+// rawData, running all OnBeforeNotify callbacks, and returns the report as
+// it would be sent to Bugsnag, indented for reading, without sending it. The
+// report is filtered and truncated in the same way as a delivered one, and
+// the FieldNameMapper is applied, but not the PayloadEncoder. An event
+// notified with a context from StartSession includes the counts its session
+// would have if the event was sent, without being counted against it. If the
+// event would not be sent, because it is ignored or a callback returned an
+// error, that error is returned instead.
+func (notifier *Notifier) RenderPayload(err error, rawData ...interface{}) ([]byte, error) {
+	if empty, e := checkForEmptyError(err, notifier.Config); empty {
+		if e == nil {
+			e = errNilError
+		}
+		return nil, e
+	}
+	event, config, e := notifier.buildEvent(err, 1, rawData)
+	if e != nil {
+		return nil, e
+	}
+	buf, e := marshalReport([]*payload{{event, config}}, false)
+	if e != nil {
+		return nil, e
+	}
+	var indented bytes.Buffer
+	if e := json.Indent(&indented, buf, "", "  "); e != nil {
+		return nil, e
+	}
+	return indented.Bytes(), nil
+}
+
+// Deliver sends an event created by BuildEvent to Bugsnag. OnBeforeNotify
+// callbacks are not run again, as BuildEvent has already run them. The event
+// is associated with its session, if any, in the same way as for Notify.
+func (notifier *Notifier) Deliver(event *Event, config *Configuration) error {
+	e := publisher.publishReport(&payload{event, config})
+	if e != nil {
+		config.logf("bugsnag.Deliver: %v", e)
+	}
+	return e
+}
+
 // AutoNotify notifies Bugsnag of any panics, then repanics.
 // It sends along any rawData that gets passed in.
+// The original panic value is repanicked unchanged, and the frames where the
+// panic originally happened remain on the stack below AutoNotify, as described
+// for bugsnag.AutoNotify.
 // Usage:
 //  go func() {
 //		defer AutoNotify()
@@ -98,7 +336,7 @@ func (notifier *Notifier) AutoNotify(rawData ...interface{}) {
 		// { "file": "github.com/bugsnag/bugsnag-go/notifier.go", "lineNumber": 116, "method": "(*Notifier).AutoNotify" },
 		// { "file": "runtime/asm_amd64.s", "lineNumber": 573, "method": "call32" },
 		skipFrames := 2
-		notifier.NotifySync(errors.New(err, skipFrames), true, rawData...)
+		notifier.notifyPanic(errors.NewPanic(err, skipFrames), rawData)
 		panic(err)
 	}
 }
@@ -111,8 +349,42 @@ func (notifier *Notifier) Recover(rawData ...interface{}) {
 		severity := notifier.getDefaultSeverity(rawData, SeverityWarning)
 		state := HandledState{SeverityReasonHandledPanic, severity, false, ""}
 		rawData = notifier.appendStateIfNeeded(rawData, state)
-		notifier.Notify(errors.New(err, 2), rawData...)
+		notifier.notifyPanic(errors.NewPanic(err, 2), rawData)
+	}
+}
+
+// notifyPanic sends a panic recovered by AutoNotify or Recover. Unhandled
+// panics are delivered before returning when SynchronousPanics is enabled,
+// and the sessions are flushed too unless FlushSessionsOnRepanic(false) has
+// been called, as the process is likely to exit.
+func (notifier *Notifier) notifyPanic(err *errors.Error, rawData []interface{}) {
+	if !notifier.isUnhandled(rawData) || !notifier.Config.IsSynchronousPanics() {
+		notifier.NotifySync(err, notifier.Config.Synchronous, rawData...)
+		return
+	}
+	notifier.NotifySync(err, true, rawData...)
+	if notifier.Config.isFlushSessionsOnRepanic() {
+		FlushSessions()
+	}
+}
+
+// isUnhandled reports whether an event notified with the rawData would be
+// unhandled.
+func (notifier *Notifier) isUnhandled(rawData []interface{}) bool {
+	unhandled := false
+	var explicit *Unhandled
+	for _, datum := range append(notifier.RawData, rawData...) {
+		switch datum := datum.(type) {
+		case HandledState:
+			unhandled = datum.Unhandled
+		case Unhandled:
+			explicit = &datum
+		}
+	}
+	if explicit != nil {
+		return bool(*explicit)
 	}
+	return unhandled
 }
 
 func (notifier *Notifier) dontPanic() {
@@ -1,10 +1,15 @@
 package bugsnag_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"runtime"
+	"runtime/debug"
 	"strings"
 	"testing"
+	"time"
 
 	simplejson "github.com/bitly/go-simplejson"
 	"github.com/bugsnag/bugsnag-go/v2"
@@ -232,6 +237,40 @@ func TestModifyingEventsWithCallbacks(t *testing.T) {
 	})
 }
 
+type panicValue struct{ code int }
+
+func panickyOrigin(notifier *bugsnag.Notifier, value interface{}) {
+	defer notifier.AutoNotify()
+	panic(value)
+}
+
+func TestAutoNotifyRepanicPreservesOrigin(t *testing.T) {
+	server, eventQueue := Setup()
+	defer server.Close()
+	notifier := notifierSetup(server.URL)
+
+	value := &panicValue{code: 7}
+	var recovered interface{}
+	var stack string
+	func() {
+		defer func() {
+			recovered = recover()
+			stack = string(debug.Stack())
+		}()
+		panickyOrigin(notifier, value)
+	}()
+	<-eventQueue
+
+	if recovered != value {
+		t.Errorf("Expected the original panic value to be repanicked but got '%v'", recovered)
+	}
+	autoNotify := strings.Index(stack, "(*Notifier).AutoNotify")
+	origin := strings.Index(stack, "panickyOrigin")
+	if autoNotify == -1 || origin == -1 || origin < autoNotify {
+		t.Errorf("Expected the stack to contain the original panic below AutoNotify but was:\n%s", stack)
+	}
+}
+
 func assertStackframesMatch(t *testing.T, expected []errors.StackFrame) {
 	var lastmatch int = 0
 	var matched int = 0
@@ -259,3 +298,287 @@ func assertStackframesMatch(t *testing.T, expected []errors.StackFrame) {
 		t.Errorf("failed to find matches for %d frames: '%v'\ngot: '%v'", len(expected)-matched, expected[matched:], string(s))
 	}
 }
+
+func TestBuildEventAndDeliver(t *testing.T) {
+	server, eventQueue := Setup()
+	defer server.Close()
+	notifier := notifierSetup(server.URL)
+
+	event, config, err := notifier.BuildEvent(fmt.Errorf("built"), bugsnag.Context{String: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Message != "built" || event.Context != "build" {
+		t.Errorf("unexpected event built: %+v", event)
+	}
+	select {
+	case <-eventQueue:
+		t.Fatalf("BuildEvent unexpectedly delivered the event")
+	default:
+	}
+
+	event.Context = "inspected"
+	config.Synchronous = true
+	if err := notifier.Deliver(event, config); err != nil {
+		t.Fatal(err)
+	}
+	json, _ := simplejson.NewJson(<-eventQueue)
+	if context := GetIndex(json, "events", 0).Get("context").MustString(); context != "inspected" {
+		t.Errorf("expected delivered context to be 'inspected' but was '%s'", context)
+	}
+}
+
+func TestRenderPayloadMatchesDelivered(t *testing.T) {
+	server, eventQueue := Setup()
+	defer server.Close()
+	notifier := notifierSetup(server.URL)
+	notifier.Config.Synchronous = true
+	notifier.Config.MaxMessageBytes = 20
+	notifier.Config.ExcludeMetaDataTabs = []string{"debug"}
+
+	rawData := []interface{}{
+		bugsnag.OccurredAt(time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)),
+		[]bugsnag.StackFrame{{Method: "main.run", File: "main.go", LineNumber: 12, InProject: true}},
+		bugsnag.MetaData{
+			"account": {"name": "Acme", "password": "hunter2"},
+			"debug":   {"verbose": true},
+		},
+	}
+	err := fmt.Errorf("a message which is far too long to be sent whole")
+	rendered, e := notifier.RenderPayload(err, rawData...)
+	if e != nil {
+		t.Fatal(e)
+	}
+	select {
+	case <-eventQueue:
+		t.Fatalf("RenderPayload unexpectedly delivered the event")
+	default:
+	}
+	if !strings.Contains(string(rendered), "\n  \"apiKey\"") {
+		t.Errorf("Expected the payload to be indented but got %s", rendered)
+	}
+
+	if e := notifier.Notify(err, rawData...); e != nil {
+		t.Fatal(e)
+	}
+	var compacted bytes.Buffer
+	if e := json.Compact(&compacted, rendered); e != nil {
+		t.Fatal(e)
+	}
+	if delivered := <-eventQueue; !bytes.Equal(compacted.Bytes(), delivered) {
+		t.Errorf("Expected the rendered payload to match the delivered one:\n%s\n%s", compacted.Bytes(), delivered)
+	}
+	for _, unwanted := range []string{"hunter2", "verbose", "far too long"} {
+		if strings.Contains(string(rendered), unwanted) {
+			t.Errorf("Expected '%s' to be filtered, excluded or truncated but got %s", unwanted, rendered)
+		}
+	}
+}
+
+func TestRenderPayloadDoesNotCountSession(t *testing.T) {
+	server, eventQueue := Setup()
+	defer server.Close()
+	notifier := notifierSetup(server.URL)
+	notifier.Config.Synchronous = true
+	ctx := bugsnag.StartSession(context.Background())
+
+	for i := 0; i < 2; i++ {
+		rendered, err := notifier.RenderPayload(fmt.Errorf("oops"), ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json, _ := simplejson.NewJson(rendered)
+		if got := GetIndex(json, "events", 0).GetPath("session", "events", "handled").MustInt(); got != 1 {
+			t.Errorf("Expected the rendered session to count the event once but the count was %d", got)
+		}
+	}
+	notifier.Notify(fmt.Errorf("oops"), ctx)
+	json, _ := simplejson.NewJson(<-eventQueue)
+	if got := GetIndex(json, "events", 0).GetPath("session", "events", "handled").MustInt(); got != 1 {
+		t.Errorf("Expected rendering not to count against the session but the delivered count was %d", got)
+	}
+}
+
+func TestWithContextAttachesSession(t *testing.T) {
+	server, eventQueue := Setup()
+	defer server.Close()
+	notifier := notifierSetup(server.URL)
+	notifier.Config.Synchronous = true
+
+	ctx := bugsnag.StartSession(context.Background())
+	requestNotifier := notifier.WithContext(ctx)
+	if requestNotifier.Config != notifier.Config {
+		t.Errorf("expected the context notifier to share the notifier's Config")
+	}
+	if len(requestNotifier.RawData) != len(notifier.RawData)+1 || requestNotifier.RawData[0] != ctx {
+		t.Errorf("expected the context to be prepended to rawData but got %v", requestNotifier.RawData)
+	}
+
+	requestNotifier.Notify(fmt.Errorf("first"))
+	requestNotifier.Notify(fmt.Errorf("second"))
+
+	var sessionID string
+	for i := 1; i <= 2; i++ {
+		json, _ := simplejson.NewJson(<-eventQueue)
+		session := GetIndex(json, "events", 0).Get("session")
+		id := session.Get("id").MustString()
+		if id == "" {
+			t.Fatalf("expected event %d to be attached to the context's session", i)
+		}
+		if sessionID != "" && id != sessionID {
+			t.Errorf("expected events to share session '%s' but got '%s'", sessionID, id)
+		}
+		sessionID = id
+		if handled := session.GetPath("events", "handled").MustInt(); handled != i {
+			t.Errorf("expected %d handled events in the session but got %d", i, handled)
+		}
+	}
+}
+
+// reportError wraps BuildEvent as a logging library might wrap Notify.
+func reportError(notifier *bugsnag.Notifier, err error, rawData ...interface{}) *bugsnag.Event {
+	event, _, _ := notifier.BuildEvent(err, rawData...)
+	return event
+}
+
+func TestStackSkipLeavesOutWrapperFrames(t *testing.T) {
+	notifier := bugsnag.New(bugsnag.Configuration{APIKey: TestAPIKey})
+
+	testCases := []struct {
+		name    string
+		config  int
+		rawData []interface{}
+		exp     string
+	}{
+		{"no skip", 0, nil, "reportError"},
+		{"rawData", 0, []interface{}{bugsnag.StackSkip(1)}, "TestStackSkipLeavesOutWrapperFrames"},
+		{"config", 1, nil, "TestStackSkipLeavesOutWrapperFrames"},
+		{"rawData over config", 5, []interface{}{bugsnag.StackSkip(1)}, "TestStackSkipLeavesOutWrapperFrames"},
+		{"past the origin", 0, []interface{}{bugsnag.StackSkip(1000)}, "reportError"},
+	}
+	for _, tc := range testCases {
+		notifier.Config.StackSkip = tc.config
+		event := reportError(notifier, fmt.Errorf("oops"), tc.rawData...)
+		if len(event.Stacktrace) == 0 {
+			t.Errorf("[%s] Expected a stacktrace but it was empty", tc.name)
+			continue
+		}
+		if got := event.Stacktrace[0].Method; got != tc.exp {
+			t.Errorf("[%s] Expected the top frame to be '%s' but was '%s'", tc.name, tc.exp, got)
+		}
+	}
+
+	wrapped := errors.New(fmt.Errorf("oops"), 0)
+	event := reportError(notifier, wrapped, bugsnag.StackSkip(1))
+	if got := event.Stacktrace[0].Method; got != "TestStackSkipLeavesOutWrapperFrames" {
+		t.Errorf("Expected an error's own stacktrace not to be skipped but the top frame was '%s'", got)
+	}
+}
+
+func TestSendTestError(t *testing.T) {
+	server, eventQueue := Setup()
+	defer server.Close()
+	notifier := notifierSetup(server.URL)
+
+	if err := notifier.SendTestError(); err != nil {
+		t.Fatal(err)
+	}
+	json, _ := simplejson.NewJson(<-eventQueue)
+	event := GetIndex(json, "events", 0)
+	exception := GetIndex(event, "exceptions", 0)
+	if class := exception.Get("errorClass").MustString(); class != bugsnag.TestErrorClass {
+		t.Errorf("Expected the error class to be '%s' but was '%s'", bugsnag.TestErrorClass, class)
+	}
+	if message := exception.Get("message").MustString(); message != bugsnag.TestErrorMessage {
+		t.Errorf("Expected the message to be '%s' but was '%s'", bugsnag.TestErrorMessage, message)
+	}
+	if !event.GetPath("metaData", "bugsnag", "test").MustBool() {
+		t.Errorf("Expected the event to be marked as a test in the 'bugsnag' tab")
+	}
+
+	rejected := notifierSetup(server.URL)
+	rejected.Config.APIKey = ""
+	err := rejected.SendTestError()
+	if err == nil || !strings.Contains(err.Error(), "empty API key") || !strings.Contains(err.Error(), server.URL) {
+		t.Errorf("Expected an error describing why the test error wasn't delivered but got '%v'", err)
+	}
+}
+
+func TestSendTestErrorIsNotFiltered(t *testing.T) {
+	server, eventQueue := Setup()
+	defer server.Close()
+	notifier := notifierSetup(server.URL)
+	notifier.Config.MinSeverity = bugsnag.SeverityError
+	notifier.Config.IgnoreErrors = []func(error) bool{func(error) bool { return true }}
+
+	if err := notifier.SendTestError(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-eventQueue:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the test error to be sent regardless of the filters which drop events")
+	}
+
+	unreachable := notifierSetup(server.URL)
+	unreachable.Config.Endpoints.Notify = "http://localhost:0"
+	if err := unreachable.SendTestError(); err == nil {
+		t.Errorf("Expected an error when the test error couldn't be sent")
+	}
+}
+
+func TestNotifyWithID(t *testing.T) {
+	server, eventQueue := Setup()
+	defer server.Close()
+	notifier := notifierSetup(server.URL)
+	notifier.Config.Synchronous = true
+
+	id, err := notifier.NotifyWithID(fmt.Errorf("oops"), bugsnag.Tags{bugsnag.EventIDTag: "overridden"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) != 36 {
+		t.Errorf("Expected the ID to be a UUID but was '%s'", id)
+	}
+	json, _ := simplejson.NewJson(<-eventQueue)
+	event := GetIndex(json, "events", 0)
+	if got := event.GetPath("metaData", "tags", bugsnag.EventIDTag).MustString(); got != id {
+		t.Errorf("Expected the event to be tagged with its ID '%s' but was '%s'", id, got)
+	}
+
+	other, _ := notifier.NotifyWithID(fmt.Errorf("oops"))
+	<-eventQueue
+	if other == id {
+		t.Errorf("Expected each event to have a different ID but both were '%s'", id)
+	}
+}
+
+func TestSynchronousPanics(t *testing.T) {
+	server, eventQueue := Setup()
+	defer server.Close()
+	notifier := notifierSetup(server.URL)
+	// Other tests leave the global configuration synchronous
+	notifier.Config.Synchronous = false
+	notifier.FlushSessionsOnRepanic(false)
+
+	func() {
+		defer func() { recover() }()
+		defer notifier.AutoNotify()
+		panic("fatal")
+	}()
+	select {
+	case <-eventQueue:
+	default:
+		t.Errorf("Expected the panic to be delivered before AutoNotify repanicked")
+	}
+
+	func() {
+		defer notifier.Recover(bugsnag.HandledState{SeverityReason: bugsnag.SeverityReasonUnhandledPanic, Unhandled: true})
+		panic("fatal")
+	}()
+	select {
+	case <-eventQueue:
+	default:
+		t.Errorf("Expected the unhandled panic to be delivered before Recover returned")
+	}
+}
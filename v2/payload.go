@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
@@ -16,8 +17,23 @@ import (
 
 const notifyPayloadVersion = "4"
 
+// notifyPayloadVersion5 is the newer event schema, which can be opted into
+// using Configuration.PayloadVersion.
+const notifyPayloadVersion5 = "5"
+
+// tagsTab is the metadata tab which event tags are sent in.
+const tagsTab = "tags"
+
+// traceTab is the metadata tab which trace correlation is sent in when the
+// payload version does not support it natively.
+const traceTab = "trace"
+
 var sessionMutex sync.Mutex
 
+// processStartedAt approximates when the process started, for reporting how
+// long the app had been running when an event happened.
+var processStartedAt = time.Now()
+
 type payload struct {
 	*Event
 	*Configuration
@@ -26,110 +42,309 @@ type payload struct {
 type hash map[string]interface{}
 
 func (p *payload) deliver() error {
+	return deliverReport([]*payload{p})
+}
 
-	if len(p.APIKey) != 32 {
-		return fmt.Errorf("bugsnag/payload.deliver: invalid api key: '%s'", p.APIKey)
-	}
-
-	buf, err := p.MarshalJSON()
-
-	if err != nil {
-		return fmt.Errorf("bugsnag/payload.deliver: %v", err)
-	}
-
+// send posts an already marshalled report to the notify endpoint using the
+// payload's configuration.
+func (p *payload) send(buf []byte) error {
 	client := http.Client{
 		Transport: p.Transport,
+		Timeout:   p.DeliveryTimeout,
 	}
 	req, err := http.NewRequest("POST", p.Endpoints.Notify, bytes.NewBuffer(buf))
 	if err != nil {
 		return fmt.Errorf("bugsnag/payload.deliver unable to create request: %v", err)
 	}
-	for k, v := range headers.PrefixedHeaders(p.APIKey, notifyPayloadVersion) {
+	for k, v := range headers.PrefixedHeaders(p.APIKey, p.payloadVersion()) {
 		req.Header.Add(k, v)
 	}
+	req.Header.Set("User-Agent", p.userAgent())
+	if p.PayloadEncoder != nil && p.PayloadContentType != "" {
+		req.Header.Set("Content-Type", p.PayloadContentType)
+	}
+	breaker := deliveryBreakers.get(p.Configuration)
+	if delay := breaker.retryDelay(); delay > 0 {
+		// The server asked us to back off, so hold the delivery until then
+		time.Sleep(delay)
+	}
+	if !breaker.allow() {
+		return fmt.Errorf("bugsnag/payload.deliver: not delivering while delivery circuit is %s", breaker.currentState())
+	}
 	resp, err := client.Do(req)
 	if err != nil {
+		breaker.recordFailure()
 		return fmt.Errorf("bugsnag/payload.deliver: %v", err)
 	}
 	defer resp.Body.Close()
+	defer drainBody(resp.Body)
 
 	if resp.StatusCode != 200 {
+		if retryAt := retryAfter(resp, breaker.now()); !retryAt.IsZero() {
+			breaker.recordRetryAfter(retryAt)
+		} else if isDeliveryFailure(resp.StatusCode) {
+			breaker.recordFailure()
+		} else {
+			breaker.recordSuccess()
+		}
 		return fmt.Errorf("bugsnag/payload.deliver: Got HTTP %s", resp.Status)
 	}
 
+	breaker.recordSuccess()
 	return nil
 }
 
 func (p *payload) MarshalJSON() ([]byte, error) {
+	event := p.eventJSON(true)
+	event.Metadata = p.withoutExcludedTabs(event.Metadata)
 	return json.Marshal(reportJSON{
-		APIKey: p.APIKey,
-		Events: []eventJSON{
-			eventJSON{
-				App: &appJSON{
-					ReleaseStage: p.ReleaseStage,
-					Type:         p.AppType,
-					Version:      p.AppVersion,
-				},
-				Context: p.Context,
-				Device: &deviceJSON{
-					Hostname:        p.Hostname,
-					OsName:          runtime.GOOS,
-					RuntimeVersions: device.GetRuntimeVersions(),
-				},
-				Request: p.Request,
-				Exceptions:     p.exceptions(),
-				GroupingHash:   p.GroupingHash,
-				Metadata:       p.MetaData.sanitize(p.ParamsFilters),
-				PayloadVersion: notifyPayloadVersion,
-				Session:        p.makeSession(),
-				Severity:       p.Severity.String,
-				SeverityReason: p.severityReasonPayload(),
-				Unhandled:      p.Unhandled,
-				User:           p.User,
-			},
-		},
-		Notifier: notifierJSON{
-			Name:    "Bugsnag Go",
-			URL:     "https://github.com/bugsnag/bugsnag-go",
-			Version: Version,
-		},
+		APIKey:   p.APIKey,
+		Events:   []eventJSON{event},
+		Notifier: p.notifier(),
 	})
 }
 
-func (p *payload) makeSession() *sessionJSON {
+// eventJSON builds the entry for this payload's event in a report's events.
+// The event is counted against its session unless countSession is false.
+func (p *payload) eventJSON(countSession bool) eventJSON {
+	session, sessionStartedAt := p.makeSession(countSession)
+	app := &appJSON{
+		ReleaseStage: p.ReleaseStage,
+		Type:         p.AppType,
+		Version:      p.AppVersion,
+		BuildUUID:    p.AppBuildID,
+		GitCommit:    p.GitCommit,
+		DeployID:     p.DeployID,
+	}
+	// Durations are only part of the newer event schema
+	if p.payloadVersion() == notifyPayloadVersion5 {
+		now := p.occurredAt()
+		app.Duration = durationMillis(now.Sub(processStartedAt))
+		if !sessionStartedAt.IsZero() {
+			app.DurationInForeground = durationMillis(now.Sub(sessionStartedAt))
+		}
+	}
+	return eventJSON{
+		App:     app,
+		Context: p.Context,
+		Device: &deviceJSON{
+			Hostname:        p.Hostname,
+			OsName:          runtime.GOOS,
+			Time:            p.eventTime(),
+			RuntimeVersions: device.GetRuntimeVersions(),
+		},
+		Request: p.Request,
+		Breadcrumbs:    p.breadcrumbs(),
+		Exceptions:     p.exceptions(),
+		GroupingHash:   p.groupingHash(),
+		Metadata:       p.metadata(),
+		PayloadVersion: p.payloadVersion(),
+		Session:        session,
+		Correlation:    p.correlation(),
+		Severity:       p.Severity.String,
+		SeverityReason: p.severityReasonPayload(),
+		Unhandled:      p.Unhandled,
+		User:           p.User,
+	}
+}
+
+// notifier returns the notifier identity, allowing libraries which embed
+// bugsnag-go to override it.
+func (p *payload) notifier() notifierJSON {
+	notifier := notifierJSON{
+		Name:    "Bugsnag Go",
+		URL:     "https://github.com/bugsnag/bugsnag-go",
+		Version: p.notifierVersion(),
+	}
+	if p.NotifierName != "" {
+		notifier.Name = p.NotifierName
+	}
+	if p.NotifierURL != "" {
+		notifier.URL = p.NotifierURL
+	}
+	return notifier
+}
+
+// payloadVersion returns the version of the event schema to send, defaulting
+// to version 4 when none or an unsupported version is configured.
+func (p *payload) payloadVersion() string {
+	if p.PayloadVersion == notifyPayloadVersion5 {
+		return notifyPayloadVersion5
+	}
+	return notifyPayloadVersion
+}
+
+// metadata sanitizes the event's MetaData and adds the event's tags in their
+// own tab, which is not subject to the configured ParamsFilters. Tags are
+// merged into a "tags" tab in the MetaData, rather than replacing it, as is
+// the trace correlation for payload versions which don't support it natively.
+func (p *payload) metadata() interface{} {
+	metaData := p.Event.MetaData
+	if len(p.DefaultMetaData) > 0 {
+		metaData = metaData.withDefaults(p.DefaultMetaData)
+	}
+	metadata := metaData.sanitizeToDepth(p.ParamsFilters, p.maxMetaDataDepth())
+	tabs, ok := metadata.(map[string]interface{})
+	if !ok {
+		return metadata
+	}
+	if len(p.Event.Tags) > 0 {
+		tags := make(map[string]interface{}, len(p.Event.Tags))
+		for key, value := range p.Event.Tags {
+			tags[key] = value
+		}
+		mergeTab(tabs, tagsTab, tags)
+	}
+	if p.TraceID != "" && p.payloadVersion() != notifyPayloadVersion5 {
+		mergeTab(tabs, traceTab, map[string]interface{}{
+			"traceId": p.TraceID,
+			"spanId":  p.SpanID,
+		})
+	}
+	if len(p.MetaDataTabOrder) > 0 {
+		return orderedTabs{tabs: tabs, order: p.MetaDataTabOrder}
+	}
+	return metadata
+}
+
+// mergeTab adds the values to the named tab of the sanitized meta-data,
+// keeping any other values already in the tab. The values take precedence
+// over those with the same keys.
+func mergeTab(tabs map[string]interface{}, name string, values map[string]interface{}) {
+	tab, ok := tabs[name].(map[string]interface{})
+	if !ok {
+		tabs[name] = values
+		return
+	}
+	for key, value := range values {
+		tab[key] = value
+	}
+}
+
+// withoutExcludedTabs removes the configured ExcludeMetaDataTabs from
+// sanitized meta-data. This is only done when a report is sent, so that the
+// tabs still appear in reports logged in DryRun mode.
+func (p *payload) withoutExcludedTabs(metadata interface{}) interface{} {
+	var tabs map[string]interface{}
+	switch m := metadata.(type) {
+	case map[string]interface{}:
+		tabs = m
+	case orderedTabs:
+		tabs = m.tabs
+	}
+	for _, name := range p.ExcludeMetaDataTabs {
+		delete(tabs, name)
+	}
+	return metadata
+}
+
+// breadcrumbs returns the event's breadcrumbs with their meta-data sanitized.
+func (p *payload) breadcrumbs() []Breadcrumb {
+	if len(p.Breadcrumbs) == 0 {
+		return nil
+	}
+	breadcrumbs := make([]Breadcrumb, len(p.Breadcrumbs))
+	for i, breadcrumb := range p.Breadcrumbs {
+		if breadcrumb.MetaData != nil {
+			sanitized, _ := sanitizer{Filters: p.ParamsFilters}.Sanitize(breadcrumb.MetaData).(map[string]interface{})
+			breadcrumb.MetaData = sanitized
+		}
+		breadcrumbs[i] = breadcrumb
+	}
+	return breadcrumbs
+}
+
+// correlation returns the trace correlation of the event for payload versions
+// which support it natively.
+func (p *payload) correlation() *correlationJSON {
+	if p.TraceID == "" || p.payloadVersion() != notifyPayloadVersion5 {
+		return nil
+	}
+	return &correlationJSON{TraceID: p.TraceID, SpanID: p.SpanID}
+}
+
+// occurredAt returns when the event occurred, or the current time for events
+// which weren't created by Notify.
+func (p *payload) occurredAt() time.Time {
+	if !p.Time.IsZero() {
+		return p.Time
+	}
+	return p.currentTime()
+}
+
+// eventTime formats when the event occurred for the payload, if known.
+func (p *payload) eventTime() string {
+	if p.Time.IsZero() {
+		return ""
+	}
+	return p.Time.UTC().Format(time.RFC3339Nano)
+}
+
+// makeSession returns the session the event belongs to, counting the event
+// towards it, along with the time the session started. If count is false the
+// session isn't changed, and the counts are those it would have if the event
+// was counted.
+func (p *payload) makeSession(count bool) (*sessionJSON, time.Time) {
 	// If a context has not been applied to the payload then assume that no
 	// session has started either
 	if p.Ctx == nil {
-		return nil
+		return nil, time.Time{}
 	}
 
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
-	session := sessions.IncrementEventCountAndGetSession(p.Ctx, p.Unhandled)
-	if session != nil {
-		s := *session
-		return &sessionJSON{
-			ID:        s.ID,
-			StartedAt: s.StartedAt.UTC().Format(time.RFC3339),
-			Events: sessions.EventCounts{
-				Handled:   s.EventCounts.Handled,
-				Unhandled: s.EventCounts.Unhandled,
-			},
+	var session *sessions.Session
+	if count {
+		session = sessions.IncrementEventCountAndGetSession(p.Ctx, p.Unhandled)
+	} else {
+		session = sessions.SessionFromContext(p.Ctx)
+	}
+	if session == nil {
+		return nil, time.Time{}
+	}
+	counts := *session.EventCounts
+	if !count {
+		if p.Unhandled {
+			counts.Unhandled++
+		} else {
+			counts.Handled++
 		}
 	}
-	return nil
+	return &sessionJSON{
+		ID:        session.ID,
+		StartedAt: session.StartedAt.UTC().Format(time.RFC3339),
+		Events:    counts,
+	}, session.StartedAt
+}
+
+// durationMillis converts a duration to the number of milliseconds which is
+// sent in event payloads.
+func durationMillis(d time.Duration) *int64 {
+	if d < 0 {
+		d = 0
+	}
+	ms := int64(d / time.Millisecond)
+	return &ms
 }
 
 func (p *payload) severityReasonPayload() *severityReasonJSON {
-	if reason := p.handledState.SeverityReason; reason != "" {
+	// Changing the handled-ness of an event must always be reported, even if
+	// no severity reason was given, so that stability scores stay accurate
+	overridden := p.handledState.Unhandled != p.Unhandled
+	if reason := p.handledState.SeverityReason; reason != "" || overridden {
 		json := &severityReasonJSON{
 			Type: reason,
-			UnhandledOverridden: p.handledState.Unhandled != p.Unhandled,
+			UnhandledOverridden: overridden,
+		}
+		if p.handledState.Framework != "" || p.responseStatus != 0 {
+			json.Attributes = make(map[string]string, 2)
 		}
 		if p.handledState.Framework != "" {
-			json.Attributes = make(map[string]string, 1)
 			json.Attributes["framework"] = p.handledState.Framework
 		}
+		if p.responseStatus != 0 {
+			json.Attributes["statusCode"] = strconv.Itoa(p.responseStatus)
+		}
 		return json
 	}
 	return nil
@@ -150,10 +365,14 @@ func (p *payload) exceptions() []exceptionJSON {
 
 	cause := p.Error.Cause
 	for cause != nil {
+		stacktrace := []StackFrame{}
+		if !p.DisableStacktraces {
+			stacktrace = generateStacktrace(cause, p.Configuration)
+		}
 		exceptions = append(exceptions, exceptionJSON{
 			ErrorClass: cause.TypeName(),
 			Message:    cause.Error(),
-			Stacktrace: generateStacktrace(cause, p.Configuration),
+			Stacktrace: stacktrace,
 		})
 		cause = cause.Cause
 	}
@@ -1,11 +1,20 @@
 package bugsnag
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bugsnag/bugsnag-go/v2/errors"
 	"github.com/bugsnag/bugsnag-go/v2/sessions"
@@ -13,10 +22,7 @@ import (
 
 const expSmall = `{"apiKey":"","events":[{"app":{"releaseStage":""},"device":{"osName":"%s","runtimeVersions":{"go":"%s"}},"exceptions":[{"errorClass":"","message":"","stacktrace":null}],"metaData":{},"payloadVersion":"4","severity":"","unhandled":false}],"notifier":{"name":"Bugsnag Go","url":"https://github.com/bugsnag/bugsnag-go","version":"` + Version + `"}}`
 
-// The large payload has a timestamp in it which makes it awkward to assert against.
-// Instead, assert that the timestamp property exist, along with the rest of the expected payload
-const expLargePre = `{"apiKey":"166f5ad3590596f9aa8d601ea89af845","events":[{"app":{"releaseStage":"mega-production","type":"gin","version":"1.5.3"},"context":"/api/v2/albums","device":{"hostname":"super.duper.site","osName":"%s","runtimeVersions":{"go":"%s"}},"exceptions":[{"errorClass":"error class","message":"error message goes here","stacktrace":[{"method":"doA","file":"a.go","lineNumber":65},{"method":"fetchB","file":"b.go","lineNumber":99,"inProject":true},{"method":"incrementI","file":"i.go","lineNumber":651}]}],"groupingHash":"custom grouping hash","metaData":{"custom tab":{"my key":"my value"}},"payloadVersion":"4","session":{"startedAt":"`
-const expLargePost = `,"severity":"info","severityReason":{"type":"unhandledError","attributes":{"framework":"gin"}},"unhandled":true,"user":{"id":"1234baerg134","name":"Kool Kidz on da bus","email":"typo@busgang.com"}}],"notifier":{"name":"Bugsnag Go","url":"https://github.com/bugsnag/bugsnag-go","version":"` + Version + `"}}`
+const expLarge = `{"apiKey":"166f5ad3590596f9aa8d601ea89af845","events":[{"app":{"releaseStage":"mega-production","type":"gin","version":"1.5.3"},"context":"/api/v2/albums","device":{"hostname":"super.duper.site","osName":"%s","runtimeVersions":{"go":"%s"}},"exceptions":[{"errorClass":"error class","message":"error message goes here","stacktrace":[{"method":"doA","file":"a.go","lineNumber":65},{"method":"fetchB","file":"b.go","lineNumber":99,"inProject":true},{"method":"incrementI","file":"i.go","lineNumber":651}]}],"groupingHash":"custom grouping hash","metaData":{"custom tab":{"my key":"my value"}},"payloadVersion":"4","session":{"startedAt":"2020-03-04T05:06:07Z","id":"%s","events":{"handled":0,"unhandled":1}},"severity":"info","severityReason":{"type":"unhandledError","attributes":{"framework":"gin"}},"unhandled":true,"user":{"id":"1234baerg134","name":"Kool Kidz on da bus","email":"typo@busgang.com"}}],"notifier":{"name":"Bugsnag Go","url":"https://github.com/bugsnag/bugsnag-go","version":"` + Version + `"}}`
 
 func TestMarshalEmptyPayload(t *testing.T) {
 	sessionTracker = sessions.NewSessionTracker(&sessionTrackingConfig)
@@ -29,16 +35,13 @@ func TestMarshalEmptyPayload(t *testing.T) {
 }
 
 func TestMarshalLargePayload(t *testing.T) {
+	defer setClock(func() time.Time { return time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC) })()
 	payload := makeLargePayload()
 	bytes, _ := payload.MarshalJSON()
-	got := string(bytes[:])
-	expPre := fmt.Sprintf(expLargePre, runtime.GOOS, runtime.Version())
-	if !strings.Contains(got, expPre) {
-		t.Errorf("Expected large payload to contain\n'%s'\n but was\n'%s'", expPre, got)
-
-	}
-	if !strings.Contains(got, expLargePost) {
-		t.Errorf("Expected large payload to contain\n'%s'\n but was\n'%s'", expLargePost, got)
+	session := sessions.IncrementEventCountAndGetSession(payload.Ctx, false)
+	exp := fmt.Sprintf(expLarge, runtime.GOOS, runtime.Version(), session.ID)
+	if got := string(bytes[:]); got != exp {
+		t.Errorf("Payload different to what was expected. \nGot: %s\nExp: %s", got, exp)
 	}
 }
 
@@ -91,3 +94,528 @@ func makeLargePayload() *payload {
 	}
 	return &payload{&event, &config}
 }
+
+func TestMarshalPayloadWithTags(t *testing.T) {
+	p := payload{
+		&Event{
+			Ctx:      context.Background(),
+			MetaData: MetaData{"account": {"password": "hunter2"}},
+			Tags:     Tags{"password": "visible", "region": "us-east-1"},
+		},
+		&Configuration{ParamsFilters: []string{"password"}},
+	}
+	bytes, _ := p.MarshalJSON()
+	got := string(bytes[:])
+	for _, exp := range []string{
+		`"account":{"password":"[FILTERED]"}`,
+		`"tags":{"password":"visible","region":"us-east-1"}`,
+	} {
+		if !strings.Contains(got, exp) {
+			t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+		}
+	}
+}
+
+func TestMarshalPayloadMergesTagsIntoMetaDataTab(t *testing.T) {
+	p := payload{
+		&Event{
+			Ctx:      context.Background(),
+			MetaData: MetaData{"tags": {"team": "payments", "region": "unknown"}},
+			Tags:     Tags{"region": "us-east-1"},
+		},
+		&Configuration{},
+	}
+	bytes, _ := p.MarshalJSON()
+	if exp, got := `"tags":{"region":"us-east-1","team":"payments"}`, string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+}
+
+func TestDeliverPayloadVersion5(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		headers <- r.Header
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	config := generateSampleConfig(ts.URL)
+	config.PayloadVersion = "5"
+	event, c := newEvent([]interface{}{fmt.Errorf("oops")}, New(config))
+	p := payload{event, c}
+	if err := p.deliver(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := (<-headers).Get("Bugsnag-Payload-Version"); got != "5" {
+		t.Errorf("Expected payload version header to be '5' but was '%s'", got)
+	}
+	if got := string(<-bodies); !strings.Contains(got, `"payloadVersion":"5"`) {
+		t.Errorf("Expected payload to declare version 5 but was '%s'", got)
+	}
+}
+
+func TestMarshalPayloadDurations(t *testing.T) {
+	defer func(t time.Time) { processStartedAt = t }(processStartedAt)
+	sessionStart := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	processStartedAt = sessionStart.Add(-time.Hour)
+	restore := setClock(func() time.Time { return sessionStart })
+	ctx := StartSession(context.Background())
+	restore()
+
+	config := &Configuration{PayloadVersion: "5", now: func() time.Time { return sessionStart.Add(90 * time.Second) }}
+	bytes, _ := (&payload{&Event{Ctx: ctx}, config}).MarshalJSON()
+	exp := `"duration":3690000,"durationInForeground":90000}`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+
+	config.PayloadVersion = "4"
+	bytes, _ = (&payload{&Event{Ctx: ctx}, config}).MarshalJSON()
+	if got := string(bytes); strings.Contains(got, "duration") {
+		t.Errorf("Expected durations not to be sent in payload version 4 but was '%s'", got)
+	}
+}
+
+func TestOccurredAt(t *testing.T) {
+	defer func(t time.Time) { processStartedAt = t }(processStartedAt)
+	sessionStart := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	processStartedAt = sessionStart.Add(-time.Hour)
+	restore := setClock(func() time.Time { return sessionStart })
+	ctx := StartSession(context.Background())
+	restore()
+
+	occurred := sessionStart.Add(30 * time.Second)
+	config := generateSampleConfig("")
+	config.PayloadVersion = "5"
+	config.now = func() time.Time { return sessionStart.Add(24 * time.Hour) }
+	event, c := newEvent([]interface{}{fmt.Errorf("replayed"), ctx, OccurredAt(occurred)}, New(config))
+	if !event.Time.Equal(occurred) {
+		t.Errorf("Expected the event time to be %v but was %v", occurred, event.Time)
+	}
+	bytes, _ := (&payload{event, c}).MarshalJSON()
+	got := string(bytes)
+	for _, exp := range []string{`"time":"2020-03-04T05:06:37Z"`, `"duration":3630000,"durationInForeground":30000}`} {
+		if !strings.Contains(got, exp) {
+			t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+		}
+	}
+
+	event, _ = newEvent([]interface{}{fmt.Errorf("now")}, New(config))
+	if exp := config.now(); !event.Time.Equal(exp) {
+		t.Errorf("Expected the event time to default to now (%v) but was %v", exp, event.Time)
+	}
+}
+
+func TestMarshalPayloadTraceCorrelation(t *testing.T) {
+	event := &Event{Ctx: context.Background(), MetaData: MetaData{}, TraceID: "abc", SpanID: "def"}
+
+	v4, _ := (&payload{event, &Configuration{}}).MarshalJSON()
+	if got, exp := string(v4), `"trace":{"spanId":"def","traceId":"abc"}`; !strings.Contains(got, exp) {
+		t.Errorf("Expected version 4 payload to contain '%s' but was '%s'", exp, got)
+	}
+
+	v5, _ := (&payload{event, &Configuration{PayloadVersion: "5"}}).MarshalJSON()
+	if got, exp := string(v5), `"correlation":{"traceId":"abc","spanId":"def"}`; !strings.Contains(got, exp) {
+		t.Errorf("Expected version 5 payload to contain '%s' but was '%s'", exp, got)
+	}
+	if got := string(v5); strings.Contains(got, `"trace":`) {
+		t.Errorf("Expected version 5 payload not to contain a trace tab but was '%s'", got)
+	}
+
+	event.MetaData = MetaData{"trace": {"sampled": true}}
+	merged, _ := (&payload{event, &Configuration{}}).MarshalJSON()
+	if got, exp := string(merged), `"trace":{"sampled":true,"spanId":"def","traceId":"abc"}`; !strings.Contains(got, exp) {
+		t.Errorf("Expected the correlation to be merged into the trace tab '%s' but was '%s'", exp, got)
+	}
+}
+
+func TestMarshalPayloadServerErrorSeverityReason(t *testing.T) {
+	event, config := newEvent([]interface{}{
+		fmt.Errorf("GET /albums responded with 503"),
+		HandledState{
+			SeverityReason:   SeverityReasonHandledServerError,
+			OriginalSeverity: SeverityError,
+		},
+		ResponseStatus(503),
+	}, &defaultNotifier)
+
+	if event.Unhandled {
+		t.Errorf("Expected a server error response event to be handled")
+	}
+	bytes, _ := (&payload{event, config}).MarshalJSON()
+	exp := `"severityReason":{"type":"handledServerError","attributes":{"statusCode":"503"}}`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+	if got := string(bytes); !strings.Contains(got, `"unhandled":false`) {
+		t.Errorf("Expected payload to be reported as handled but was '%s'", got)
+	}
+}
+
+func TestMarshalPayloadCustomSeverityReason(t *testing.T) {
+	event, config := newEvent([]interface{}{fmt.Errorf("disk full"), SeverityReasonLog, SeverityError}, &defaultNotifier)
+	bytes, _ := (&payload{event, config}).MarshalJSON()
+	exp := `"severityReason":{"type":"log"}`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+
+	// As appended by AutoNotify after the caller's rawData
+	state := HandledState{SeverityReason: SeverityReasonHandledPanic, OriginalSeverity: SeverityError, Unhandled: true}
+	event, config = newEvent([]interface{}{fmt.Errorf("SIGTERM"), SeverityReasonSignal, state}, &defaultNotifier)
+	bytes, _ = (&payload{event, config}).MarshalJSON()
+	exp = `"severityReason":{"type":"signal"},"unhandled":true`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+}
+
+func TestMarshalPayloadUnhandledOverridden(t *testing.T) {
+	event, config := newEvent([]interface{}{
+		fmt.Errorf("oops"),
+		HandledState{SeverityReason: SeverityReasonUnhandledPanic, OriginalSeverity: SeverityError, Unhandled: true},
+	}, &defaultNotifier)
+	stack := middlewareStack{}
+	stack.OnBeforeNotify(func(event *Event, config *Configuration) error {
+		event.Unhandled = false
+		return nil
+	})
+	stack.Run(event, config, func() error { return nil })
+
+	bytes, _ := (&payload{event, config}).MarshalJSON()
+	exp := `"severityReason":{"type":"unhandledPanic","unhandledOverridden":true},"unhandled":false`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+
+	event = &Event{Ctx: context.Background(), Unhandled: true}
+	bytes, _ = (&payload{event, &Configuration{}}).MarshalJSON()
+	exp = `"severityReason":{"unhandledOverridden":true},"unhandled":true`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload without a severity reason to contain '%s' but was '%s'", exp, got)
+	}
+}
+
+func TestMarshalPayloadBuildInfo(t *testing.T) {
+	config := &Configuration{
+		AppVersion: "1.6.0",
+		AppBuildID: "ci-4821",
+		GitCommit:  "9f1c2e7",
+		DeployID:   "deploy-77",
+	}
+	event := &Event{Ctx: context.Background()}
+
+	bytes, _ := (&payload{event, config}).MarshalJSON()
+	exp := `"app":{"releaseStage":"","version":"1.6.0","buildUUID":"ci-4821","gitCommit":"9f1c2e7","deployId":"deploy-77"}`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+}
+
+func TestMarshalPayloadDefaultMetaData(t *testing.T) {
+	config := &Configuration{
+		DefaultMetaData: MetaData{
+			"service": {"name": "billing", "version": "1.2.3", "token": "abc"},
+			"region":  {"name": "us-east-1"},
+		},
+		ParamsFilters: []string{"token"},
+	}
+	event := &Event{Ctx: context.Background(), MetaData: MetaData{"service": {"version": "1.2.4", "job": "invoices"}}}
+
+	bytes, _ := (&payload{event, config}).MarshalJSON()
+	exp := `"metaData":{"region":{"name":"us-east-1"},"service":{"job":"invoices","name":"billing","token":"[FILTERED]","version":"1.2.4"}}`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+	if _, ok := config.DefaultMetaData["service"]["job"]; ok {
+		t.Errorf("Expected default meta-data not to be modified by events")
+	}
+}
+
+func TestMarshalPayloadMetaDataTabOrder(t *testing.T) {
+	config := &Configuration{MetaDataTabOrder: []string{"service", "missing", "account"}}
+	event := &Event{
+		Ctx: context.Background(),
+		MetaData: MetaData{
+			"account": {"plan": "pro", "id": 3},
+			"zebra":   {"stripes": true},
+			"service": {"name": "billing", "nested": map[string]interface{}{"b": 2, "a": 1}},
+			"apple":   {"core": false},
+		},
+		Tags: Tags{"region": "eu"},
+	}
+
+	exp := `"metaData":{"service":{"name":"billing","nested":{"a":1,"b":2}},"account":{"id":3,"plan":"pro"},"apple":{"core":false},"tags":{"region":"eu"},"zebra":{"stripes":true}}`
+	for i := 0; i < 5; i++ {
+		bytes, err := (&payload{event, config}).MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(bytes); !strings.Contains(got, exp) {
+			t.Fatalf("Expected payload to contain '%s' but was '%s'", exp, got)
+		}
+	}
+}
+
+func TestExcludeMetaDataTabs(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	for _, order := range [][]string{nil, {"debug", "account"}} {
+		var logged bytes.Buffer
+		config := generateSampleConfig(ts.URL)
+		config.Logger = log.New(&logged, "", 0)
+		config.ExcludeMetaDataTabs = []string{"debug"}
+		config.MetaDataTabOrder = order
+		event := &Event{
+			Ctx:      context.Background(),
+			MetaData: MetaData{"account": {"id": 3}, "debug": {"state": "internal"}},
+		}
+
+		if err := (&payload{event, &config}).deliver(); err != nil {
+			t.Fatal(err)
+		}
+		got := string(<-bodies)
+		if strings.Contains(got, `"debug"`) {
+			t.Errorf("Expected the debug tab to be excluded but was '%s'", got)
+		}
+		if !strings.Contains(got, `"account":{"id":3}`) {
+			t.Errorf("Expected the account tab to be sent but was '%s'", got)
+		}
+
+		config.DryRun = true
+		if err := (&payload{event, &config}).deliver(); err != nil {
+			t.Fatal(err)
+		}
+		if got := logged.String(); !strings.Contains(got, `"debug": {`) {
+			t.Errorf("Expected the debug tab to be logged in dry run mode but was '%s'", got)
+		}
+	}
+}
+
+func TestMarshalPayloadNotifierOverride(t *testing.T) {
+	config := &Configuration{
+		NotifierName:    "Acme Observability",
+		NotifierVersion: "3.1.4",
+		NotifierURL:     "https://example.com/acme-observability",
+	}
+	bytes, _ := (&payload{&Event{Ctx: context.Background()}, config}).MarshalJSON()
+	exp := `"notifier":{"name":"Acme Observability","url":"https://example.com/acme-observability","version":"3.1.4"}`
+	if got := string(bytes); !strings.Contains(got, exp) {
+		t.Errorf("Expected payload to contain '%s' but was '%s'", exp, got)
+	}
+}
+
+func TestDeliverFieldNameMapper(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	config := generateSampleConfig(ts.URL)
+	config.FieldNameMapper = func(name string) string {
+		switch name {
+		case "groupingHash":
+			return "grouping_hash"
+		case "errorClass":
+			return "error_class"
+		}
+		return name
+	}
+	event, c := newEvent([]interface{}{fmt.Errorf("renamed error"), MetaData{"account": {"groupingHash": "kept"}}}, New(config))
+	event.GroupingHash = "checkout"
+	if err := (&payload{event, c}).deliver(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(<-bodies)
+	for _, exp := range []string{`"grouping_hash":"checkout"`, `"error_class":"*errors.errorString"`, `"account":{"groupingHash":"kept"}`} {
+		if !strings.Contains(got, exp) {
+			t.Errorf("Expected the report to contain '%s' but was '%s'", exp, got)
+		}
+	}
+
+	// Renaming to the standard names leaves the report unchanged
+	buf, err := json.Marshal(reportJSON{APIKey: testAPIKey, Events: []eventJSON{(&payload{event, c}).eventJSON(true)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := renameFields(buf, func(name string) string { return name })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(renamed, buf) {
+		t.Errorf("Expected the report to be unchanged but it was '%s' rather than '%s'", renamed, buf)
+	}
+}
+
+func TestDeliverDryRun(t *testing.T) {
+	requested := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer ts.Close()
+
+	var logged bytes.Buffer
+	config := generateSampleConfig(ts.URL)
+	config.APIKey = ""
+	config.DryRun = true
+	config.Logger = log.New(&logged, "", 0)
+	event, c := newEvent([]interface{}{fmt.Errorf("dry run error")}, New(config))
+	if err := (&payload{event, c}).deliver(); err != nil {
+		t.Fatal(err)
+	}
+
+	if requested {
+		t.Errorf("Expected no request to be made in dry run mode")
+	}
+	if got := logged.String(); !strings.Contains(got, `"message": "dry run error"`) {
+		t.Errorf("Expected the pretty-printed report to be logged but was '%s'", got)
+	}
+}
+
+func TestDeliverPayloadEncoder(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	type request struct {
+		contentType string
+		body        []byte
+	}
+	requests := make(chan request, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{contentType: r.Header.Get("Content-Type"), body: body}
+	}))
+	defer ts.Close()
+
+	config := generateSampleConfig(ts.URL)
+	config.PayloadEncoder = func(report []byte) ([]byte, error) {
+		return []byte(base64.StdEncoding.EncodeToString(report)), nil
+	}
+	config.PayloadContentType = "application/vnd.example.bugsnag+base64"
+	event, c := newEvent([]interface{}{fmt.Errorf("encoded error")}, New(config))
+	if err := (&payload{event, c}).deliver(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	if req.contentType != config.PayloadContentType {
+		t.Errorf("Expected Content-Type '%s' but was '%s'", config.PayloadContentType, req.contentType)
+	}
+	report, err := base64.StdEncoding.DecodeString(string(req.body))
+	if err != nil {
+		t.Fatalf("Expected the body to be base64 encoded but was '%s'", req.body)
+	}
+	if got := string(report); !strings.Contains(got, `"message":"encoded error"`) {
+		t.Errorf("Expected the encoded report to be the JSON report but was '%s'", got)
+	}
+
+	c.PayloadEncoder = func(report []byte) ([]byte, error) {
+		return nil, fmt.Errorf("no key")
+	}
+	if err := (&payload{event, c}).deliver(); err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Errorf("Expected the encoder's error to be returned but got '%v'", err)
+	}
+}
+
+func TestDeliverUserAgent(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	userAgents := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name   string
+		update Configuration
+		exp    string
+	}{
+		{name: "default", exp: "bugsnag-go/" + Version},
+		{name: "notifier version", update: Configuration{NotifierVersion: "0.9.0"}, exp: "bugsnag-go/0.9.0"},
+		{name: "custom", update: Configuration{UserAgent: "acme-billing/2.1"}, exp: "acme-billing/2.1"},
+	} {
+		t.Run(tc.name, func(st *testing.T) {
+			config := generateSampleConfig(ts.URL)
+			config.update(&tc.update)
+			event, c := newEvent([]interface{}{fmt.Errorf("oops")}, New(config))
+			if err := (&payload{event, c}).deliver(); err != nil {
+				st.Fatal(err)
+			}
+			if got := <-userAgents; got != tc.exp {
+				st.Errorf("Expected User-Agent to be '%s' but was '%s'", tc.exp, got)
+			}
+		})
+	}
+}
+
+func TestDeliverTimeout(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	config := generateSampleConfig(ts.URL)
+	config.DeliveryTimeout = 50 * time.Millisecond
+	event, c := newEvent([]interface{}{fmt.Errorf("oops")}, New(config))
+
+	start := time.Now()
+	if err := (&payload{event, c}).deliver(); err == nil {
+		t.Errorf("Expected delivery to a hung server to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected delivery to give up after the timeout but took %v", elapsed)
+	}
+}
+
+func TestDeliverOnError(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	var kinds []string
+	config := generateSampleConfig(ts.URL)
+	config.Logger = log.New(ioutil.Discard, "", 0)
+	config.OnError = func(err error, kind string) {
+		if err == nil {
+			t.Errorf("Expected OnError to be called with an error for '%s'", kind)
+		}
+		kinds = append(kinds, kind)
+	}
+	event, c := newEvent([]interface{}{fmt.Errorf("rejected error")}, New(config))
+	if err := (&payload{event, c}).deliver(); err == nil {
+		t.Fatal("Expected a rejected report to fail")
+	}
+
+	c.PayloadEncoder = func(report []byte) ([]byte, error) {
+		return nil, fmt.Errorf("no key")
+	}
+	if err := (&payload{event, c}).deliver(); err == nil {
+		t.Fatal("Expected a report which couldn't be encoded to fail")
+	}
+
+	if exp := []string{ErrorKindDelivery, ErrorKindMarshal}; !reflect.DeepEqual(kinds, exp) {
+		t.Errorf("Expected OnError to be called for '%v' but was called for '%v'", exp, kinds)
+	}
+}
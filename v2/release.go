@@ -0,0 +1,131 @@
+package bugsnag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxReleaseErrorBody is the most of the build API's response which is
+// included in the error returned when a release is rejected.
+const maxReleaseErrorBody = 512
+
+// ReleaseInfo describes a release of the application, which is reported to
+// Bugsnag with NotifyRelease.
+type ReleaseInfo struct {
+	// AppVersion is the version being released. This defaults to the
+	// configured AppVersion, and a release can't be reported without one.
+	AppVersion string
+	// ReleaseStage is the stage the release is deployed to. This defaults to
+	// the configured ReleaseStage.
+	ReleaseStage string
+	// BuilderName is the person or system which made the release, e.g. the
+	// name of the CI pipeline.
+	BuilderName string
+	// Provider is the source control provider, e.g. "github", "gitlab" or
+	// "bitbucket". It can be left empty for repositories hosted on the
+	// provider's public service, as it is inferred from the repository URL.
+	Provider string
+	// Repository is the URL of the source control repository.
+	Repository string
+	// Revision is the commit which was released. This defaults to the
+	// configured GitCommit.
+	Revision string
+	// MetaData is any other information about the release, such as the ID of
+	// the CI run.
+	MetaData map[string]string
+}
+
+type releaseJSON struct {
+	APIKey        string             `json:"apiKey"`
+	AppVersion    string             `json:"appVersion"`
+	ReleaseStage  string             `json:"releaseStage,omitempty"`
+	BuilderName   string             `json:"builderName,omitempty"`
+	SourceControl *sourceControlJSON `json:"sourceControl,omitempty"`
+	MetaData      map[string]string  `json:"metadata,omitempty"`
+}
+
+type sourceControlJSON struct {
+	Provider   string `json:"provider,omitempty"`
+	Repository string `json:"repository"`
+	Revision   string `json:"revision"`
+}
+
+// NotifyRelease reports a release of the application to the build endpoint,
+// using the notifier's configuration to fill in any details which aren't set
+// on the release. It returns once the release has been recorded, or with an
+// error describing why it wasn't.
+func (notifier *Notifier) NotifyRelease(release ReleaseInfo) error {
+	config := notifier.Config
+	body := releaseJSON{
+		APIKey:       config.APIKey,
+		AppVersion:   release.AppVersion,
+		ReleaseStage: release.ReleaseStage,
+		BuilderName:  release.BuilderName,
+		MetaData:     release.MetaData,
+	}
+	if body.AppVersion == "" {
+		body.AppVersion = config.AppVersion
+	}
+	if body.ReleaseStage == "" {
+		body.ReleaseStage = config.ReleaseStage
+	}
+	revision := release.Revision
+	if revision == "" {
+		revision = config.GitCommit
+	}
+	// The build API rejects source control information without both a
+	// repository and a revision
+	if release.Repository != "" && revision != "" {
+		body.SourceControl = &sourceControlJSON{
+			Provider:   release.Provider,
+			Repository: release.Repository,
+			Revision:   revision,
+		}
+	}
+
+	if body.APIKey == "" {
+		return fmt.Errorf("bugsnag/release: no API key configured")
+	}
+	if body.AppVersion == "" {
+		return fmt.Errorf("bugsnag/release: no app version given")
+	}
+	if config.Endpoints.Build == "" {
+		return fmt.Errorf("bugsnag/release: no build endpoint configured")
+	}
+
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("bugsnag/release: unable to marshal release: %v", err)
+	}
+	req, err := http.NewRequest("POST", config.Endpoints.Build, bytes.NewBuffer(buf))
+	if err != nil {
+		return fmt.Errorf("bugsnag/release: unable to create request: %v", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("User-Agent", config.userAgent())
+
+	client := http.Client{
+		Transport: config.Transport,
+		Timeout:   config.DeliveryTimeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("bugsnag/release: %v", err)
+	}
+	defer resp.Body.Close()
+	defer drainBody(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxReleaseErrorBody))
+		if msg := strings.TrimSpace(string(detail)); msg != "" {
+			return fmt.Errorf("bugsnag/release: Got HTTP %s: %s", resp.Status, msg)
+		}
+		return fmt.Errorf("bugsnag/release: Got HTTP %s", resp.Status)
+	}
+	return nil
+}
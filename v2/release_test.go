@@ -0,0 +1,89 @@
+package bugsnag
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNotifyRelease(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	notifier := New(Configuration{
+		APIKey:       testAPIKey,
+		Endpoints:    Endpoints{Notify: server.URL, Sessions: server.URL, Build: server.URL},
+		AppVersion:   "1.5.0",
+		ReleaseStage: "production",
+		GitCommit:    "9f1c2e7",
+	})
+	err := notifier.NotifyRelease(ReleaseInfo{
+		AppVersion:  "1.6.0",
+		BuilderName: "deploy-pipeline",
+		Repository:  "https://github.com/example/billing",
+		MetaData:    map[string]string{"ci_run": "4821"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]interface{}{
+		"apiKey":       testAPIKey,
+		"appVersion":   "1.6.0",
+		"releaseStage": "production",
+		"builderName":  "deploy-pipeline",
+		"sourceControl": map[string]interface{}{
+			"repository": "https://github.com/example/billing",
+			"revision":   "9f1c2e7",
+		},
+		"metadata": map[string]interface{}{"ci_run": "4821"},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected release to be '%+v' but was '%+v'", exp, got)
+	}
+}
+
+func TestNotifyReleaseErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors":["appVersion is invalid"]}`))
+	}))
+	defer server.Close()
+	endpoints := Endpoints{Notify: server.URL, Sessions: server.URL, Build: server.URL}
+
+	for _, tc := range []struct {
+		name   string
+		config Configuration
+		exp    string
+	}{
+		{name: "rejected", config: Configuration{APIKey: testAPIKey, Endpoints: endpoints}, exp: "400 Bad Request: {\"errors\":[\"appVersion is invalid\"]}"},
+		{name: "no API key", config: Configuration{Endpoints: endpoints}, exp: "no API key"},
+		{name: "no build endpoint", config: Configuration{APIKey: testAPIKey, Endpoints: Endpoints{Notify: server.URL}}, exp: "no build endpoint"},
+	} {
+		t.Run(tc.name, func(st *testing.T) {
+			config := tc.config
+			notifier := &Notifier{Config: &config}
+			err := notifier.NotifyRelease(ReleaseInfo{AppVersion: "1.6.0"})
+			if err == nil || !strings.Contains(err.Error(), tc.exp) {
+				st.Errorf("Expected an error containing '%s' but got '%v'", tc.exp, err)
+			}
+		})
+	}
+
+	notifier := &Notifier{Config: &Configuration{APIKey: testAPIKey, Endpoints: endpoints}}
+	if err := notifier.NotifyRelease(ReleaseInfo{}); err == nil || !strings.Contains(err.Error(), "no app version") {
+		t.Errorf("Expected an error for a release without an app version but got '%v'", err)
+	}
+}
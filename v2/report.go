@@ -23,11 +23,13 @@ type eventJSON struct {
 	Context        string              `json:"context,omitempty"`
 	Device         *deviceJSON         `json:"device,omitempty"`
 	Request        *RequestJSON        `json:"request,omitempty"`
+	Breadcrumbs    []Breadcrumb        `json:"breadcrumbs,omitempty"`
 	Exceptions     []exceptionJSON     `json:"exceptions"`
 	GroupingHash   string              `json:"groupingHash,omitempty"`
 	Metadata       interface{}         `json:"metaData"`
 	PayloadVersion string              `json:"payloadVersion"`
 	Session        *sessionJSON        `json:"session,omitempty"`
+	Correlation    *correlationJSON    `json:"correlation,omitempty"`
 	Severity       string              `json:"severity"`
 	SeverityReason *severityReasonJSON `json:"severityReason,omitempty"`
 	Unhandled      bool                `json:"unhandled"`
@@ -40,10 +42,24 @@ type sessionJSON struct {
 	Events    sessions.EventCounts `json:"events"`
 }
 
+type correlationJSON struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId,omitempty"`
+}
+
 type appJSON struct {
 	ReleaseStage string `json:"releaseStage"`
 	Type         string `json:"type,omitempty"`
 	Version      string `json:"version,omitempty"`
+	BuildUUID    string `json:"buildUUID,omitempty"`
+	GitCommit    string `json:"gitCommit,omitempty"`
+	DeployID     string `json:"deployId,omitempty"`
+	// Duration is how many milliseconds the app had been running, and
+	// DurationInForeground how many milliseconds into the event's session
+	// the event happened, which for a server is the request or job being
+	// handled.
+	Duration             *int64 `json:"duration,omitempty"`
+	DurationInForeground *int64 `json:"durationInForeground,omitempty"`
 }
 
 type exceptionJSON struct {
@@ -61,6 +77,8 @@ type severityReasonJSON struct {
 type deviceJSON struct {
 	Hostname string `json:"hostname,omitempty"`
 	OsName   string `json:"osName,omitempty"`
+	// Time is when the event occurred
+	Time string `json:"time,omitempty"`
 
 	RuntimeVersions *device.RuntimeVersions `json:"runtimeVersions,omitempty"`
 }
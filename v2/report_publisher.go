@@ -11,17 +11,33 @@ type defaultReportPublisher struct{}
 func (*defaultReportPublisher) publishReport(p *payload) error {
 	p.logf("notifying bugsnag: %s", p.Message)
 	if !p.notifyInReleaseStage() {
+		p.dropped(p.Event, DropReasonReleaseStage)
 		return fmt.Errorf("not notifying in %s", p.ReleaseStage)
 	}
+	if state := deliveryBreakers.get(p.Configuration).currentState(); state == CircuitOpen {
+		p.dropped(p.Event, DropReasonCircuitOpen)
+		return fmt.Errorf("not notifying while delivery circuit is %s", state)
+	}
+	if p.DedupWindow > 0 && deduplicator.duplicate(p) {
+		p.dropped(p.Event, DropReasonDuplicate)
+		return nil
+	}
+	if p.BatchWindow > 0 && (!p.Synchronous || p.Unhandled) {
+		return batcher.add(p)
+	}
 	if p.Synchronous {
 		return p.deliver()
 	}
 
-	go func(p *payload) {
+	started := deliveries.run(p.Configuration, func() {
 		if err := p.deliver(); err != nil {
 			// Ensure that any errors are logged if they occur in a goroutine.
 			p.logf("bugsnag/defaultReportPublisher.publishReport: %v", err)
 		}
-	}(p)
+	})
+	if !started {
+		p.dropped(p.Event, DropReasonDeliveryOverflow)
+		return fmt.Errorf("not notifying as %d deliveries are in progress", p.MaxDeliveryConcurrency)
+	}
 	return nil
 }
@@ -33,6 +33,85 @@ func readRequestBody(r *http.Request) []byte {
 	return body
 }
 
+// DefaultMaxRequestBodySize is the default value of
+// Configuration.MaxRequestBodySize.
+var DefaultMaxRequestBodySize = 16 * 1024
+
+// DefaultSkipRequestBodyContentTypes is the default value of
+// Configuration.SkipRequestBodyContentTypes.
+var DefaultSkipRequestBodyContentTypes = []string{
+	"application/octet-stream",
+	"multipart/form-data",
+	"audio/",
+	"image/",
+	"video/",
+}
+
+// CaptureRequestBody returns a copy of the request with the start of its body
+// attached to its context, so that it's sent in the "request" tab of events
+// notified with the context. The body of the returned request can still be
+// read in full by later handlers. Up to Config.MaxRequestBodySize bytes are
+// kept, and bodies with one of the Config.SkipRequestBodyContentTypes aren't
+// kept at all. Call it before bugsnag.Handler so that panics include the body:
+//
+//	handler := bugsnag.Handler(mux)
+//	http.ListenAndServe(":8080", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//		handler.ServeHTTP(w, bugsnag.CaptureRequestBody(r))
+//	}))
+func CaptureRequestBody(r *http.Request) *http.Request {
+	if r.Body == nil || r.Body == http.NoBody || skipRequestBody(r.Header.Get("Content-Type")) {
+		return r
+	}
+	limit := Config.MaxRequestBodySize
+	if limit <= 0 {
+		limit = DefaultMaxRequestBodySize
+	}
+	body, _ := io.ReadAll(io.LimitReader(r.Body, int64(limit)))
+	captured := r.WithContext(context.WithValue(r.Context(), requestBodyContextKey, body))
+	// Replay the captured start of the body before the rest of it
+	captured.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	return captured
+}
+
+// skipRequestBody reports whether a body with the content type shouldn't be
+// captured.
+func skipRequestBody(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	skip := Config.SkipRequestBodyContentTypes
+	if skip == nil {
+		skip = DefaultSkipRequestBodyContentTypes
+	}
+	for _, t := range skip {
+		t = strings.ToLower(t)
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// userFromRequest derives a user from the request using the UserFromRequest
+// function, if one is configured. A panic in the function is logged rather
+// than stopping the event from being sent.
+func (config *Configuration) userFromRequest(req *http.Request) (user *User) {
+	if req == nil || config.UserFromRequest == nil {
+		return nil
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			config.logf("bugsnag/UserFromRequest: unexpected panic: %v", err)
+			user = nil
+		}
+	}()
+	if derived := config.UserFromRequest(req); derived != (User{}) {
+		return &derived
+	}
+	return nil
+}
+
 // extractRequestInfo looks for the request object that the notifier
 // automatically attaches to the context when using any of the supported
 // frameworks or bugsnag.HandlerFunc or bugsnag.Handler, and returns sub-object
@@ -96,6 +175,36 @@ func sanitizeURL(req *http.Request) string {
 	return u.String()
 }
 
+// filterURL replaces the values of query parameters matching the filters with
+// [FILTERED]. The URL is returned unchanged if it can't be parsed.
+func filterURL(rawURL string, filters []string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return rawURL
+	}
+	changed := false
+	for key, values := range query {
+		for i, value := range values {
+			if contains(filters, key) {
+				values[i] = "BUGSNAG_URL_FILTERED"
+				changed = true
+			} else if value == "[FILTERED]" {
+				// Already filtered, so left as it is if the query is
+				// encoded again
+				values[i] = "BUGSNAG_URL_FILTERED"
+			}
+		}
+	}
+	if changed {
+		u.RawQuery = strings.Replace(query.Encode(), "BUGSNAG_URL_FILTERED", "[FILTERED]", -1)
+	}
+	return u.String()
+}
+
 func parseRequestHeaders(header map[string][]string) map[string]string {
 	headers := make(map[string]string)
 	for k, v := range header {
@@ -2,6 +2,9 @@ package bugsnag
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -103,3 +106,76 @@ func TestParseHeadersWillSanitiseIllegalParams(t *testing.T) {
 		}
 	}
 }
+
+func TestCaptureRequestBody(t *testing.T) {
+	defer func(size int) { Config.MaxRequestBodySize = size }(Config.MaxRequestBodySize)
+	Config.MaxRequestBodySize = 10
+
+	body := `{"name":"a very long name"}`
+	read := make(chan string, 1)
+	captured := make(chan interface{}, 1)
+	hf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = CaptureRequestBody(r)
+		captured <- r.Context().Value(requestBodyContextKey)
+		downstream, _ := io.ReadAll(r.Body)
+		read <- string(downstream)
+	})
+	ts := httptest.NewServer(hf)
+	defer ts.Close()
+
+	if _, err := http.Post(ts.URL, "application/json", strings.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-read; got != body {
+		t.Errorf("Expected the handler to read the whole body '%s' but read '%s'", body, got)
+	}
+	if got, _ := (<-captured).([]byte); string(got) != body[:10] {
+		t.Errorf("Expected the first 10 bytes of the body to be captured but got '%s'", got)
+	}
+
+	if _, err := http.Post(ts.URL, "image/png; charset=binary", strings.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-read; got != body {
+		t.Errorf("Expected the handler to read the whole body '%s' but read '%s'", body, got)
+	}
+	if got := <-captured; got != nil {
+		t.Errorf("Expected an image body not to be captured but got '%s'", got)
+	}
+}
+
+func TestUserFromRequest(t *testing.T) {
+	notifier := New(Configuration{APIKey: testAPIKey, Logger: log.New(io.Discard, "", 0), UserFromRequest: func(r *http.Request) User {
+		if id := r.Header.Get("X-User-Id"); id != "" {
+			return User{Id: id, Email: id + "@example.com"}
+		}
+		return User{}
+	}})
+
+	authenticated := httptest.NewRequest("GET", "/account", nil)
+	authenticated.Header.Set("X-User-Id", "ada")
+	ctx := AttachRequestData(context.Background(), authenticated)
+	event, _ := newEvent([]interface{}{fmt.Errorf("oops"), ctx}, notifier)
+	if exp := (User{Id: "ada", Email: "ada@example.com"}); event.User == nil || *event.User != exp {
+		t.Errorf("Expected the user to be derived from the request as '%v' but was '%v'", exp, event.User)
+	}
+
+	anonymous := httptest.NewRequest("GET", "/account", nil)
+	event, _ = newEvent([]interface{}{fmt.Errorf("oops"), anonymous}, notifier)
+	if exp := (User{Id: "192.0.2.1"}); event.User == nil || *event.User != exp {
+		t.Errorf("Expected the user to default to the IP address without credentials but was '%v'", event.User)
+	}
+
+	event, _ = newEvent([]interface{}{fmt.Errorf("oops"), authenticated, User{Id: "grace"}}, notifier)
+	if event.User == nil || event.User.Id != "grace" {
+		t.Errorf("Expected an explicit user to take precedence but was '%v'", event.User)
+	}
+
+	panicking := New(Configuration{APIKey: testAPIKey, Logger: log.New(io.Discard, "", 0), UserFromRequest: func(r *http.Request) User {
+		return User{Id: strings.Fields(r.Header.Get("Authorization"))[1]}
+	}})
+	event, _ = newEvent([]interface{}{fmt.Errorf("oops"), anonymous}, panicking)
+	if exp := (User{Id: "192.0.2.1"}); event.User == nil || *event.User != exp {
+		t.Errorf("Expected a panicking UserFromRequest to be ignored but the user was '%v'", event.User)
+	}
+}
@@ -0,0 +1,118 @@
+package bugsnag
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RoundTripperConfig chooses which failed requests are reported by a
+// RoundTripper.
+type RoundTripperConfig struct {
+	// Hosts are the hosts which failed requests are reported for, e.g.
+	// "api.example.com". Requests to every host are reported when empty.
+	Hosts []string
+	// ReportStatus decides whether a response with the status code is
+	// reported. This defaults to reporting 5xx responses.
+	ReportStatus func(status int) bool
+}
+
+func (c RoundTripperConfig) reportsHost(host string) bool {
+	if len(c.Hosts) == 0 {
+		return true
+	}
+	for _, h := range c.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c RoundTripperConfig) reportsStatus(status int) bool {
+	if c.ReportStatus != nil {
+		return c.ReportStatus(status)
+	}
+	return status >= 500
+}
+
+// roundTripper reports the failed requests sent by the next RoundTripper.
+type roundTripper struct {
+	next     http.RoundTripper
+	config   RoundTripperConfig
+	notifier *Notifier
+}
+
+// RoundTripper wraps the next http.RoundTripper, or http.DefaultTransport if
+// it's nil, so that requests made by an http.Client which fail with an error
+// or a 5xx response are reported to Bugsnag. The method, URL, status and
+// duration of the request are sent in the "outboundRequest" tab, with any
+// user info removed from the URL and the values of its query parameters
+// filtered out, and failures are grouped by the host they were sent to. The rawData is used in
+// the same way as for Handler, e.g. to pass a Configuration.
+//
+//	client := &http.Client{Transport: bugsnag.RoundTripper(nil, bugsnag.RoundTripperConfig{})}
+//
+// The response is returned untouched, so streaming its body is unaffected.
+// Requests to the Bugsnag endpoints are never reported, but the wrapped
+// transport shouldn't be used as the Configuration.Transport.
+func RoundTripper(next http.RoundTripper, config RoundTripperConfig, rawData ...interface{}) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &roundTripper{next: next, config: config, notifier: New(rawData...)}
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if !t.config.reportsHost(req.URL.Hostname()) || t.isBugsnagRequest(req) {
+		return resp, err
+	}
+	request := OutboundRequest{Method: req.Method, URL: redactURL(req.URL), Duration: time.Since(start)}
+	target := req.Method + " " + req.URL.Host + req.URL.Path
+	groupByHost := func(event *Event) {
+		if event.GroupingHash == "" {
+			event.GroupingHash = "outboundRequest:" + req.URL.Host
+		}
+	}
+	if err != nil {
+		t.notifier.Notify(fmt.Errorf("%s: %v", target, err), req.Context(), request, groupByHost)
+	} else if t.config.reportsStatus(resp.StatusCode) {
+		request.Status = resp.StatusCode
+		t.notifier.Notify(fmt.Errorf("%s: %s", target, resp.Status), req.Context(), request, groupByHost)
+	}
+	return resp, err
+}
+
+// isBugsnagRequest checks whether the request is being sent to Bugsnag, so
+// that failures to report errors are not themselves reported.
+func (t *roundTripper) isBugsnagRequest(req *http.Request) bool {
+	url := req.URL.String()
+	endpoints := cloneConfig(t.notifier.Config).Endpoints
+	for _, endpoint := range []string{endpoints.Notify, endpoints.Sessions, endpoints.Build} {
+		if endpoint != "" && strings.HasPrefix(url, endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL returns the URL without any user info, and with the values of
+// its query parameters replaced by "[FILTERED]", as they often hold tokens
+// and credentials whose names don't match the ParamsFilters.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	if query := redacted.Query(); len(query) > 0 {
+		for _, values := range query {
+			for i := range values {
+				values[i] = "BUGSNAG_URL_FILTERED"
+			}
+		}
+		redacted.RawQuery = strings.Replace(query.Encode(), "BUGSNAG_URL_FILTERED", "[FILTERED]", -1)
+	}
+	return redacted.String()
+}
@@ -0,0 +1,90 @@
+package bugsnag
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	simplejson "github.com/bitly/go-simplejson"
+)
+
+func TestRoundTripper(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	reports := make(chan []byte, 10)
+	bugsnagServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		reports <- body
+	}))
+	defer bugsnagServer.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("try again later"))
+	}))
+	defer failing.Close()
+
+	config := generateSampleConfig(bugsnagServer.URL)
+	config.NotifyReleaseStages = []string{"test"}
+	config.Synchronous = true
+	config.ParamsFilters = []string{"token"}
+	client := &http.Client{Transport: RoundTripper(nil, RoundTripperConfig{}, config)}
+
+	resp, err := client.Get(strings.Replace(failing.URL, "http://", "http://user:hunter2@", 1) + "/users?token=secret&page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "try again later" {
+		t.Errorf("Expected the response body to be untouched but was '%s'", body)
+	}
+	json, _ := simplejson.NewJson(<-reports)
+	event := json.Get("events").GetIndex(0)
+	tab := event.GetPath("metaData", "outboundRequest")
+	if got, exp := tab.Get("url").MustString(), failing.URL+"/users?page=[FILTERED]&token=[FILTERED]"; got != exp {
+		t.Errorf("Expected the URL to be reported as '%s', without credentials or query values, but was '%s'", exp, got)
+	}
+	if got := tab.Get("status").MustInt(); got != http.StatusServiceUnavailable {
+		t.Errorf("Expected the status to be reported but was %d", got)
+	}
+	if _, ok := tab.CheckGet("durationMs"); !ok {
+		t.Errorf("Expected the duration to be reported")
+	}
+	if got := event.Get("exceptions").GetIndex(0).Get("message").MustString(); got != "GET "+strings.TrimPrefix(failing.URL, "http://")+"/users: 503 Service Unavailable" {
+		t.Errorf("Expected the message to describe the failed request but was '%s'", got)
+	}
+
+	if resp, err := client.Get(failing.URL + "/missing"); err != nil {
+		t.Fatal(err)
+	} else {
+		resp.Body.Close()
+	}
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	if _, err := client.Get(closed.URL); err == nil {
+		t.Fatal("Expected the request to a closed server to fail")
+	}
+	json, _ = simplejson.NewJson(<-reports)
+	tab = json.Get("events").GetIndex(0).GetPath("metaData", "outboundRequest")
+	if got := tab.Get("url").MustString(); got != closed.URL {
+		t.Errorf("Expected the transport error to be reported, not the 404, but got the request to '%s'", got)
+	}
+
+	filtered := &http.Client{Transport: RoundTripper(nil, RoundTripperConfig{Hosts: []string{"api.example.com"}}, config)}
+	if resp, err := filtered.Get(failing.URL); err != nil {
+		t.Fatal(err)
+	} else {
+		resp.Body.Close()
+	}
+	select {
+	case <-reports:
+		t.Errorf("Expected requests to other hosts not to be reported")
+	default:
+	}
+}
@@ -31,6 +31,9 @@ type SessionTrackingConfiguration struct {
 	Endpoint string
 	// Version defines the current version of the notifier.
 	Version string
+	// UserAgent is sent as the User-Agent header of requests to the session
+	// server. This defaults to "bugsnag-go/" followed by the Version.
+	UserAgent string
 
 	// ReleaseStage defines the release stage, e.g. "production" or "staging",
 	// that this session occurred in. The release stage, in combination with
@@ -42,8 +45,21 @@ type SessionTrackingConfiguration struct {
 	AppType string
 	// AppVersion defines the version of the application.
 	AppVersion string
+	// AppBuildID identifies the build of the application, and is sent as the
+	// app's buildUUID.
+	AppBuildID string
+	// GitCommit is the revision of the source the application was built from.
+	GitCommit string
+	// DeployID identifies the deploy which is running.
+	DeployID string
 	// Transport defines the http.RoundTripper to be used for managing HTTP requests.
 	Transport http.RoundTripper
+	// Timeout bounds how long each request to the session server may take.
+	// No timeout is applied when zero.
+	Timeout time.Duration
+	// Clock returns the current time, which is used as the start time of new
+	// sessions. This defaults to time.Now and is intended for use in tests.
+	Clock func() time.Time
 
 	// The release stages to notify about sessions in. If you set this then
 	// bugsnag-go will only send sessions to Bugsnag if the release stage
@@ -56,6 +72,17 @@ type SessionTrackingConfiguration struct {
 	Logger interface {
 		Printf(format string, v ...interface{})
 	}
+	// OnError is called when sessions can't be recorded in the Store or
+	// sent to the session server, in addition to the failure being logged.
+	OnError func(err error)
+	// OnShutdown is called instead of flushing the sessions when the process
+	// receives SIGTERM or SIGINT, before the signal is raised again, so that
+	// other work can be finished before the process exits as well.
+	OnShutdown func()
+	// Store holds the number of sessions started until they are published.
+	// This defaults to a store in memory. See Store for using a store shared
+	// between processes.
+	Store Store
 
 	mutex sync.Mutex
 }
@@ -77,6 +104,9 @@ func (c *SessionTrackingConfiguration) Update(config *SessionTrackingConfigurati
 	if config.Version != "" {
 		c.Version = config.Version
 	}
+	if config.UserAgent != "" {
+		c.UserAgent = config.UserAgent
+	}
 	if config.ReleaseStage != "" {
 		c.ReleaseStage = config.ReleaseStage
 	}
@@ -89,12 +119,36 @@ func (c *SessionTrackingConfiguration) Update(config *SessionTrackingConfigurati
 	if config.AppVersion != "" {
 		c.AppVersion = config.AppVersion
 	}
+	if config.AppBuildID != "" {
+		c.AppBuildID = config.AppBuildID
+	}
+	if config.GitCommit != "" {
+		c.GitCommit = config.GitCommit
+	}
+	if config.DeployID != "" {
+		c.DeployID = config.DeployID
+	}
 	if config.Transport != nil {
 		c.Transport = config.Transport
 	}
+	if config.Timeout != 0 {
+		c.Timeout = config.Timeout
+	}
+	if config.Clock != nil {
+		c.Clock = config.Clock
+	}
 	if config.Logger != nil {
 		c.Logger = config.Logger
 	}
+	if config.OnError != nil {
+		c.OnError = config.OnError
+	}
+	if config.OnShutdown != nil {
+		c.OnShutdown = config.OnShutdown
+	}
+	if config.Store != nil {
+		c.Store = config.Store
+	}
 	if config.NotifyReleaseStages != nil {
 		c.NotifyReleaseStages = config.NotifyReleaseStages
 	}
@@ -103,6 +157,30 @@ func (c *SessionTrackingConfiguration) Update(config *SessionTrackingConfigurati
 	}
 }
 
+func (c *SessionTrackingConfiguration) now() time.Time {
+	c.mutex.Lock()
+	clock := c.Clock
+	c.mutex.Unlock()
+	if clock != nil {
+		return clock()
+	}
+	return time.Now()
+}
+
+// shutdownHook returns the OnShutdown callback, if one is configured.
+func (c *SessionTrackingConfiguration) shutdownHook() func() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.OnShutdown
+}
+
+func (c *SessionTrackingConfiguration) userAgent() string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	return "bugsnag-go/" + c.Version
+}
+
 func (c *SessionTrackingConfiguration) logf(fmt string, args ...interface{}) {
 	if c != nil && c.Logger != nil {
 		c.Logger.Printf(fmt, args...)
@@ -111,6 +189,15 @@ func (c *SessionTrackingConfiguration) logf(fmt string, args ...interface{}) {
 	}
 }
 
+// sessionsFailed logs a failure to record or send sessions and passes it to
+// the OnError callback, if one is configured.
+func (c *SessionTrackingConfiguration) sessionsFailed(err error) {
+	c.logf("%v", err)
+	if c != nil && c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
 // IsAutoCaptureSessions identifies whether or not the notifier should
 // automatically capture sessions as requests come in. It's a convenience
 // wrapper that allows automatic session capturing to be enabled by default.
@@ -24,7 +24,11 @@ func TestConfigDoesNotChangeGivenBlankValues(t *testing.T) {
 		{"Hostname", exp.Hostname, c.Hostname},
 		{"AppType", exp.AppType, c.AppType},
 		{"AppVersion", exp.AppVersion, c.AppVersion},
+		{"AppBuildID", exp.AppBuildID, c.AppBuildID},
+		{"GitCommit", exp.GitCommit, c.GitCommit},
+		{"DeployID", exp.DeployID, c.DeployID},
 		{"Transport", exp.Transport, c.Transport},
+		{"Timeout", exp.Timeout, c.Timeout},
 		{"NotifyReleaseStages", exp.NotifyReleaseStages, c.NotifyReleaseStages},
 	}
 	for _, tc := range tt {
@@ -45,6 +49,10 @@ func TestConfigUpdatesGivenNonDefaultValues(t *testing.T) {
 		Hostname:            "Brian's Surface",
 		AppType:             "Revel API",
 		AppVersion:          "6.3.9",
+		AppBuildID:          "ci-1234",
+		GitCommit:           "4a1b2c3",
+		DeployID:            "deploy-7",
+		Timeout:             5 * time.Second,
 		NotifyReleaseStages: []string{"staging", "production"},
 	}
 	c.Update(&exp)
@@ -61,6 +69,10 @@ func TestConfigUpdatesGivenNonDefaultValues(t *testing.T) {
 		{"Hostname", exp.Hostname, c.Hostname},
 		{"AppType", exp.AppType, c.AppType},
 		{"AppVersion", exp.AppVersion, c.AppVersion},
+		{"AppBuildID", exp.AppBuildID, c.AppBuildID},
+		{"GitCommit", exp.GitCommit, c.GitCommit},
+		{"DeployID", exp.DeployID, c.DeployID},
+		{"Timeout", exp.Timeout, c.Timeout},
 		{"NotifyReleaseStages", exp.NotifyReleaseStages, c.NotifyReleaseStages},
 	}
 	for _, tc := range tt {
@@ -80,7 +92,11 @@ func testConfig() SessionTrackingConfiguration {
 		Hostname:            "Russ's MacbookPro",
 		AppType:             "Gin API",
 		AppVersion:          "5.2.8",
+		AppBuildID:          "ci-1000",
+		GitCommit:           "0d9e8f7",
+		DeployID:            "deploy-1",
 		NotifyReleaseStages: []string{"staging", "production"},
 		Transport:           http.DefaultTransport,
+		Timeout:             10 * time.Second,
 	}
 }
@@ -14,11 +14,15 @@ type notifierPayload struct {
 	Version string `json:"version"`
 }
 
-// appPayload defines the .app subobject of the payload
+// appPayload defines the .app subobject of the payload, matching the app
+// identity sent with events so that stability can be broken down by release
 type appPayload struct {
 	Type         string `json:"type,omitempty"`
 	ReleaseStage string `json:"releaseStage,omitempty"`
 	Version      string `json:"version,omitempty"`
+	BuildUUID    string `json:"buildUUID,omitempty"`
+	GitCommit    string `json:"gitCommit,omitempty"`
+	DeployID     string `json:"deployId,omitempty"`
 }
 
 // devicePayload defines the .device subobject of the payload
@@ -43,8 +47,8 @@ type sessionPayload struct {
 	SessionCounts []sessionCountsPayload `json:"sessionCounts"`
 }
 
-// makeSessionPayload creates a sessionPayload based off of the given sessions and config
-func makeSessionPayload(sessions []*Session, config *SessionTrackingConfiguration) *sessionPayload {
+// makeSessionPayload creates a sessionPayload based off of the given session counts and config
+func makeSessionPayload(counts []SessionCount, config *SessionTrackingConfiguration) *sessionPayload {
 	releaseStage := config.ReleaseStage
 	if releaseStage == "" {
 		releaseStage = "production"
@@ -54,6 +58,14 @@ func makeSessionPayload(sessions []*Session, config *SessionTrackingConfiguratio
 		hostname = device.GetHostname()
 	}
 
+	sessionCounts := make([]sessionCountsPayload, len(counts))
+	for i, count := range counts {
+		sessionCounts[i] = sessionCountsPayload{
+			StartedAt:       count.StartedAt.UTC().Format(time.RFC3339),
+			SessionsStarted: count.Count,
+		}
+	}
+
 	return &sessionPayload{
 		Notifier: &notifierPayload{
 			Name:    "Bugsnag Go",
@@ -64,18 +76,15 @@ func makeSessionPayload(sessions []*Session, config *SessionTrackingConfiguratio
 			Type:         config.AppType,
 			Version:      config.AppVersion,
 			ReleaseStage: releaseStage,
+			BuildUUID:    config.AppBuildID,
+			GitCommit:    config.GitCommit,
+			DeployID:     config.DeployID,
 		},
 		Device: &devicePayload{
 			OsName:          runtime.GOOS,
 			Hostname:        hostname,
 			RuntimeVersions: device.GetRuntimeVersions(),
 		},
-		SessionCounts: []sessionCountsPayload{
-			{
-				//This timestamp assumes that we're sending these off once a minute
-				StartedAt:       sessions[0].StartedAt.UTC().Format(time.RFC3339),
-				SessionsStarted: len(sessions),
-			},
-		},
+		SessionCounts: sessionCounts,
 	}
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/bugsnag/bugsnag-go/v2/headers"
@@ -13,8 +15,23 @@ import (
 // being sent to the session server.
 const sessionPayloadVersion = "1.0"
 
+// maxDrainedBody is the most of a response which is read so that its
+// connection can be reused.
+const maxDrainedBody = 4096
+
 type sessionPublisher interface {
-	publish(sessions []*Session) error
+	publish(counts []SessionCount) error
+}
+
+// transientError is a failure to send sessions which may succeed if they are
+// sent again, because of a network error or the session server being
+// unavailable.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
 }
 
 type httpClient interface {
@@ -26,55 +43,76 @@ type publisher struct {
 	client httpClient
 }
 
-// publish builds a payload from the given sessions and publishes them to the
-// session server. Returns any errors that happened as part of publishing.
-func (p *publisher) publish(sessions []*Session) error {
+// publish builds a payload from the given session counts and publishes them
+// to the session server. Returns any errors that happened as part of
+// publishing.
+func (p *publisher) publish(counts []SessionCount) error {
+	req, err := p.newRequest(counts)
+	if err != nil || req == nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return &transientError{fmt.Errorf("bugsnag/sessions/publisher.publish unable to deliver session: %v", err)}
+	}
+	defer func(res *http.Response) {
+		// Read the rest of the response so that the connection can be reused
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxDrainedBody))
+		if err := res.Body.Close(); err != nil {
+			p.config.logf("%v", err)
+		}
+	}(res)
+	if res.StatusCode != 202 {
+		err := fmt.Errorf("bugsnag/session.publish expected 202 response status, got HTTP %s", res.Status)
+		if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests {
+			return &transientError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// newRequest builds the request which publishes the given session counts,
+// or returns nil if they shouldn't be sent. The configuration is locked while
+// the request is built, so that it's consistent if it's updated at the same
+// time, but not while the request is sent, which would stop sessions being
+// started until the session server responded.
+func (p *publisher) newRequest(counts []SessionCount) (*http.Request, error) {
+	p.config.mutex.Lock()
+	defer p.config.mutex.Unlock()
 	if p.config.Endpoint == "" {
 		// Session tracking is disabled, likely because the notify endpoint was
 		// changed without changing the sessions endpoint
 		// We've already logged a warning in this case, so no need to spam the
 		// log every minute
-		return nil
+		return nil, nil
 	}
 	if apiKey := p.config.APIKey; len(apiKey) != 32 {
-		return fmt.Errorf("bugsnag/sessions/publisher.publish invalid API key: '%s'", apiKey)
+		return nil, fmt.Errorf("bugsnag/sessions/publisher.publish invalid API key: '%s'", apiKey)
 	}
 	nrs, rs := p.config.NotifyReleaseStages, p.config.ReleaseStage
 	if rs != "" && (nrs != nil && !contains(nrs, rs)) {
 		// Always send sessions if the release stage is not set, but don't send any
 		// sessions when notify release stages don't match the current release stage
-		return nil
+		return nil, nil
 	}
-	if len(sessions) == 0 {
-		return fmt.Errorf("bugsnag/sessions/publisher.publish requested publication of 0")
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("bugsnag/sessions/publisher.publish requested publication of 0")
 	}
-	p.config.mutex.Lock()
-	defer p.config.mutex.Unlock()
-	payload := makeSessionPayload(sessions, p.config)
+	payload := makeSessionPayload(counts, p.config)
 	buf, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("bugsnag/sessions/publisher.publish unable to marshal json: %v", err)
+		return nil, fmt.Errorf("bugsnag/sessions/publisher.publish unable to marshal json: %v", err)
 	}
 	req, err := http.NewRequest("POST", p.config.Endpoint, bytes.NewBuffer(buf))
 	if err != nil {
-		return fmt.Errorf("bugsnag/sessions/publisher.publish unable to create request: %v", err)
+		return nil, fmt.Errorf("bugsnag/sessions/publisher.publish unable to create request: %v", err)
 	}
 	for k, v := range headers.PrefixedHeaders(p.config.APIKey, sessionPayloadVersion) {
 		req.Header.Add(k, v)
 	}
-	res, err := p.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("bugsnag/sessions/publisher.publish unable to deliver session: %v", err)
-	}
-	defer func(res *http.Response) {
-		if err := res.Body.Close(); err != nil {
-			p.config.logf("%v", err)
-		}
-	}(res)
-	if res.StatusCode != 202 {
-		return fmt.Errorf("bugsnag/session.publish expected 202 response status, got HTTP %s", res.Status)
-	}
-	return nil
+	req.Header.Set("User-Agent", p.config.userAgent())
+	return req, nil
 }
 
 func contains(coll []string, e string) bool {
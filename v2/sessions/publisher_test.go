@@ -11,7 +11,6 @@ import (
 	"time"
 
 	simplejson "github.com/bitly/go-simplejson"
-	uuid "github.com/google/uuid"
 )
 
 const (
@@ -32,7 +31,7 @@ func (nopCloser) Close() error { return nil }
 
 func (c *testHTTPClient) Do(r *http.Request) (*http.Response, error) {
 	c.reqs = append(c.reqs, r)
-	return &http.Response{Body: nopCloser{}, StatusCode: 202}, nil
+	return &http.Response{Body: nopCloser{strings.NewReader("")}, StatusCode: 202}, nil
 }
 
 func get(j *simplejson.Json, path string) *simplejson.Json {
@@ -94,9 +93,12 @@ func TestSendsCorrectPayloadForSmallConfig(t *testing.T) {
 	if got, exp := getString(sessionCounts, "startedAt"), earliestTime; got != exp {
 		t.Errorf("Expected sessionCounts[0].startedAt to be '%s' but was '%s'", exp, got)
 	}
-	if got, exp := getInt(sessionCounts, "sessionsStarted"), len(sessions); got != exp {
+	if got, exp := getInt(sessionCounts, "sessionsStarted"), sessions[0].Count; got != exp {
 		t.Errorf("Expected sessionCounts[0].sessionsStarted to be %d but was %d", exp, got)
 	}
+	if got, exp := getInt(getIndex(root, "sessionCounts", 1), "sessionsStarted"), sessions[1].Count; got != exp {
+		t.Errorf("Expected sessionCounts[1].sessionsStarted to be %d but was %d", exp, got)
+	}
 }
 
 func TestSendsCorrectPayloadForBigConfig(t *testing.T) {
@@ -114,6 +116,9 @@ func TestSendsCorrectPayloadForBigConfig(t *testing.T) {
 	}
 	req := testClient.reqs[0]
 	assertCorrectHeaders(t, req)
+	if got, exp := req.Header.Get("User-Agent"), "bugsnag-go/2.3.4-alpha"; got != exp {
+		t.Errorf("Expected User-Agent to be '%s' but was '%s'", exp, got)
+	}
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		t.Fatal(err)
@@ -130,6 +135,9 @@ func TestSendsCorrectPayloadForBigConfig(t *testing.T) {
 		"app.type":         "gin",
 		"app.releaseStage": "development",
 		"app.version":      "1.2.3-beta",
+		"app.buildUUID":    "ci-4567",
+		"app.gitCommit":    "9f3c2e1",
+		"app.deployId":     "deploy-89",
 		"device.osName":    runtime.GOOS,
 		"device.hostname":  "gce-1234-us-west-1",
 	} {
@@ -143,7 +151,7 @@ func TestSendsCorrectPayloadForBigConfig(t *testing.T) {
 	if got, exp := getString(sessionCounts, "startedAt"), earliestTime; got != exp {
 		t.Errorf("Expected sessionCounts[0].startedAt to be '%s' but was '%s'", exp, got)
 	}
-	if got, exp := getInt(sessionCounts, "sessionsStarted"), len(sessions); got != exp {
+	if got, exp := getInt(sessionCounts, "sessionsStarted"), sessions[0].Count; got != exp {
 		t.Errorf("Expected sessionCounts[0].sessionsStarted to be %d but was %d", exp, got)
 	}
 }
@@ -203,11 +211,53 @@ func TestReleaseStageNotSetSendsSessionsRegardlessOfNotifyReleaseStages(t *testi
 	}
 }
 
+// blockingHTTPClient sends on sent when a request is made, and responds once
+// release is closed.
+type blockingHTTPClient struct {
+	sent    chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	close(c.sent)
+	<-c.release
+	return &http.Response{Body: nopCloser{strings.NewReader("")}, StatusCode: 202}, nil
+}
+
+func TestConfigurationIsNotLockedWhilePublishing(t *testing.T) {
+	sessions, _ := makeSessions()
+	client := &blockingHTTPClient{sent: make(chan struct{}), release: make(chan struct{})}
+	config := makeHeavyConfig()
+	publisher := publisher{config: config, client: client}
+
+	published := make(chan error)
+	go func() { published <- publisher.publish(sessions) }()
+	<-client.sent
+
+	updated := make(chan struct{})
+	go func() {
+		config.Update(&SessionTrackingConfiguration{AppVersion: "1.2.4"})
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the configuration to be updated while sessions were being sent")
+	}
+	close(client.release)
+	if err := <-published; err != nil {
+		t.Error(err)
+	}
+}
+
 func makeHeavyConfig() *SessionTrackingConfiguration {
 	return &SessionTrackingConfiguration{
 		AppType:             "gin",
 		APIKey:              testAPIKey,
 		AppVersion:          "1.2.3-beta",
+		AppBuildID:          "ci-4567",
+		GitCommit:           "9f3c2e1",
+		DeployID:            "deploy-89",
 		Version:             "2.3.4-alpha",
 		Endpoint:            sessionEndpoint,
 		Transport:           http.DefaultTransport,
@@ -217,12 +267,11 @@ func makeHeavyConfig() *SessionTrackingConfiguration {
 	}
 }
 
-func makeSessions() ([]*Session, string) {
+func makeSessions() ([]SessionCount, string) {
 	earliestTime := time.Now().Add(-6 * time.Minute)
-	return []*Session{
-		{StartedAt: earliestTime, ID: uuid.New()},
-		{StartedAt: earliestTime.Add(2 * time.Minute), ID: uuid.New()},
-		{StartedAt: earliestTime.Add(4 * time.Minute), ID: uuid.New()},
+	return []SessionCount{
+		{StartedAt: earliestTime, Count: 3},
+		{StartedAt: earliestTime.Add(2 * time.Minute), Count: 1},
 	}, earliestTime.UTC().Format(time.RFC3339)
 }
 
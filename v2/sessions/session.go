@@ -20,9 +20,9 @@ type Session struct {
 	EventCounts *EventCounts
 }
 
-func newSession() *Session {
+func newSession(startedAt time.Time) *Session {
 	return &Session{
-		StartedAt:   time.Now(),
+		StartedAt:   startedAt,
 		ID:          uuid.New(),
 		EventCounts: &EventCounts{},
 	}
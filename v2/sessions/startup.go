@@ -14,15 +14,15 @@ import (
 // caught by panicwrap.
 func SendStartupSession(config *SessionTrackingConfiguration) context.Context {
 	ctx := context.Background()
-	session := newSession()
+	session := newSession(config.now())
 	if !config.IsAutoCaptureSessions() || isApplicationProcess() {
 		return ctx
 	}
 	publisher := &publisher{
 		config: config,
-		client: &http.Client{Transport: config.Transport},
+		client: &http.Client{Transport: config.Transport, Timeout: config.Timeout},
 	}
-	go publisher.publish([]*Session{session})
+	go publisher.publish([]SessionCount{{StartedAt: session.StartedAt, Count: 1}})
 	return context.WithValue(ctx, contextSessionKey, session)
 }
 
@@ -0,0 +1,75 @@
+package sessions
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionCount is the number of sessions started in the minute beginning at
+// StartedAt.
+type SessionCount struct {
+	StartedAt time.Time
+	Count     int
+}
+
+// Store holds the number of sessions started until they are published to
+// Bugsnag. The default store keeps the counts in memory, so they are lost if
+// the process exits before the next publish. A store shared between
+// processes, e.g. in Redis, lets short-lived instances record sessions which
+// are then published by whichever instance flushes the store next.
+//
+// A Store must be safe for concurrent use. Flush must take the counts
+// atomically, so that each session is published exactly once; a session
+// recorded while a flush is in progress may be returned by that flush or the
+// next. Counts which are flushed but can't be published, because the session
+// server is unavailable, are kept in the memory of the process which flushed
+// them and sent along with its next flush, up to MaxPublishAttempts.
+//
+// Sessions are recorded in the Store in the background, so that a slow Store
+// doesn't delay starting a session. Sessions which can't be recorded, because
+// Increment fails or too many sessions are waiting, are counted in the memory
+// of the process which started them instead.
+type Store interface {
+	// Increment records that a session started at the given time.
+	Increment(startedAt time.Time) error
+	// Flush removes and returns the counts recorded since the last Flush.
+	Flush() ([]SessionCount, error)
+}
+
+// NewMemoryStore creates a Store which keeps the counts in memory, which is
+// used unless SessionTrackingConfiguration.Store is set.
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+type memoryStore struct {
+	mutex  sync.Mutex
+	counts map[time.Time]int
+}
+
+func (m *memoryStore) Increment(startedAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.counts == nil {
+		m.counts = make(map[time.Time]int)
+	}
+	m.counts[startedAt.UTC().Truncate(time.Minute)]++
+	return nil
+}
+
+func (m *memoryStore) Flush() ([]SessionCount, error) {
+	m.mutex.Lock()
+	counts := m.counts
+	m.counts = nil
+	m.mutex.Unlock()
+
+	flushed := make([]SessionCount, 0, len(counts))
+	for startedAt, count := range counts {
+		flushed = append(flushed, SessionCount{StartedAt: startedAt, Count: count})
+	}
+	sort.Slice(flushed, func(i, j int) bool {
+		return flushed[i].StartedAt.Before(flushed[j].StartedAt)
+	})
+	return flushed, nil
+}
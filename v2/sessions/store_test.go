@@ -0,0 +1,145 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreCountsByMinute(t *testing.T) {
+	minute := time.Date(2020, time.March, 4, 5, 6, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	for _, startedAt := range []time.Time{
+		minute.Add(time.Minute + time.Second),
+		minute.Add(10 * time.Second),
+		minute.Add(59 * time.Second),
+	} {
+		if err := store.Increment(startedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts, err := store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []SessionCount{{StartedAt: minute, Count: 2}, {StartedAt: minute.Add(time.Minute), Count: 1}}
+	if !reflect.DeepEqual(counts, exp) {
+		t.Errorf("Expected counts to be '%v' but were '%v'", exp, counts)
+	}
+	if counts, _ := store.Flush(); len(counts) != 0 {
+		t.Errorf("Expected the store to be empty after flushing but had '%v'", counts)
+	}
+}
+
+// sharedStore is a Store which is shared between several trackers, as a
+// Redis-backed store would be shared between processes.
+type sharedStore struct {
+	mutex      sync.Mutex
+	increments int
+	flushes    int
+}
+
+func (s *sharedStore) Increment(startedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.increments++
+	return nil
+}
+
+func (s *sharedStore) Flush() ([]SessionCount, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.flushes++
+	if s.increments == 0 {
+		return nil, nil
+	}
+	counts := []SessionCount{{StartedAt: time.Date(2020, time.March, 4, 5, 6, 0, 0, time.UTC), Count: s.increments}}
+	s.increments = 0
+	return counts, nil
+}
+
+func TestTrackersPublishFromSharedStore(t *testing.T) {
+	store := &sharedStore{}
+	publisher := &testPublisher{}
+	newTracker := func() *sessionTracker {
+		return &sessionTracker{
+			config:    &SessionTrackingConfiguration{Store: store},
+			memory:    NewMemoryStore(),
+			publisher: publisher,
+		}
+	}
+	first, second := newTracker(), newTracker()
+	first.appendSession(newSession(time.Now()))
+	second.appendSession(newSession(time.Now()))
+	second.appendSession(newSession(time.Now()))
+
+	// The first tracker's process exits without publishing, and the second
+	// publishes the sessions started by both
+	second.FlushSessions()
+	exp := [][]SessionCount{{{StartedAt: time.Date(2020, time.March, 4, 5, 6, 0, 0, time.UTC), Count: 3}}}
+	if !reflect.DeepEqual(publisher.sessionsReceived, exp) {
+		t.Errorf("Expected '%v' to be published but was '%v'", exp, publisher.sessionsReceived)
+	}
+
+	first.FlushSessions()
+	if len(publisher.sessionsReceived) != 1 {
+		t.Errorf("Expected nothing more to be published once the store was flushed but got '%v'", publisher.sessionsReceived)
+	}
+}
+
+// blockingStore is a Store whose Increment fails once release is closed, as a
+// store would if it timed out.
+type blockingStore struct {
+	release chan struct{}
+}
+
+func (s *blockingStore) Increment(startedAt time.Time) error {
+	<-s.release
+	return fmt.Errorf("store unavailable")
+}
+
+func (s *blockingStore) Flush() ([]SessionCount, error) {
+	return nil, nil
+}
+
+func TestStartSessionDoesNotWaitForStore(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{})}
+	publisher := &testPublisher{}
+	st := &sessionTracker{
+		sessionChannel: make(chan *Session, sessionQueueSize),
+		config:         &SessionTrackingConfiguration{Store: store, Logger: log.New(ioutil.Discard, "", 0)},
+		memory:         NewMemoryStore(),
+		publisher:      publisher,
+	}
+
+	started := make(chan struct{})
+	go func() {
+		for i := 0; i < sessionQueueSize+2; i++ {
+			st.StartSession(context.Background())
+		}
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Expected sessions to start without waiting for the store")
+	}
+
+	close(store.release)
+	st.FlushSessions()
+	sessions := 0
+	for _, counts := range publisher.sessionsReceived {
+		for _, count := range counts {
+			sessions += count.Count
+		}
+	}
+	if exp := sessionQueueSize + 2; sessions != exp {
+		t.Errorf("Expected the %d sessions the store failed to record to be published from memory but got %d", exp, sessions)
+	}
+}
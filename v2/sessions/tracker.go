@@ -2,20 +2,41 @@ package sessions
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
 )
 
+// MaxPublishAttempts is how many times sessions are sent to the session
+// server before they are dropped, when sending them fails because of a
+// network error or a response indicating the server is unavailable.
+var MaxPublishAttempts = 5
+
+// MaxRetainedSessionCounts is the most counts of sessions, each for the
+// sessions started in one minute, which are kept to be sent again while the
+// session server is unavailable. The oldest counts are dropped beyond this.
+var MaxRetainedSessionCounts = 24 * 60
+
+// jitter returns a random number in [0, 1) to spread out the retries of
+// processes which failed to send sessions at the same time.
+var jitter = rand.Float64
+
 const (
 	//contextSessionKey is a unique key for accessing and setting Bugsnag
 	//session data on a context.Context object
 	contextSessionKey ctxKey = 1
 )
 
+// sessionQueueSize is how many sessions can wait to be recorded in a
+// configured Store before further sessions are counted in memory instead.
+const sessionQueueSize = 1024
+
 // ctxKey is a type alias that ensures uniqueness as a context.Context key
 type ctxKey int
 
@@ -27,22 +48,30 @@ type SessionTracker interface {
 }
 
 type sessionTracker struct {
+	// sessionChannel holds the sessions waiting to be recorded in a
+	// configured Store. processSessions returns if it's closed
 	sessionChannel chan *Session
-	sessions       []*Session
+	memory         Store
 	config         *SessionTrackingConfiguration
 	publisher      sessionPublisher
-	sessionsMutex  sync.Mutex
+
+	// retryMutex guards the counts which failed to be sent, and when they
+	// are next sent
+	retryMutex sync.Mutex
+	failed     []SessionCount
+	attempts   int
+	retryAt    time.Time
 }
 
 // NewSessionTracker creates a new SessionTracker based on the provided config,
 func NewSessionTracker(config *SessionTrackingConfiguration) SessionTracker {
 	publisher := publisher{
 		config: config,
-		client: &http.Client{Transport: config.Transport},
+		client: &http.Client{Transport: config.Transport, Timeout: config.Timeout},
 	}
 	st := sessionTracker{
-		sessionChannel: make(chan *Session, 1),
-		sessions:       []*Session{},
+		sessionChannel: make(chan *Session, sessionQueueSize),
+		memory:         NewMemoryStore(),
 		config:         config,
 		publisher:      &publisher,
 	}
@@ -54,15 +83,24 @@ func NewSessionTracker(config *SessionTrackingConfiguration) SessionTracker {
 // context and increments the event count of unhandled or handled events and
 // returns the session
 func IncrementEventCountAndGetSession(ctx context.Context, unhandled bool) *Session {
+	session := SessionFromContext(ctx)
+	if session != nil {
+		ec := session.EventCounts
+		if unhandled {
+			ec.Unhandled++
+		} else {
+			ec.Handled++
+		}
+	}
+	return session
+}
+
+// SessionFromContext returns the Bugsnag session of the given context, or nil
+// if no session has been started for it.
+func SessionFromContext(ctx context.Context) *Session {
 	if s := ctx.Value(contextSessionKey); s != nil {
 		if session, ok := s.(*Session); ok && !session.StartedAt.IsZero() {
 			// It is not just getting back a default value
-			ec := session.EventCounts
-			if unhandled {
-				ec.Unhandled++
-			} else {
-				ec.Handled++
-			}
 			return session
 		}
 	}
@@ -70,8 +108,8 @@ func IncrementEventCountAndGetSession(ctx context.Context, unhandled bool) *Sess
 }
 
 func (s *sessionTracker) StartSession(ctx context.Context) context.Context {
-	session := newSession()
-	s.sessionChannel <- session
+	session := newSession(s.config.now())
+	s.recordSession(session)
 	return context.WithValue(ctx, contextSessionKey, session)
 }
 
@@ -86,7 +124,11 @@ func (s *sessionTracker) processSessions() {
 	shutdown := shutdownSignals()
 	for {
 		select {
-		case session := <-s.sessionChannel:
+		case session, ok := <-s.sessionChannel:
+			if !ok {
+				signal.Stop(shutdown)
+				return
+			}
 			s.appendSession(session)
 		case <-tic:
 			s.publishCollectedSessions()
@@ -96,39 +138,171 @@ func (s *sessionTracker) processSessions() {
 	}
 }
 
+// store returns the configured Store, or the tracker's in-memory store if
+// none is configured.
+func (s *sessionTracker) store() Store {
+	s.config.mutex.Lock()
+	defer s.config.mutex.Unlock()
+	if s.config.Store != nil {
+		return s.config.Store
+	}
+	return s.memory
+}
+
+// recordSession counts a session which has started. Sessions are counted in
+// memory straight away, so that they're included if the sessions are flushed
+// straight after, e.g. at the end of a Lambda invocation. A configured Store
+// is updated in the background instead, so that starting a session never
+// waits for the Store or fails because of it. If too many sessions are
+// already waiting for the Store they're counted in memory, and published by
+// this process.
+func (s *sessionTracker) recordSession(session *Session) {
+	if s.store() == s.memory {
+		s.memory.Increment(session.StartedAt)
+		return
+	}
+	select {
+	case s.sessionChannel <- session:
+	default:
+		s.memory.Increment(session.StartedAt)
+	}
+}
+
+// appendSession records the session in the store. Sessions which can't be
+// recorded in a configured Store are counted in memory instead.
 func (s *sessionTracker) appendSession(session *Session) {
-	s.sessionsMutex.Lock()
-	defer s.sessionsMutex.Unlock()
+	store := s.store()
+	if err := store.Increment(session.StartedAt); err != nil {
+		s.config.sessionsFailed(err)
+		if store != s.memory {
+			s.memory.Increment(session.StartedAt)
+		}
+	}
+}
 
-	s.sessions = append(s.sessions, session)
+// takeCounts flushes the counts of sessions started since the last publish
+// from the store, merged with any counts which were kept in memory instead
+// and any counts which failed to be sent.
+func (s *sessionTracker) takeCounts() []SessionCount {
+	// Sessions waiting to be recorded are included in this flush
+	for waiting := true; waiting; {
+		select {
+		case session, ok := <-s.sessionChannel:
+			if !ok {
+				// The tracker is being stopped
+				waiting = false
+				break
+			}
+			s.appendSession(session)
+		default:
+			waiting = false
+		}
+	}
+	store := s.store()
+	counts, err := store.Flush()
+	if err != nil {
+		s.config.sessionsFailed(err)
+	}
+	if store != s.memory {
+		// The memory store never fails
+		kept, _ := s.memory.Flush()
+		counts = mergeCounts(kept, counts)
+	}
+	s.retryMutex.Lock()
+	failed := s.failed
+	s.failed = nil
+	s.retryMutex.Unlock()
+	return mergeCounts(failed, counts)
 }
 
 func (s *sessionTracker) publishCollectedSessions() {
-	s.sessionsMutex.Lock()
-	defer s.sessionsMutex.Unlock()
-
-	oldSessions := s.sessions
-	s.sessions = nil
-	if len(oldSessions) > 0 {
-		go func(s *sessionTracker) {
-			err := s.publisher.publish(oldSessions)
-			if err != nil {
-				s.config.logf("%v", err)
-			}
-		}(s)
+	s.retryMutex.Lock()
+	backingOff := s.config.now().Before(s.retryAt)
+	s.retryMutex.Unlock()
+	if backingOff {
+		// Sessions started meanwhile are kept in the store until the retry
+		return
+	}
+	if counts := s.takeCounts(); len(counts) > 0 {
+		go s.publish(counts)
 	}
 }
 
-func (s *sessionTracker) flushSessionsAndRepeatSignal(shutdown chan<- os.Signal, sig syscall.Signal) {
-	s.sessionsMutex.Lock()
-	defer s.sessionsMutex.Unlock()
+// publish sends the counts to the session server. If that fails because the
+// server is unavailable they are kept to be sent along with the next
+// sessions, after a backoff, until MaxPublishAttempts have failed.
+func (s *sessionTracker) publish(counts []SessionCount) {
+	err := s.publisher.publish(counts)
+	if err != nil {
+		s.config.sessionsFailed(err)
+	}
+	if err := s.keepToRetry(counts, err); err != nil {
+		s.config.sessionsFailed(err)
+	}
+}
 
+// keepToRetry keeps the counts to be sent again if the error is transient,
+// and returns an error if any counts had to be dropped instead.
+func (s *sessionTracker) keepToRetry(counts []SessionCount, err error) error {
+	s.retryMutex.Lock()
+	defer s.retryMutex.Unlock()
+	if _, transient := err.(*transientError); !transient {
+		s.attempts, s.retryAt = 0, time.Time{}
+		return nil
+	}
+	s.attempts++
+	if s.attempts >= MaxPublishAttempts {
+		attempts := s.attempts
+		s.attempts, s.retryAt = 0, time.Time{}
+		return fmt.Errorf("bugsnag/sessions: dropped %d session counts after %d attempts to send them", len(counts), attempts)
+	}
+	s.failed = mergeCounts(s.failed, counts)
+	s.retryAt = s.config.now().Add(s.backoff())
+	if dropped := len(s.failed) - MaxRetainedSessionCounts; dropped > 0 {
+		s.failed = s.failed[dropped:]
+		return fmt.Errorf("bugsnag/sessions: dropped the %d oldest session counts while the session server is unavailable", dropped)
+	}
+	return nil
+}
+
+// backoff is how long to wait before sending sessions again, which doubles
+// with each failed attempt and is jittered by up to half either way.
+// Callers must hold the retryMutex.
+func (s *sessionTracker) backoff() time.Duration {
+	doublings := s.attempts - 1
+	if doublings > 6 {
+		doublings = 6
+	}
+	delay := s.interval() << uint(doublings)
+	return time.Duration(float64(delay) * (0.5 + jitter()))
+}
+
+// mergeCounts combines counts which are for the same minute, so that
+// sessions kept to retry aren't sent twice, and sorts them oldest first.
+func mergeCounts(a, b []SessionCount) []SessionCount {
+	if len(a) == 0 {
+		return b
+	}
+	totals := make(map[time.Time]int, len(a)+len(b))
+	for _, count := range append(append([]SessionCount(nil), a...), b...) {
+		totals[count.StartedAt.UTC()] += count.Count
+	}
+	merged := make([]SessionCount, 0, len(totals))
+	for startedAt, count := range totals {
+		merged = append(merged, SessionCount{StartedAt: startedAt, Count: count})
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].StartedAt.Before(merged[j].StartedAt)
+	})
+	return merged
+}
+
+func (s *sessionTracker) flushSessionsAndRepeatSignal(shutdown chan<- os.Signal, sig syscall.Signal) {
 	signal.Stop(shutdown)
-	if len(s.sessions) > 0 {
-		err := s.publisher.publish(s.sessions)
-		if err != nil {
-			s.config.logf("%v", err)
-		}
+	if onShutdown := s.config.shutdownHook(); onShutdown != nil {
+		onShutdown()
+	} else {
+		s.FlushSessions()
 	}
 
 	if p, err := os.FindProcess(os.Getpid()); err != nil {
@@ -139,15 +313,8 @@ func (s *sessionTracker) flushSessionsAndRepeatSignal(shutdown chan<- os.Signal,
 }
 
 func (s *sessionTracker) FlushSessions() {
-	s.sessionsMutex.Lock()
-	defer s.sessionsMutex.Unlock()
-
-	sessions := s.sessions
-	s.sessions = nil
-	if len(sessions) != 0 {
-		if err := s.publisher.publish(sessions); err != nil {
-			s.config.logf("%v", err)
-		}
+	if counts := s.takeCounts(); len(counts) > 0 {
+		s.publish(counts)
 	}
 }
 
@@ -2,25 +2,33 @@ package sessions
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
 
 type testPublisher struct {
 	mutex            sync.Mutex
-	sessionsReceived [][]*Session
+	sessionsReceived [][]SessionCount
 }
 
 var pub = testPublisher{
 	mutex:            sync.Mutex{},
-	sessionsReceived: [][]*Session{},
+	sessionsReceived: [][]SessionCount{},
 }
 
-func (pub *testPublisher) publish(sessions []*Session) error {
+func (pub *testPublisher) publish(counts []SessionCount) error {
 	pub.mutex.Lock()
 	defer pub.mutex.Unlock()
-	pub.sessionsReceived = append(pub.sessionsReceived, sessions)
+	pub.sessionsReceived = append(pub.sessionsReceived, counts)
 	return nil
 }
 
@@ -55,18 +63,20 @@ func TestShouldOnlyWriteWhenReceivingSessions(t *testing.T) {
 	for i := 0; i < 50000; i++ {
 		st.StartSession(context.Background())
 	}
-	time.Sleep(time.Millisecond * 500) // wait for sessions to get consumed
+	time.Sleep(time.Millisecond * 500) // wait for sessions to get published
 
-	var sessions []*Session
+	sessions := 0
 	pub.mutex.Lock()
 	defer pub.mutex.Unlock()
-	for _, s := range pub.sessionsReceived {
-		for _, session := range s {
-			verifyValidSession(t, session)
-			sessions = append(sessions, session)
+	for _, counts := range pub.sessionsReceived {
+		for _, count := range counts {
+			if count.StartedAt.IsZero() {
+				t.Errorf("Expected start time to be set but was nil")
+			}
+			sessions += count.Count
 		}
 	}
-	if exp, got := 50000, len(sessions); exp != got {
+	if exp, got := 50000, sessions; exp != got {
 		t.Errorf("Expected %d sessions but got %d", exp, got)
 	}
 
@@ -79,7 +89,7 @@ func makeSessionTracker() (*sessionTracker, chan *Session) {
 			PublishInterval: time.Millisecond * 10, //Publish very fast
 		},
 		sessionChannel: c,
-		sessions:       []*Session{},
+		memory:         NewMemoryStore(),
 		publisher:      &pub,
 	}, c
 }
@@ -92,3 +102,185 @@ func verifyValidSession(t *testing.T, s *Session) {
 		t.Errorf("Expected UUID to be a valid V4 UUID but was %s", s.ID)
 	}
 }
+
+func TestStartSessionUsesConfiguredClock(t *testing.T) {
+	st, c := makeSessionTracker()
+	defer close(c)
+	startedAt := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+	st.config.Update(&SessionTrackingConfiguration{Clock: func() time.Time { return startedAt }})
+
+	ctx := st.StartSession(context.Background())
+	if got := IncrementEventCountAndGetSession(ctx, false).StartedAt; !got.Equal(startedAt) {
+		t.Errorf("Expected session to start at '%v' but was '%v'", startedAt, got)
+	}
+}
+
+type failingPublisher struct{}
+
+func (failingPublisher) publish(counts []SessionCount) error {
+	return fmt.Errorf("sessions rejected")
+}
+
+func TestFlushSessionsOnError(t *testing.T) {
+	var got error
+	st := &sessionTracker{
+		config: &SessionTrackingConfiguration{
+			Logger:  log.New(ioutil.Discard, "", 0),
+			OnError: func(err error) { got = err },
+		},
+		memory:    NewMemoryStore(),
+		publisher: failingPublisher{},
+	}
+	st.appendSession(newSession(time.Now()))
+	st.FlushSessions()
+	if got == nil || got.Error() != "sessions rejected" {
+		t.Errorf("Expected OnError to be called with the publishing error but got '%v'", got)
+	}
+}
+
+type flakyPublisher struct {
+	mutex    sync.Mutex
+	failures int
+	received [][]SessionCount
+}
+
+func (p *flakyPublisher) publish(counts []SessionCount) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.received = append(p.received, counts)
+	if p.failures > 0 {
+		p.failures--
+		return &transientError{fmt.Errorf("session server unavailable")}
+	}
+	return nil
+}
+
+func (p *flakyPublisher) calls() [][]SessionCount {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([][]SessionCount(nil), p.received...)
+}
+
+func TestRetriesTransientPublishFailures(t *testing.T) {
+	defer func(random func() float64) { jitter = random }(jitter)
+	jitter = func() float64 { return 0.5 }
+
+	start := time.Date(2020, time.March, 4, 5, 6, 0, 0, time.UTC)
+	now := start
+	var clockMutex sync.Mutex
+	clock := func() time.Time {
+		clockMutex.Lock()
+		defer clockMutex.Unlock()
+		return now
+	}
+	publisher := &flakyPublisher{failures: 1}
+	st := &sessionTracker{
+		config: &SessionTrackingConfiguration{
+			PublishInterval: time.Minute,
+			Clock:           clock,
+			Logger:          log.New(ioutil.Discard, "", 0),
+		},
+		memory:    NewMemoryStore(),
+		publisher: publisher,
+	}
+
+	st.StartSession(context.Background())
+	st.StartSession(context.Background())
+	st.FlushSessions()
+	if got := len(publisher.calls()); got != 1 {
+		t.Fatalf("Expected the sessions to be sent once but they were sent %d times", got)
+	}
+
+	st.StartSession(context.Background())
+	clockMutex.Lock()
+	now = start.Add(30 * time.Second)
+	clockMutex.Unlock()
+	st.publishCollectedSessions()
+	if got := len(publisher.calls()); got != 1 {
+		t.Fatalf("Expected no sessions to be sent while backing off but they were sent %d times", got)
+	}
+
+	clockMutex.Lock()
+	now = start.Add(time.Minute)
+	clockMutex.Unlock()
+	st.StartSession(context.Background())
+	st.publishCollectedSessions()
+	for deadline := time.Now().Add(time.Second); len(publisher.calls()) < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	calls := publisher.calls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected the sessions to be sent again after the backoff but they were sent %d times", len(calls))
+	}
+	exp := []SessionCount{{StartedAt: start, Count: 3}, {StartedAt: start.Add(time.Minute), Count: 1}}
+	if !reflect.DeepEqual(calls[1], exp) {
+		t.Errorf("Expected the retried counts to be merged with the new ones as '%v' but got '%v'", exp, calls[1])
+	}
+	st.retryMutex.Lock()
+	if len(st.failed) != 0 || st.attempts != 0 {
+		t.Errorf("Expected nothing to be kept to retry after sending succeeded but got '%v'", st.failed)
+	}
+	st.retryMutex.Unlock()
+}
+
+func TestRetainedSessionCountsAreBounded(t *testing.T) {
+	defer func(max int) { MaxRetainedSessionCounts = max }(MaxRetainedSessionCounts)
+	MaxRetainedSessionCounts = 1
+
+	var errs []error
+	st := &sessionTracker{
+		config: &SessionTrackingConfiguration{
+			PublishInterval: time.Minute,
+			Logger:          log.New(ioutil.Discard, "", 0),
+			OnError:         func(err error) { errs = append(errs, err) },
+		},
+		memory:    NewMemoryStore(),
+		publisher: &flakyPublisher{failures: 1},
+	}
+	start := time.Date(2020, time.March, 4, 5, 6, 0, 0, time.UTC)
+	st.appendSession(newSession(start))
+	st.appendSession(newSession(start.Add(time.Minute)))
+	st.FlushSessions()
+
+	if exp := []SessionCount{{StartedAt: start.Add(time.Minute), Count: 1}}; !reflect.DeepEqual(st.failed, exp) {
+		t.Errorf("Expected only the newest count to be kept to retry but got '%v'", st.failed)
+	}
+	if len(errs) != 2 || !strings.Contains(errs[1].Error(), "dropped the 1 oldest") {
+		t.Errorf("Expected OnError to be told about the dropped counts but got '%v'", errs)
+	}
+}
+
+func TestShutdownSignalCallsOnShutdown(t *testing.T) {
+	caught := make(chan os.Signal, 3)
+	signal.Notify(caught, syscall.SIGINT)
+	defer signal.Stop(caught)
+
+	calls := 0
+	st := &sessionTracker{
+		config: &SessionTrackingConfiguration{
+			Logger:     log.New(ioutil.Discard, "", 0),
+			OnShutdown: func() { calls++ },
+		},
+		memory:    NewMemoryStore(),
+		publisher: failingPublisher{},
+	}
+	st.appendSession(newSession(time.Now()))
+	st.flushSessionsAndRepeatSignal(shutdownSignals(), syscall.SIGINT)
+
+	if calls != 1 {
+		t.Errorf("Expected OnShutdown to be called once but it was called %d times", calls)
+	}
+	if counts, _ := st.memory.Flush(); len(counts) != 1 {
+		t.Errorf("Expected OnShutdown to be called instead of flushing the sessions")
+	}
+	select {
+	case <-caught:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the signal to be raised again")
+	}
+	select {
+	case <-caught:
+		t.Errorf("Expected the signal to be raised again only once")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
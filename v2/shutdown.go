@@ -0,0 +1,36 @@
+package bugsnag
+
+import (
+	"sync"
+	"time"
+)
+
+// ShutdownTimeout is the longest OnShutdown waits for deliveries which are
+// already in progress to finish.
+var ShutdownTimeout = 10 * time.Second
+
+var shutdownHandlerOnce sync.Once
+
+// OnShutdown sends any events and sessions which are waiting to be delivered,
+// and waits up to ShutdownTimeout for deliveries in progress to finish, so
+// that they aren't lost when the process exits. Call it from your own
+// handling of SIGTERM, e.g. when a container is stopped, or set
+// Configuration.InstallShutdownHandler to call it automatically. Events
+// notified afterwards are reported with the AppPhaseStopping phase.
+func OnShutdown() {
+	SetAppPhase(AppPhaseStopping)
+	Flush()
+	if !deliveries.drain(ShutdownTimeout) {
+		Config.logf("bugsnag.OnShutdown: gave up waiting for deliveries after %v", ShutdownTimeout)
+	}
+	FlushSessions()
+}
+
+// installShutdownHandler starts the session tracker, which flushes sessions
+// when the process receives SIGTERM or SIGINT and then raises the signal again
+// so that the process exits as it would have without the handler. With
+// InstallShutdownHandler set, the tracker calls OnShutdown instead, so the
+// signal is only raised again once.
+func installShutdownHandler() {
+	sessionTrackerOnce.Do(startSessionTracking)
+}
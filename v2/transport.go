@@ -0,0 +1,129 @@
+package bugsnag
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxDrainedBody is the most of a response which is read so that its
+// connection can be reused. Longer responses are closed instead.
+const maxDrainedBody = 4096
+
+// TransportConfig tunes the connections used to send events and sessions to
+// Bugsnag. Sending a high volume of events over a small pool of kept-alive
+// connections avoids repeating a TLS handshake for each event.
+type TransportConfig struct {
+	// MaxIdleConns is the most idle connections kept open in total.
+	// Defaults to DefaultTransportConfig.MaxIdleConns.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the most idle connections kept open to each of
+	// the Bugsnag endpoints. Defaults to
+	// DefaultTransportConfig.MaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept open. Defaults
+	// to DefaultTransportConfig.IdleConnTimeout.
+	IdleConnTimeout time.Duration
+	// ForceHTTP2 attempts HTTP/2 where the endpoint supports it, so that
+	// deliveries share a single multiplexed connection. Setting it to false
+	// stops HTTP/2 from being attempted, so HTTP/1.1 is used. Defaults to
+	// DefaultTransportConfig.ForceHTTP2, but is stored as an interface to
+	// enable us to detect when this option has not been set.
+	ForceHTTP2 interface{}
+	// DisableKeepAlives opens a new connection for each delivery.
+	DisableKeepAlives bool
+}
+
+// DefaultTransportConfig holds the defaults of the fields which aren't set in
+// Configuration.TransportConfig.
+var DefaultTransportConfig = TransportConfig{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 10,
+	IdleConnTimeout:     90 * time.Second,
+	ForceHTTP2:          true,
+}
+
+// transportSettings are the settings of a TransportConfig once the defaults
+// have been applied, which identify the Transport created for it.
+type transportSettings struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	forceHTTP2          bool
+	disableKeepAlives   bool
+}
+
+// transports holds the Transport created for each transportSettings, so that
+// merging the same TransportConfig again, e.g. as rawData to each Notify call
+// or with each UseProfile, keeps using the same pool of connections.
+var transports = struct {
+	mutex sync.Mutex
+	cache map[transportSettings]*http.Transport
+}{cache: make(map[transportSettings]*http.Transport)}
+
+// settings applies the DefaultTransportConfig to the fields which aren't set.
+func (c TransportConfig) settings() transportSettings {
+	settings := transportSettings{
+		maxIdleConns:        c.MaxIdleConns,
+		maxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		idleConnTimeout:     c.IdleConnTimeout,
+		disableKeepAlives:   c.DisableKeepAlives,
+	}
+	if settings.maxIdleConns == 0 {
+		settings.maxIdleConns = DefaultTransportConfig.MaxIdleConns
+	}
+	if settings.maxIdleConnsPerHost == 0 {
+		settings.maxIdleConnsPerHost = DefaultTransportConfig.MaxIdleConnsPerHost
+	}
+	if settings.idleConnTimeout == 0 {
+		settings.idleConnTimeout = DefaultTransportConfig.IdleConnTimeout
+	}
+	forceHTTP2 := c.ForceHTTP2
+	if forceHTTP2 == nil {
+		forceHTTP2 = DefaultTransportConfig.ForceHTTP2
+	}
+	settings.forceHTTP2, _ = forceHTTP2.(bool)
+	return settings
+}
+
+// transport returns the Transport for the settings of the TransportConfig,
+// creating it the first time they're used.
+func (c TransportConfig) transport() *http.Transport {
+	settings := c.settings()
+	transports.mutex.Lock()
+	defer transports.mutex.Unlock()
+	if t, ok := transports.cache[settings]; ok {
+		return t
+	}
+	t := settings.newTransport()
+	transports.cache[settings] = t
+	return t
+}
+
+// newTransport creates a Transport using the settings, with the same timeouts
+// and proxy handling as http.DefaultTransport.
+func (s transportSettings) newTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          s.maxIdleConns,
+		MaxIdleConnsPerHost:   s.maxIdleConnsPerHost,
+		IdleConnTimeout:       s.idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     s.forceHTTP2,
+		DisableKeepAlives:     s.disableKeepAlives,
+	}
+}
+
+// drainBody reads the rest of a short response so that its connection can
+// be reused for the next delivery.
+func drainBody(body io.Reader) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, maxDrainedBody))
+}
@@ -0,0 +1,109 @@
+package bugsnag
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTransportConfig(t *testing.T) {
+	config := &Configuration{}
+	config.update(&Configuration{TransportConfig: &TransportConfig{MaxIdleConnsPerHost: 4}})
+
+	transport, ok := config.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected a Transport to be created but it was %T", config.Transport)
+	}
+	if transport.MaxIdleConnsPerHost != 4 {
+		t.Errorf("Expected 4 idle connections per host but there were %d", transport.MaxIdleConnsPerHost)
+	}
+	if transport.MaxIdleConns != DefaultTransportConfig.MaxIdleConns {
+		t.Errorf("Expected the default of %d idle connections but there were %d", DefaultTransportConfig.MaxIdleConns, transport.MaxIdleConns)
+	}
+	if transport.IdleConnTimeout != DefaultTransportConfig.IdleConnTimeout {
+		t.Errorf("Expected the default idle timeout of %v but it was %v", DefaultTransportConfig.IdleConnTimeout, transport.IdleConnTimeout)
+	}
+	if transport.DisableKeepAlives || !transport.ForceAttemptHTTP2 {
+		t.Errorf("Expected keep-alives over HTTP/2 but DisableKeepAlives was %v and ForceAttemptHTTP2 was %v", transport.DisableKeepAlives, transport.ForceAttemptHTTP2)
+	}
+	config.update(&Configuration{TransportConfig: &TransportConfig{ForceHTTP2: false}})
+	if config.Transport.(*http.Transport).ForceAttemptHTTP2 {
+		t.Errorf("Expected HTTP/2 to be disabled when ForceHTTP2 is false")
+	}
+
+	config.update(&Configuration{TransportConfig: &TransportConfig{MaxIdleConnsPerHost: 4}})
+	if config.Transport != transport {
+		t.Errorf("Expected merging the same TransportConfig again to keep the same Transport")
+	}
+
+	custom := &http.Transport{}
+	config.update(&Configuration{Transport: custom, TransportConfig: &TransportConfig{MaxIdleConns: 1}})
+	if config.Transport != custom {
+		t.Errorf("Expected a custom Transport to take precedence over the TransportConfig")
+	}
+}
+
+func TestDeliverReusesConnections(t *testing.T) {
+	resetCircuitBreakers(t)
+
+	ts, connections := newConnectionCountingServer()
+	defer ts.Close()
+
+	transport := DefaultTransportConfig.settings().newTransport()
+	transport.TLSClientConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig
+	config := generateSampleConfig(ts.URL)
+	config.Transport = transport
+	event, c := newEvent([]interface{}{fmt.Errorf("reused")}, New(config))
+	for i := 0; i < 3; i++ {
+		if err := (&payload{event, c}).deliver(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt64(connections); got != 1 {
+		t.Errorf("Expected deliveries to share a connection but %d were opened", got)
+	}
+}
+
+// newConnectionCountingServer starts a server which counts the connections
+// opened to it, and responds to each request with a short body.
+func newConnectionCountingServer() (*httptest.Server, *int64) {
+	var connections int64
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&connections, 1)
+		}
+	}
+	ts.StartTLS()
+	return ts, &connections
+}
+
+func BenchmarkDeliverConnectionReuse(b *testing.B) {
+	for _, keepAlive := range []bool{true, false} {
+		b.Run(fmt.Sprintf("keepAlive=%v", keepAlive), func(b *testing.B) {
+			resetCircuitBreakers(b)
+			ts, connections := newConnectionCountingServer()
+			defer ts.Close()
+
+			transport := TransportConfig{DisableKeepAlives: !keepAlive}.settings().newTransport()
+			transport.TLSClientConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig
+			config := generateSampleConfig(ts.URL)
+			config.Transport = transport
+			event, c := newEvent([]interface{}{fmt.Errorf("benchmark")}, New(config))
+			p := &payload{event, c}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := p.deliver(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(connections))/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package bugsnag
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// validationErrorClass is the error class of events for errors created by
+// ValidationError.
+const validationErrorClass = "ValidationError"
+
+// validationTab is the metadata tab which the field errors of a
+// ValidationError are sent in.
+const validationTab = "validation"
+
+// validationError is a failed validation of one or more fields.
+type validationError struct {
+	fields map[string]string
+}
+
+// ValidationError creates an error for a failed validation, such as of a
+// request, from the error message of each field which failed. When it is
+// notified, the field errors are sent in the "validation" tab of a single
+// event, and events are grouped by the set of fields which failed rather
+// than by where they were notified.
+func ValidationError(fields map[string]string) error {
+	copied := make(map[string]string, len(fields))
+	for field, message := range fields {
+		copied[field] = message
+	}
+	return &validationError{fields: copied}
+}
+
+func (e *validationError) Error() string {
+	return fmt.Sprintf("validation failed for %s", strings.Join(e.fieldNames(), ", "))
+}
+
+// fieldNames returns the names of the fields which failed, in sorted order.
+func (e *validationError) fieldNames() []string {
+	names := make([]string, 0, len(e.fields))
+	for field := range e.fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// groupingHash identifies the set of fields which failed.
+func (e *validationError) groupingHash() string {
+	return validationErrorClass + ":" + strings.Join(e.fieldNames(), ",")
+}
+
+// findValidationError returns the validationError which err is or wraps.
+func findValidationError(err error) (*validationError, bool) {
+	for err != nil {
+		if v, ok := err.(*validationError); ok {
+			return v, true
+		}
+		err = unwrapError(err)
+	}
+	return nil, false
+}
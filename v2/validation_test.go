@@ -0,0 +1,49 @@
+package bugsnag
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidationErrorGrouping(t *testing.T) {
+	notifier := New(Configuration{APIKey: testAPIKey})
+	notify := func(err error) *Event {
+		event, config := newEvent([]interface{}{err}, notifier)
+		if err := validationMiddleware(event, config); err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+
+	first := notify(ValidationError(map[string]string{"email": "is invalid", "name": "is required", "age": "must be positive"}))
+	if first.GroupingHash != "ValidationError:age,email,name" {
+		t.Errorf("Expected the event to be grouped by its sorted fields but the grouping hash was '%s'", first.GroupingHash)
+	}
+	if first.ErrorClass != validationErrorClass {
+		t.Errorf("Expected the error class to be '%s' but was '%s'", validationErrorClass, first.ErrorClass)
+	}
+	if got := first.MetaData[validationTab]["email"]; got != "is invalid" {
+		t.Errorf("Expected the field errors in the validation tab but the email error was '%v'", got)
+	}
+
+	// Maps are iterated in a random order, so build the same set of fields
+	// repeatedly in a different order
+	for i := 0; i < 20; i++ {
+		fields := map[string]string{}
+		for _, field := range []string{"name", "age", "email"}[i%3:] {
+			fields[field] = "failed"
+		}
+		for _, field := range []string{"name", "age", "email"}[:i%3] {
+			fields[field] = "failed"
+		}
+		wrapped := fmt.Errorf("creating user: %w", ValidationError(fields))
+		if got := notify(wrapped).GroupingHash; got != first.GroupingHash {
+			t.Fatalf("Expected the grouping hash to be '%s' regardless of order but was '%s'", first.GroupingHash, got)
+		}
+	}
+
+	other := notify(ValidationError(map[string]string{"email": "is invalid"}))
+	if other.GroupingHash == first.GroupingHash {
+		t.Errorf("Expected events with different failing fields to be grouped separately")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build go1.18
+// +build go1.18
+
+package bugsnag
+
+import "runtime/debug"
+
+// vcsRevision returns the revision of the repository the application was
+// built from, as recorded by the go command.
+func vcsRevision(info *debug.BuildInfo) string {
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,12 @@
+//go:build !go1.18
+// +build !go1.18
+
+package bugsnag
+
+import "runtime/debug"
+
+// vcsRevision returns an empty string, as VCS information isn't recorded in
+// the build info before Go 1.18.
+func vcsRevision(info *debug.BuildInfo) string {
+	return ""
+}